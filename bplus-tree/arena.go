@@ -0,0 +1,220 @@
+package bplustree
+
+import (
+	"bytes"
+
+	"storage-engine/layout"
+)
+
+// Leaves own the bytes of their keys and values. Every write copies them into
+// a slab owned by the leaf, so callers are free to reuse their buffers, and a
+// leaf makes one allocation for many entries instead of two per entry.
+//
+// Slabs are append-only: when one fills up, the leaf's live entries move to a
+// new slab and the old one is left to the garbage collector, taking the bytes
+// of overwritten and deleted entries with it.
+//
+// The prefix shared by every key of a leaf is stored once: n.prefix holds it
+// and n.key only the rest of each key. The prefix is picked again every time
+// the leaf moves to a new slab, and cut short as soon as a key that doesn't
+// start with it comes in.
+const (
+	// minSlabSize is the smallest slab a leaf allocates.
+	minSlabSize = 512
+	// maxSlabEntry is the size above which a key or value gets an allocation
+	// of its own instead of a place in the slab.
+	maxSlabEntry = 1024
+)
+
+// store copies b into the leaf's slab and returns the copy. nil stays nil.
+func (n *Node) store(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	if len(b) > maxSlabEntry {
+		return bytes.Clone(b)
+	}
+
+	if cap(n.arena)-len(n.arena) < len(b) {
+		n.compact(len(b))
+	}
+	start := len(n.arena)
+	n.arena = append(n.arena, b...)
+	// cap the copy, so appending to it can't run into the next entry
+	return n.arena[start:len(n.arena):len(n.arena)]
+}
+
+// storeEntry copies a new entry into the leaf's slab and returns the copies,
+// the key without the leaf's prefix. It doesn't add them to the leaf.
+func (n *Node) storeEntry(key, value []byte) ([]byte, []byte) {
+	// a key too big for the slab can leave a suffix that isn't, count it whole
+	need := len(key) + slabSize(value)
+	if !bytes.HasPrefix(key, n.prefix) || cap(n.arena)-len(n.arena) < need {
+		n.repack(n.sharedPrefix(key), need)
+	}
+	return n.store(key[len(n.prefix):]), n.store(value)
+}
+
+// compact moves the leaf's entries to a new slab with room for at least need
+// more bytes.
+func (n *Node) compact(need int) {
+	n.repack(n.sharedPrefix(), need)
+}
+
+// repack moves the leaf's entries to a new slab with room for at least need
+// more bytes, and their keys onto prefix, which every key must start with.
+func (n *Node) repack(prefix []byte, need int) {
+	old := n.prefix
+	live := slabSize(prefix)
+	for i := range n.key {
+		if size := len(old) + len(n.key[i]) - len(prefix); size <= maxSlabEntry {
+			live += size
+		}
+		live += slabSize(n.value[i])
+	}
+
+	n.arena = make([]byte, 0, max(minSlabSize, 2*(live+need)))
+	n.prefix = n.moveToSlab(prefix)
+	for i, k := range n.key {
+		if len(prefix) <= len(old) {
+			n.key[i] = n.moveToSlab(old[len(prefix):], k)
+		} else {
+			n.key[i] = n.moveToSlab(k[len(prefix)-len(old):])
+		}
+		n.value[i] = n.moveToSlab(n.value[i])
+	}
+}
+
+// moveToSlab copies the concatenation of parts into the slab, which must have
+// room for it.
+func (n *Node) moveToSlab(parts ...[]byte) []byte {
+	size := 0
+	for _, p := range parts {
+		size += len(p)
+	}
+	if size > maxSlabEntry {
+		if len(parts) == 1 {
+			return parts[0]
+		}
+		return bytes.Join(parts, nil)
+	}
+	if len(parts) == 1 && parts[0] == nil {
+		return nil
+	}
+
+	start := len(n.arena)
+	for _, p := range parts {
+		n.arena = append(n.arena, p...)
+	}
+	return n.arena[start:len(n.arena):len(n.arena)]
+}
+
+func slabSize(b []byte) int {
+	if len(b) > maxSlabEntry {
+		return 0
+	}
+	return len(b)
+}
+
+// sharedPrefix returns the longest prefix of every key in the leaf and every
+// key in more. An empty leaf has none.
+func (n *Node) sharedPrefix(more ...[]byte) []byte {
+	if len(n.key) == 0 {
+		return nil
+	}
+
+	first, last := n.key[0], n.key[len(n.key)-1]
+	prefix := append(bytes.Clone(n.prefix), first[:layout.CommonPrefixLen(first, last)]...)
+	for _, k := range more {
+		prefix = prefix[:layout.CommonPrefixLen(prefix, k)]
+	}
+	return prefix
+}
+
+// adopt moves keys, the rest of keys after prefix in another leaf, to the leaf
+// n and returns them relative to the prefix of n. The caller adds them to n.
+func (n *Node) adopt(prefix []byte, keys [][]byte) [][]byte {
+	if bytes.Equal(prefix, n.prefix) || len(keys) == 0 {
+		return keys
+	}
+
+	full := make([][]byte, len(keys))
+	need := 0
+	for i, k := range keys {
+		full[i] = append(prefix[:len(prefix):len(prefix)], k...)
+		need += len(full[i])
+	}
+	shared := n.sharedPrefix(full[0], full[len(full)-1])
+	if len(shared) < len(n.prefix) || cap(n.arena)-len(n.arena) < need {
+		n.repack(shared, need)
+	}
+	for i, k := range full {
+		full[i] = n.store(k[len(n.prefix):])
+	}
+	return full
+}
+
+// leafKey returns the whole key at i of the leaf. It only allocates if the
+// leaf has a prefix.
+func (n *Node) leafKey(i int) []byte {
+	if len(n.prefix) == 0 {
+		return n.key[i]
+	}
+	return n.appendKey(make([]byte, 0, len(n.prefix)+len(n.key[i])), i)
+}
+
+// appendKey appends the whole key at i of the leaf to dst.
+func (n *Node) appendKey(dst []byte, i int) []byte {
+	return append(append(dst, n.prefix...), n.key[i]...)
+}
+
+// compareKey compares the key at i of the leaf with key.
+func (n *Node) compareKey(i int, key []byte) int {
+	if !bytes.HasPrefix(key, n.prefix) {
+		// the prefix alone decides
+		return bytes.Compare(n.prefix, key)
+	}
+	return bytes.Compare(n.key[i], key[len(n.prefix):])
+}
+
+// keyEqual reports whether the key at i of the leaf is key.
+func (n *Node) keyEqual(i int, key []byte) bool {
+	return len(key) == len(n.prefix)+len(n.key[i]) &&
+		bytes.HasPrefix(key, n.prefix) && bytes.Equal(key[len(n.prefix):], n.key[i])
+}
+
+// lowerBound returns the index of the first key in the leaf >= key, or
+// len(n.key) if there is none.
+func (n *Node) lowerBound(key []byte) int {
+	if !bytes.HasPrefix(key, n.prefix) {
+		return n.outside(key)
+	}
+	return lowerBound(n.key, key[len(n.prefix):])
+}
+
+// upperBound returns the index of the first key in the leaf > key, or
+// len(n.key) if there is none.
+func (n *Node) upperBound(key []byte) int {
+	if !bytes.HasPrefix(key, n.prefix) {
+		return n.outside(key)
+	}
+	return upperBound(n.key, key[len(n.prefix):])
+}
+
+// outside is the bound of a key that doesn't start with the leaf's prefix,
+// so it sorts before or after every key in the leaf.
+func (n *Node) outside(key []byte) int {
+	if bytes.Compare(key, n.prefix) < 0 {
+		return 0
+	}
+	return len(n.key)
+}
+
+// newLeaf returns a leaf holding a copy of key and value.
+func newLeaf(key, value []byte) *Node {
+	n := &Node{}
+	k, v := n.storeEntry(key, value)
+	n.key = append(n.key, k)
+	n.value = append(n.value, v)
+	return n
+}
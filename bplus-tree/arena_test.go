@@ -0,0 +1,181 @@
+package bplustree
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInsert_CopiesInputs(t *testing.T) {
+	b := New(2)
+
+	// one buffer reused for every key and value
+	buf := make([]byte, 0, 16)
+	for i := range 50 {
+		buf = fmt.Appendf(buf[:0], "key%03d", i)
+		assert.NoError(t, b.Insert(buf, buf))
+	}
+	buf = append(buf[:0], "garbage"...)
+
+	for i := range 50 {
+		want := []byte(fmt.Sprintf("key%03d", i))
+		v, err := b.Get(want)
+		assert.NoError(t, err)
+		assert.Equal(t, want, v)
+	}
+}
+
+func TestGet_ReturnsCopy(t *testing.T) {
+	b := New(3)
+	b.Insert([]byte("k"), []byte("value"))
+
+	v, _ := b.Get([]byte("k"))
+	v[0] = 'X'
+
+	v, _ = b.Get([]byte("k"))
+	assert.Equal(t, []byte("value"), v)
+
+	all, _ := b.GetAll([]byte("k"))
+	all[0][0] = 'X'
+	v, _ = b.Get([]byte("k"))
+	assert.Equal(t, []byte("value"), v)
+
+	prev, _, _ := b.Swap([]byte("k"), []byte("other"))
+	prev[0] = 'X'
+	v, _ = b.Get([]byte("k"))
+	assert.Equal(t, []byte("other"), v)
+}
+
+func TestUpdate_CopiesInputs(t *testing.T) {
+	b := New(3)
+	buf := []byte("first")
+	b.Update([]byte("k"), func(old []byte, exists bool) ([]byte, bool) {
+		return buf, false
+	})
+	copy(buf, "XXXXX")
+
+	v, _ := b.Get([]byte("k"))
+	assert.Equal(t, []byte("first"), v)
+}
+
+func TestArena_Overwrites(t *testing.T) {
+	b := New(3)
+	for i := range 1000 {
+		value := bytes.Repeat([]byte{byte(i)}, 1+i%40)
+		assert.NoError(t, b.Insert([]byte("k"), value))
+
+		v, err := b.Get([]byte("k"))
+		assert.NoError(t, err)
+		assert.Equal(t, value, v)
+	}
+
+	// overwritten values are dropped when the slab is compacted
+	leaf := b.leftmostLeaf()
+	assert.LessOrEqual(t, cap(leaf.arena), minSlabSize)
+}
+
+func TestArena_ViewsAreCapped(t *testing.T) {
+	b := New(2)
+	for i := range 20 {
+		b.InsertInt(i, []byte(fmt.Sprint(i)))
+	}
+
+	it := b.NewIter(nil)
+	it.First()
+	key, value := it.Key(), it.Value()
+
+	// appending to a view must not run into the entry after it
+	_ = append(key, 0xff)
+	_ = append(value, 'x')
+	v, _ := b.GetInt(1)
+	assert.Equal(t, []byte("1"), v)
+	assert.True(t, it.Next())
+	assert.Equal(t, 1, convertBytetoInt(it.Key()))
+}
+
+func TestArena_LargeValues(t *testing.T) {
+	b := New(3)
+	big := bytes.Repeat([]byte("v"), 4*maxSlabEntry)
+	b.Insert([]byte("big"), big)
+	b.Insert([]byte("small"), []byte("v"))
+	big[0] = 'X'
+
+	v, _ := b.Get([]byte("big"))
+	assert.Equal(t, byte('v'), v[0])
+	assert.Len(t, v, 4*maxSlabEntry)
+	assert.Less(t, cap(b.leftmostLeaf().arena), maxSlabEntry)
+}
+
+func TestArena_LeafPrefix(t *testing.T) {
+	b := New(8)
+	for i := range 200 {
+		k := fmt.Sprintf("tenants/acme/users/%04d", i)
+		assert.NoError(t, b.Insert([]byte(k), []byte("v")))
+	}
+
+	// once their slab is rebuilt, leaves hold the shared part of their keys
+	// once
+	shared := 0
+	for n := b.leftmostLeaf(); n != nil; n = n.next {
+		if len(n.prefix) >= len("tenants/acme/users/") {
+			shared++
+			for _, k := range n.key {
+				assert.LessOrEqual(t, len(k), 4)
+			}
+		}
+	}
+	assert.Greater(t, shared, 0)
+
+	// keys that don't start with the prefix cut it short
+	for _, k := range []string{"a", "tenants/acme/users/0100/x", "tenants/b", "tenants/acme", "z"} {
+		assert.NoError(t, b.Insert([]byte(k), []byte(k)))
+	}
+	for _, k := range []string{"a", "tenants/acme/users/0100/x", "tenants/b", "tenants/acme", "z", "tenants/acme/users/0007"} {
+		_, err := b.Get([]byte(k))
+		assert.NoError(t, err, k)
+	}
+	_, err := b.Get([]byte("tenants/acme/users/01"))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	it := b.NewIter(&IterOptions{LowerBound: []byte("tenants/acme/users/0198")})
+	assert.True(t, it.First())
+	assert.Equal(t, "tenants/acme/users/0198", string(it.Key()))
+	first := it.Key()
+	assert.True(t, it.Next())
+	assert.Equal(t, "tenants/acme/users/0199", string(it.Key()))
+	// rebuilt keys stay valid while the iterator moves on
+	assert.Equal(t, "tenants/acme/users/0198", string(first))
+	assert.True(t, b.Verify().OK(), b.Verify())
+}
+
+func TestArena_LeafPrefixRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	prefixes := []string{"", "a/", "a/b/", "a/b/c/", "a/c/", string(bytes.Repeat([]byte("p"), maxSlabEntry))}
+
+	for _, order := range []int{2, 3, 8} {
+		b := New(order)
+		want := map[string]string{}
+		for i := range 3000 {
+			k := prefixes[r.Intn(len(prefixes))] + fmt.Sprint(r.Intn(300))
+			if _, ok := want[k]; ok && r.Intn(2) == 0 {
+				assert.NoError(t, b.Delete([]byte(k)))
+				delete(want, k)
+				continue
+			}
+			v := fmt.Sprint(i)
+			assert.NoError(t, b.Insert([]byte(k), []byte(v)))
+			want[k] = v
+		}
+
+		assert.Equal(t, want, contents(b), "order %d", order)
+		for k, v := range want {
+			got, err := b.Get([]byte(k))
+			assert.NoError(t, err)
+			assert.Equal(t, v, string(got))
+		}
+		assert.True(t, b.Verify().OK(), "order %d: %s", order, b.Verify())
+	}
+}
@@ -2,15 +2,28 @@ package bplustree
 
 import (
 	"bytes"
-	"encoding/binary"
+	"errors"
 	"fmt"
 
 	"storage-engine/common"
+	"storage-engine/keyenc"
 )
 
 type BTree struct {
 	root  *Node
 	order int
+
+	dups    DupOrder      // DupNone unless the tree is a multimap
+	mergeOp MergeOperator // nil unless Merge is enabled
+	clock   Clock         // nil unless TTLs are enabled
+	indexes []*index      // secondary indexes, see RegisterIndex
+
+	corruptionErrors bool             // see WithCorruptionErrors
+	poisoned         *CorruptionError // set once a corruption error was returned
+	intKeysMigrated  bool             // set by MigrateLegacyIntKeys
+
+	shared bool   // see WithStructuralSharing
+	edit   uint64 // token of the nodes a transient may change in place, 0 in a version
 }
 
 type Node struct {
@@ -21,49 +34,87 @@ type Node struct {
 	// maintain a doubly linked list
 	next *Node // only if node is leaf node
 	prev *Node
+
+	prefix []byte // only if node is leaf node, shared by all its keys, see arena.go
+	arena  []byte // only if node is leaf node, holds its keys and values
+
+	edit uint64 // token of the transient that created the node, see own
 }
 
 func (n *Node) IsLeaf() bool {
 	return len(n.children) == 0
 }
 
-func New(order int) *BTree {
+// Option configures optional behaviour of a tree created by New.
+type Option func(*BTree)
+
+func New(order int, opts ...Option) *BTree {
 	common.Assert(order > 0, "order must be positive, got %d", order)
-	return &BTree{order: order}
+	b := &BTree{order: order}
+	for _, opt := range opts {
+		opt(b)
+	}
+	common.Assert(b.dups == DupNone || !b.usesCells(),
+		"multimap trees don't support merge operators or TTLs")
+	common.Assert(!b.shared || (b.dups == DupNone && !b.usesCells()),
+		"persistent trees don't support multimaps, merge operators or TTLs")
+	return b
 }
 
+// Insert stores a copy of key and value, so the caller can reuse both
+// buffers afterwards.
 func (b *BTree) Insert(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	return b.insert(key, b.encodeValue(value))
+}
+
+// insert stores value, already encoded by encodeValue, under key.
+func (b *BTree) insert(key []byte, value []byte) (err error) {
+	if err := b.writable(); err != nil {
+		return err
+	}
+	defer b.recoverCorruption(&err)
+
 	if b.root == nil {
-		root := &Node{
-			key:      make([][]byte, 0),
-			value:    make([][]byte, 0),
-			children: make([]*Node, 0),
+		changes, err := b.indexChanges(key, nil, false, value, true)
+		if err != nil {
+			return err
 		}
 
-		root.key = append(root.key, key)
-		root.value = append(root.value, value)
-
-		b.root = root
+		b.root = newLeaf(key, value)
+		b.root.edit = b.edit
+		b.applyIndexChanges(key, changes)
 
 		return nil
 	}
 
-	curr := b.root
-	path := make([]*Node, 0)
-
-	for curr != nil && !curr.IsLeaf() {
-		path = append(path, curr)
-		curr = b.traverseRightOrLeft(curr, key)
+	if b.dups != DupNone {
+		return b.insertDuplicate(key, value)
 	}
 
+	curr, path := b.pathToLeaf(key)
+
 	kvInsertionIndex := b.findKeyIndexInNode(curr, key)
 	if kvInsertionIndex == -1 {
-		return fmt.Errorf("failed to insert key")
+		return common.Corruptf("no leaf found for key")
+	}
+
+	exists := len(curr.key) > kvInsertionIndex && curr.keyEqual(kvInsertionIndex, key)
+
+	var old []byte
+	if exists {
+		old = curr.value[kvInsertionIndex]
+	}
+	changes, err := b.indexChanges(key, old, exists, value, true)
+	if err != nil {
+		return err
 	}
 
-	if len(curr.key) > kvInsertionIndex && bytes.Equal(curr.key[kvInsertionIndex], key) {
+	if exists {
 		// key exists, update the value
-		curr.value[kvInsertionIndex] = value
+		curr.value[kvInsertionIndex] = curr.store(value)
 	} else {
 		// append the key value to the insertion index
 		b.insertKVInLeafInPlace(curr, key, value, kvInsertionIndex)
@@ -71,15 +122,33 @@ func (b *BTree) Insert(key []byte, value []byte) error {
 		if b.checkMaxKeys(len(curr.key)) {
 			// if max keys, split (recursive process till parent is also not overflowed with keys)
 			_, _ = b.splitNode(curr, path)
-			return nil
 		}
 	}
+	// only once the leaf is changed: a failed assertion above unwinds past
+	// this, so the indexes never list an entry the tree doesn't have. The
+	// reverse can happen, a split failing after the entry went into the
+	// leaf, but the tree is poisoned then and takes no more writes
+	b.applyIndexChanges(key, changes)
 	return nil
 }
 
-func (b *BTree) Get(key []byte) ([]byte, error) {
+// Get returns a copy of the value stored for key, which the caller owns.
+func (b *BTree) Get(key []byte) (value []byte, err error) {
+	if len(key) == 0 {
+		return nil, ErrEmptyKey
+	}
+	defer b.recoverCorruption(&err)
+
 	if b.root == nil {
-		return nil, fmt.Errorf("tree is empty")
+		return nil, ErrNotFound
+	}
+
+	if b.dups != DupNone {
+		n, idx, _, found := b.findEntry(key, nil)
+		if !found {
+			return nil, ErrNotFound
+		}
+		return bytes.Clone(n.value[idx]), nil
 	}
 
 	n := b.root
@@ -91,44 +160,74 @@ func (b *BTree) Get(key []byte) ([]byte, error) {
 	idx, err := b.findEqualKeyIndexInNode(n, key)
 
 	if err != nil {
-		return nil, fmt.Errorf("no key found")
+		return nil, ErrNotFound
 	}
 
-	return n.value[idx], nil
+	v, err := b.readLiveValue(key, n.value[idx])
+	if errors.Is(err, errExpired) {
+		return nil, ErrNotFound
+	}
+	return bytes.Clone(v), err
 }
 
-func (b *BTree) Delete(key []byte) error {
-	if b.root == nil {
-		return fmt.Errorf("tree is empty")
+func (b *BTree) Delete(key []byte) (err error) {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	if err := b.writable(); err != nil {
+		return err
 	}
+	defer b.recoverCorruption(&err)
 
-	curr := b.root
-	path := make([]*Node, 0)
+	if b.root == nil {
+		return ErrNotFound
+	}
 
-	for curr != nil && !curr.IsLeaf() {
-		path = append(path, curr)
-		curr = b.traverseRightOrLeft(curr, key)
+	if b.dups != DupNone {
+		return b.deleteAll(key)
 	}
+
+	curr, path := b.pathToLeaf(key)
 	if curr == nil {
-		return fmt.Errorf("could not find key")
+		return common.Corruptf("no leaf found for key")
 	}
 
 	deleteIdx, err := b.findEqualKeyIndexInNode(curr, key)
 	if err != nil {
-		return fmt.Errorf("no equal key index found")
+		return ErrNotFound
 	}
 
-	curr.key = append(curr.key[:deleteIdx], curr.key[deleteIdx+1:]...)
-	curr.value = append(curr.value[:deleteIdx], curr.value[deleteIdx+1:]...)
+	// an expired entry is still removed, but to the caller it wasn't there
+	expired := b.rawExpired(curr.value[deleteIdx])
 
-	// check if the leaf node is underflowed
-	if !b.checkMinKeys(len(curr.key)) {
-		_ = b.handleNodeUnderflow(curr, path)
+	changes, err := b.indexChanges(key, curr.value[deleteIdx], true, nil, false)
+	if err != nil {
+		return err
+	}
+
+	b.deleteFromLeaf(curr, deleteIdx, path)
+	b.applyIndexChanges(key, changes)
+	if expired {
+		return ErrNotFound
 	}
 	return nil
 }
 
-// Convenience helpers that encode integer keys using fixed-width big-endian
+// deleteFromLeaf removes the KV at idx from leaf and rebalances the tree.
+// path holds the ancestors of leaf, root first.
+func (b *BTree) deleteFromLeaf(leaf *Node, idx int, path []*Node) {
+	leaf.key = append(leaf.key[:idx], leaf.key[idx+1:]...)
+	leaf.value = append(leaf.value[:idx], leaf.value[idx+1:]...)
+
+	// check if the leaf node is underflowed
+	if !b.checkMinKeys(len(leaf.key)) {
+		b.handleNodeUnderflow(leaf, path)
+	}
+}
+
+// Convenience helpers that encode integer keys with keyenc.EncodeInt64, so
+// negative keys sort before positive ones. Trees filled by older versions of
+// these helpers can be converted with MigrateLegacyIntKeys.
 func (b *BTree) InsertInt(k int, value []byte) error {
 	return b.Insert(convertIntToByte(k), value)
 }
@@ -141,7 +240,59 @@ func (b *BTree) DeleteInt(k int) error {
 	return b.Delete(convertIntToByte(k))
 }
 
-func (b *BTree) handleNodeUnderflow(node *Node, path []*Node) error {
+// MigrateLegacyIntKeys re-encodes every 8 byte key from the old InsertInt
+// encoding (a plain uint64 cast) to the current one and returns how many keys
+// were rewritten. Keys of any other length are left alone, so it must only be
+// run on trees whose 8 byte keys all came from the old InsertInt. The tree is
+// only changed if every key could be migrated, and it remembers that it was:
+// running it again does nothing.
+func (b *BTree) MigrateLegacyIntKeys() (int, error) {
+	if err := b.writable(); err != nil {
+		return 0, err
+	}
+	if b.intKeysMigrated {
+		return 0, nil
+	}
+
+	// the migrated entries go to a tree of their own with the same options,
+	// and the indexes are filled again, since they point at the old keys
+	fresh := *b
+	fresh.root, fresh.poisoned = nil, nil
+	fresh.indexes = make([]*index, 0, len(b.indexes))
+	for _, ix := range b.indexes {
+		fresh.indexes = append(fresh.indexes, &index{
+			name:    ix.name,
+			extract: ix.extract,
+			tree:    New(b.order, WithDuplicates(DupValueOrder)),
+		})
+	}
+
+	// the stored values are copied as they are, so TTLs and merge operands
+	// survive
+	migrated := 0
+	for n := b.leftmostLeaf(); n != nil; n = b.leafAfter(n) {
+		for i := range n.key {
+			k := n.leafKey(i)
+			if len(k) == 8 {
+				v, err := keyenc.DecodeLegacyInt(k)
+				if err != nil {
+					return 0, err
+				}
+				k = convertIntToByte(v)
+				migrated++
+			}
+			if err := fresh.insert(k, n.value[i]); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	b.root, b.indexes = fresh.root, fresh.indexes
+	b.intKeysMigrated = true
+	return migrated, nil
+}
+
+func (b *BTree) handleNodeUnderflow(node *Node, path []*Node) {
 	common.Assert(node != nil, "handleNodeUnderflow called with nil node")
 
 	var parent *Node
@@ -156,15 +307,12 @@ func (b *BTree) handleNodeUnderflow(node *Node, path []*Node) error {
 				len(node.children))
 			b.root = node.children[0]
 		}
-		return nil
+		return
 	}
 
 	currChildNodeIndex := b.getChildIndexFromParentChildren(parent, node)
 	common.Assert(currChildNodeIndex >= 0,
 		"node not found in parent's children during underflow handling")
-	if currChildNodeIndex < 0 {
-		return fmt.Errorf("could not find key: invalid currChildIndex")
-	}
 
 	var leftSibling *Node
 	var rightSibling *Node
@@ -175,15 +323,17 @@ func (b *BTree) handleNodeUnderflow(node *Node, path []*Node) error {
 	if currChildNodeIndex < len(parent.children)-1 {
 		rightSibling = parent.children[currChildNodeIndex+1]
 	}
-
-	// try borrowing from siblings
+	// try borrowing from siblings. Either way the sibling changes too, so a
+	// transient has to own it first.
 	if leftSibling != nil && b.checkMinKeys(len(leftSibling.key)) {
+		leftSibling = b.ownChild(parent, leftSibling)
 		if !node.IsLeaf() {
 			node = b.borrowKeyFromINode(leftSibling, node, parent, true)
 		} else {
 			node = b.borrowKeyFromLeafNode(leftSibling, node, true, parent, currChildNodeIndex)
 		}
 	} else if rightSibling != nil && b.checkMinKeys(len(rightSibling.key)) {
+		rightSibling = b.ownChild(parent, rightSibling)
 		if !node.IsLeaf() {
 			node = b.borrowKeyFromINode(rightSibling, node, parent, false)
 		} else {
@@ -191,16 +341,21 @@ func (b *BTree) handleNodeUnderflow(node *Node, path []*Node) error {
 		}
 	} else {
 		// not able to borrow; merge
+		var merged *Node
 		if leftSibling != nil {
+			leftSibling = b.ownChild(parent, leftSibling)
 			separatorKeyIdxToRemove := currChildNodeIndex - 1
 			separatorKey := parent.key[separatorKeyIdxToRemove]
 			leftSibling = b.mergeNodes(node, leftSibling, true, separatorKey)
+			merged = leftSibling
 			parent.key = append(parent.key[:separatorKeyIdxToRemove], parent.key[separatorKeyIdxToRemove+1:]...)
 		} else {
+			rightSibling = b.ownChild(parent, rightSibling)
 			separatorKeyIdxToRemove := currChildNodeIndex
 			separatorKey := parent.key[separatorKeyIdxToRemove]
 			parent.key = append(parent.key[:separatorKeyIdxToRemove], parent.key[separatorKeyIdxToRemove+1:]...)
 			rightSibling = b.mergeNodes(node, rightSibling, false, separatorKey)
+			merged = rightSibling
 		}
 		// after merging nodes, only one node is required. we do not require the other child which was the src
 		parent.children = append(parent.children[:currChildNodeIndex], parent.children[currChildNodeIndex+1:]...)
@@ -210,19 +365,22 @@ func (b *BTree) handleNodeUnderflow(node *Node, path []*Node) error {
 		if len(parent.children) > 0 && parent.children[0].IsLeaf() {
 			for i := 0; i < len(parent.key); i++ {
 				if i+1 < len(parent.children) && len(parent.children[i+1].key) > 0 {
-					// parent.key[i] = first key of parent.children[i+1]
-					parent.key[i] = parent.children[i+1].key[0]
+					parent.key[i] = leafSeparator(parent.children[i], parent.children[i+1])
 				}
 			}
 		}
+
+		// two internal nodes of up to order keys plus the separator pulled
+		// down from the parent can be one key too many, split them again
+		if b.checkMaxKeys(len(merged.key)) {
+			_, _ = b.splitNode(merged, path)
+		}
 	}
 
 	if !b.checkMinKeys(len(parent.key)) {
 		// check underflow for internal nodes
-		_ = b.handleNodeUnderflow(parent, path[:len(path)-1])
+		b.handleNodeUnderflow(parent, path[:len(path)-1])
 	}
-
-	return nil
 }
 
 // dst is the node where the merge happens i.e. the node which satisfies the min keys criteria
@@ -244,7 +402,8 @@ func (b *BTree) mergeNodes(src, dst *Node, mergeWithLeft bool, separatorKey []by
 			dst.children = append(dst.children, src.children...)
 		} else {
 			// For leaf nodes: just concatenate (separator is copy-up, not stored)
-			dst.key = append(dst.key, src.key...)
+			keys := dst.adopt(src.prefix, src.key)
+			dst.key = append(dst.key, keys...)
 			dst.value = append(dst.value, src.value...)
 			// Update the next pointer: dst now points to what src pointed to
 			dst.next = src.next
@@ -269,7 +428,8 @@ func (b *BTree) mergeNodes(src, dst *Node, mergeWithLeft bool, separatorKey []by
 			dst.children = append(src.children, dst.children...)
 		} else {
 			// For leaf nodes: just concatenate
-			dst.key = append(src.key, dst.key...)
+			keys := dst.adopt(src.prefix, src.key)
+			dst.key = append(keys, dst.key...)
 			dst.value = append(src.value, dst.value...)
 
 			if src.prev != nil {
@@ -296,7 +456,7 @@ func (b *BTree) borrowKeyFromLeafNode(src, dst *Node, borrowFromLeft bool, paren
 	// borrow from the left sibling i.e. get the rightmost key
 	if borrowFromLeft {
 		lastIdx := len(src.key) - 1
-		lastKey := src.key[lastIdx]
+		lastKey := dst.adopt(src.prefix, src.key[lastIdx:])[0]
 		lastVal := src.value[lastIdx]
 
 		// remove the last KV from the source / borrower
@@ -308,11 +468,11 @@ func (b *BTree) borrowKeyFromLeafNode(src, dst *Node, borrowFromLeft bool, paren
 		dst.value = append([][]byte{lastVal}, dst.value...)
 
 		// update separator: dst's first key changed
-		parent.key[dstIdx-1] = dst.key[0]
+		parent.key[dstIdx-1] = leafSeparator(src, dst)
 
 		return dst
 	} else { // borrow from the right sibling i.e. get the leftmost key
-		firstKey := src.key[0]
+		firstKey := dst.adopt(src.prefix, src.key[:1])[0]
 		firstVal := src.value[0]
 
 		// remove the first KV from the source / borrower
@@ -324,7 +484,7 @@ func (b *BTree) borrowKeyFromLeafNode(src, dst *Node, borrowFromLeft bool, paren
 		dst.value = append(dst.value, firstVal)
 
 		// update separator: src's first key changed
-		parent.key[dstIdx] = src.key[0]
+		parent.key[dstIdx] = leafSeparator(dst, src)
 
 		return dst
 	}
@@ -391,13 +551,12 @@ func (b *BTree) getChildIndexFromParentChildren(parent, child *Node) int {
 }
 
 func (b *BTree) findEqualKeyIndexInNode(node *Node, key []byte) (int, error) {
-	for i, k := range node.key {
-		if bytes.Equal(k, key) {
-			return i, nil
-		}
+	i := node.lowerBound(key)
+	if i < len(node.key) && node.keyEqual(i, key) {
+		return i, nil
 	}
 
-	return 0, fmt.Errorf("no key found")
+	return 0, ErrNotFound
 }
 
 func (b *BTree) splitNode(node *Node, path []*Node) (left, right *Node) {
@@ -412,7 +571,7 @@ func (b *BTree) splitNode(node *Node, path []*Node) (left, right *Node) {
 			"leaf node key/value mismatch before split: %d keys, %d values",
 			len(node.key), len(node.value))
 
-		right = &Node{}
+		right = &Node{prefix: node.prefix, edit: b.edit}
 		numRightKeys := len(node.key) - b.order
 		right.key = make([][]byte, numRightKeys)
 		right.value = make([][]byte, numRightKeys)
@@ -425,20 +584,22 @@ func (b *BTree) splitNode(node *Node, path []*Node) (left, right *Node) {
 			right.value[i] = left.value[b.order+i]
 		}
 
-		right.next = left.next
-		left.next = right
+		if !b.shared {
+			right.next = left.next
+			left.next = right
 
-		right.prev = left
+			right.prev = left
 
-		if right.next != nil {
-			// update the prev pointer of the next node
-			right.next.prev = right
+			if right.next != nil {
+				// update the prev pointer of the next node
+				right.next.prev = right
+			}
 		}
 
 		left.key = left.key[:b.order]
 		left.value = left.value[:b.order]
 
-		separatorKey := right.key[0]
+		separatorKey := leafSeparator(left, right)
 
 		var parent *Node
 		if len(path) != 0 {
@@ -446,14 +607,17 @@ func (b *BTree) splitNode(node *Node, path []*Node) (left, right *Node) {
 		}
 		if parent == nil {
 			// create a new root
-			newRoot := &Node{}
+			newRoot := &Node{edit: b.edit}
 			newRoot.key = append(newRoot.key, separatorKey)
 			newRoot.children = append(newRoot.children, left, right)
 
 			b.root = newRoot
 			return
 		}
-		insertionIdx := b.findKeyIndexInNode(parent, separatorKey)
+		// the separator goes right after left's slot in the parent. Searching
+		// for it by key is ambiguous once duplicate keys can spread over
+		// several leaves and the parent holds equal separators.
+		insertionIdx := b.getChildIndexFromParentChildren(parent, left)
 		b.insertKeyInNodeInPlace(parent, separatorKey, right, insertionIdx)
 		if b.checkMaxKeys(len(parent.key)) {
 			return b.splitNode(parent, path[:len(path)-1])
@@ -465,7 +629,7 @@ func (b *BTree) splitNode(node *Node, path []*Node) (left, right *Node) {
 			"internal node children/key mismatch before split: %d children, %d keys",
 			len(node.children), len(node.key))
 
-		right = &Node{}
+		right = &Node{edit: b.edit}
 
 		// Calculate how many keys go to right (all keys after the separator)
 		numRightKeys := len(node.key) - b.order - 1
@@ -495,14 +659,14 @@ func (b *BTree) splitNode(node *Node, path []*Node) (left, right *Node) {
 		}
 		if parent == nil {
 			// create a new root
-			newRoot := &Node{}
+			newRoot := &Node{edit: b.edit}
 			newRoot.key = append(newRoot.key, separatorKey)
 			newRoot.children = append(newRoot.children, left, right)
 
 			b.root = newRoot
 			return
 		}
-		insertionIdx := b.findKeyIndexInNode(parent, separatorKey)
+		insertionIdx := b.getChildIndexFromParentChildren(parent, left)
 
 		b.insertKeyInNodeInPlace(parent, separatorKey, right, insertionIdx)
 
@@ -542,6 +706,9 @@ func (b *BTree) insertKVInLeafInPlace(
 	common.Assert(len(node.key) == len(node.value),
 		"leaf node key/value length mismatch: %d keys, %d values", len(node.key), len(node.value))
 
+	// copy first, moving to a new slab rewrites the keys already in the leaf
+	key, val = node.storeEntry(key, val)
+
 	node.key = append(node.key, nil)
 	node.value = append(node.value, nil)
 
@@ -571,13 +738,7 @@ func (b *BTree) traverseRightOrLeft(node *Node, key []byte) *Node {
 		"internal node has %d children but %d keys (expected %d children)",
 		len(node.children), len(node.key), len(node.key)+1)
 
-	for i, v := range node.key {
-		if bytes.Compare(key, v) < 0 {
-			return node.children[i]
-		}
-	}
-
-	return node.children[len(node.key)]
+	return node.children[upperBound(node.key, key)]
 }
 
 func (b *BTree) findKeyIndexInNode(node *Node, key []byte) int {
@@ -585,18 +746,17 @@ func (b *BTree) findKeyIndexInNode(node *Node, key []byte) int {
 		return -1
 	}
 
-	for i, v := range node.key {
-		c := bytes.Compare(key, v)
-		if c <= 0 {
-			return i
-		}
+	if node.IsLeaf() {
+		return node.lowerBound(key)
 	}
-
-	return len(node.key)
+	return lowerBound(node.key, key)
 }
 
-// PrettyPrint prints the B+tree in a hierarchical format
+// PrettyPrint prints the B+tree in a hierarchical format. With
+// WithCorruptionErrors, corruption stops the output and poisons the tree.
 func (b *BTree) PrettyPrint() {
+	defer b.recoverCorruption(nil)
+
 	if b.root == nil {
 		fmt.Println("(empty tree)")
 		return
@@ -626,12 +786,19 @@ func (b *BTree) printNode(node *Node, prefix string, isLast bool) {
 	// Print keys
 	fmt.Printf("%s%s%s [", prefix, connector, label)
 	for i, key := range node.key {
+		if node.IsLeaf() {
+			key = node.leafKey(i)
+		}
 		if i > 0 {
 			fmt.Print(", ")
 		}
 		if node.IsLeaf() {
 			// Leaf: show key:value
-			fmt.Printf("%s:%s", string(key), string(node.value[i]))
+			value, err := b.readValue(key, node.value[i])
+			if err != nil {
+				value = []byte("<" + err.Error() + ">")
+			}
+			fmt.Printf("%s:%s", string(key), string(value))
 		} else {
 			// Internal: just show key
 			fmt.Printf("%s", string(key))
@@ -652,17 +819,11 @@ func (b *BTree) printNode(node *Node, prefix string, isLast bool) {
 }
 
 func convertIntToByte(i int) []byte {
-	var buf [8]byte
-	binary.BigEndian.PutUint64(buf[:], uint64(i))
-	return buf[:]
+	return keyenc.EncodeInt64(int64(i))
 }
 
 func convertBytetoInt(b []byte) int {
-	if len(b) < 8 {
-		// normalize shorter slices by left-padding to 8 bytes
-		var tmp [8]byte
-		copy(tmp[8-len(b):], b)
-		return int(binary.BigEndian.Uint64(tmp[:]))
-	}
-	return int(binary.BigEndian.Uint64(b))
+	v, err := keyenc.DecodeInt64(b)
+	common.Assert(err == nil, "invalid int key: %v", err)
+	return int(v)
 }
@@ -118,3 +118,33 @@ func TestRandomizedOperations(t *testing.T) {
 		}
 	}
 }
+
+// TestDelete_MergedNodeOverflow deletes until internal nodes merge. Two of
+// them at the minimum plus the separator pulled down from their parent can
+// be one key over the maximum, which has to be split again.
+func TestDelete_MergedNodeOverflow(t *testing.T) {
+	rnd := rand.New(rand.NewSource(7))
+	b := New(1)
+	for _, k := range rnd.Perm(300) {
+		assert.NoError(t, b.InsertInt(k, nil))
+	}
+
+	var check func(n *Node) bool
+	check = func(n *Node) bool {
+		if b.checkMaxKeys(len(n.key)) {
+			return false
+		}
+		for _, c := range n.children {
+			if !check(c) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, k := range rnd.Perm(300) {
+		assert.NoError(t, b.DeleteInt(k))
+		if !assert.True(t, check(b.root), "node over the maximum after deleting %d", k) {
+			return
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"storage-engine/common"
+)
+
+// Trees with a merge operator or TTLs can't store values as-is: a leaf slot
+// may hold a base value plus merge operands that haven't been folded yet, or
+// an expiry time. Such trees store every value as a cell, a flags byte
+// followed by the payload.
+//
+// Cell:        flags | [8 byte expiry, unix nanoseconds, if cellExpiry] | payload
+// Plain:       payload = value
+// Merge:       payload = hasBase (1 byte) | [uvarint len | base] |
+//
+//	uvarint count | (uvarint len | operand)...
+const (
+	cellMerge  byte = 1 << 0
+	cellExpiry byte = 1 << 1
+)
+
+// errExpired is returned by readLiveValue for entries whose TTL has passed.
+// It never reaches callers; they see the key as missing.
+var errExpired = errors.New("entry expired")
+
+type cell struct {
+	flags     byte
+	expiresAt int64    // unix nanoseconds, if cellExpiry is set
+	value     []byte   // the value, or the merge base if hasBase
+	hasBase   bool     // merge cells only
+	operands  [][]byte // merge cells only, oldest first
+}
+
+func (c cell) isMerge() bool {
+	return c.flags&cellMerge != 0
+}
+
+func (c cell) hasExpiry() bool {
+	return c.flags&cellExpiry != 0
+}
+
+// withExpiryOf copies the expiry of other, if it has one, into c.
+func (c cell) withExpiryOf(other cell) cell {
+	c.flags = c.flags&^cellExpiry | other.flags&cellExpiry
+	c.expiresAt = other.expiresAt
+	return c
+}
+
+func encodeCell(c cell) []byte {
+	buf := make([]byte, 0, 1+8+len(c.value))
+	buf = append(buf, c.flags)
+	if c.hasExpiry() {
+		buf = binary.BigEndian.AppendUint64(buf, uint64(c.expiresAt))
+	}
+
+	if !c.isMerge() {
+		return append(buf, c.value...)
+	}
+
+	if c.hasBase {
+		buf = append(buf, 1)
+		buf = binary.AppendUvarint(buf, uint64(len(c.value)))
+		buf = append(buf, c.value...)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(c.operands)))
+	for _, op := range c.operands {
+		buf = binary.AppendUvarint(buf, uint64(len(op)))
+		buf = append(buf, op...)
+	}
+	return buf
+}
+
+func decodeCell(raw []byte) (cell, error) {
+	if len(raw) == 0 {
+		return cell{}, common.Corruptf("empty value cell")
+	}
+
+	c := cell{flags: raw[0]}
+	rest := raw[1:]
+	if c.hasExpiry() {
+		if len(rest) < 8 {
+			return cell{}, common.Corruptf("truncated value cell")
+		}
+		c.expiresAt = int64(binary.BigEndian.Uint64(rest))
+		rest = rest[8:]
+	}
+
+	if !c.isMerge() {
+		c.value = rest
+		return c, nil
+	}
+
+	if len(rest) == 0 {
+		return cell{}, common.Corruptf("truncated merge cell")
+	}
+	c.hasBase = rest[0] == 1
+	rest = rest[1:]
+
+	var err error
+	if c.hasBase {
+		if c.value, rest, err = readChunk(rest); err != nil {
+			return cell{}, err
+		}
+	}
+
+	count, n := binary.Uvarint(rest)
+	if n <= 0 {
+		return cell{}, common.Corruptf("truncated merge cell")
+	}
+	rest = rest[n:]
+	// every operand takes at least its length byte
+	if count > uint64(len(rest)) {
+		return cell{}, common.Corruptf("merge cell claims %d operands in %d bytes", count, len(rest))
+	}
+
+	c.operands = make([][]byte, 0, count)
+	for range count {
+		var op []byte
+		if op, rest, err = readChunk(rest); err != nil {
+			return cell{}, err
+		}
+		c.operands = append(c.operands, op)
+	}
+	return c, nil
+}
+
+func readChunk(b []byte) ([]byte, []byte, error) {
+	l, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < l {
+		return nil, nil, common.Corruptf("truncated merge cell")
+	}
+	return b[n : n+int(l)], b[n+int(l):], nil
+}
+
+// usesCells reports whether values are stored as cells in this tree.
+func (b *BTree) usesCells() bool {
+	return b.mergeOp != nil || b.clock != nil
+}
+
+// encodeValue turns a caller's value into what is stored in the leaf.
+func (b *BTree) encodeValue(value []byte) []byte {
+	if !b.usesCells() {
+		return value
+	}
+	return encodeCell(cell{value: value})
+}
+
+// readValue turns what is stored in the leaf back into the caller's value,
+// applying pending merge operands. It doesn't look at the TTL, see
+// readLiveValue.
+func (b *BTree) readValue(key, raw []byte) ([]byte, error) {
+	if !b.usesCells() {
+		return raw, nil
+	}
+
+	c, err := decodeCell(raw)
+	if err != nil {
+		return nil, err
+	}
+	return b.cellValue(key, c)
+}
+
+// readLiveValue is readValue for lookups, returning errExpired for entries
+// whose TTL has passed.
+func (b *BTree) readLiveValue(key, raw []byte) ([]byte, error) {
+	if b.rawExpired(raw) {
+		return nil, errExpired
+	}
+	return b.readValue(key, raw)
+}
+
+// cellValue returns the caller's value held by c.
+func (b *BTree) cellValue(key []byte, c cell) ([]byte, error) {
+	if !c.isMerge() {
+		return c.value, nil
+	}
+
+	var base []byte
+	if c.hasBase {
+		base = c.value
+	}
+	var (
+		value []byte
+		err   error
+	)
+	b.callback(func() { value, err = b.mergeOp.Merge(key, base, c.operands) })
+	return value, err
+}
+
+// rawExpired reports whether a stored value has passed its TTL. It only looks
+// at the cell header.
+func (b *BTree) rawExpired(raw []byte) bool {
+	if b.clock == nil || len(raw) < 9 || raw[0]&cellExpiry == 0 {
+		return false
+	}
+	return int64(binary.BigEndian.Uint64(raw[1:9])) <= b.clock.Now().UnixNano()
+}
+
+func (b *BTree) cellExpired(c cell) bool {
+	return b.clock != nil && c.hasExpiry() && c.expiresAt <= b.clock.Now().UnixNano()
+}
@@ -0,0 +1,160 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"storage-engine/keyenc"
+)
+
+// Codec converts values of type T to and from bytes.
+//
+// Codecs used for keys must be order-preserving: if a < b then
+// bytes.Compare(Encode(a), Encode(b)) < 0, otherwise iteration order over the
+// tree won't match the order of the typed keys.
+type Codec[T any] interface {
+	Encode(v T) []byte
+	Decode(b []byte) (T, error)
+}
+
+type Signed interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64
+}
+
+type Unsigned interface {
+	~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+type Float interface {
+	~float32 | ~float64
+}
+
+// IntCodec encodes signed integers with keyenc.EncodeInt64, so negative
+// numbers sort before positive ones.
+type IntCodec[T Signed] struct{}
+
+func (IntCodec[T]) Encode(v T) []byte {
+	return keyenc.EncodeInt64(int64(v))
+}
+
+func (IntCodec[T]) Decode(b []byte) (T, error) {
+	v, err := keyenc.DecodeInt64(b)
+	return T(v), err
+}
+
+// UintCodec encodes unsigned integers as 8 bytes big-endian.
+type UintCodec[T Unsigned] struct{}
+
+func (UintCodec[T]) Encode(v T) []byte {
+	return keyenc.EncodeUint64(uint64(v))
+}
+
+func (UintCodec[T]) Decode(b []byte) (T, error) {
+	v, err := keyenc.DecodeUint64(b)
+	return T(v), err
+}
+
+// FloatCodec encodes floats with keyenc.EncodeFloat64 (IEEE-754 total order).
+// float32 values are widened, which keeps their order.
+type FloatCodec[T Float] struct{}
+
+func (FloatCodec[T]) Encode(v T) []byte {
+	return keyenc.EncodeFloat64(float64(v))
+}
+
+func (FloatCodec[T]) Decode(b []byte) (T, error) {
+	v, err := keyenc.DecodeFloat64(b)
+	return T(v), err
+}
+
+// DescCodec reverses the sort order of a fixed-width codec such as IntCodec,
+// UintCodec, FloatCodec or TimeCodec by inverting every bit. It does not work
+// for variable-length codecs like StringCodec.
+type DescCodec[T any] struct {
+	Codec Codec[T]
+}
+
+func (d DescCodec[T]) Encode(v T) []byte {
+	return keyenc.Invert(append([]byte(nil), d.Codec.Encode(v)...))
+}
+
+func (d DescCodec[T]) Decode(b []byte) (T, error) {
+	return d.Codec.Decode(keyenc.Invert(append([]byte(nil), b...)))
+}
+
+// StringCodec stores strings as their raw bytes. Byte order of UTF-8 matches
+// code point order, so it is safe to use for keys.
+type StringCodec struct{}
+
+func (StringCodec) Encode(v string) []byte {
+	return []byte(v)
+}
+
+func (StringCodec) Decode(b []byte) (string, error) {
+	return string(b), nil
+}
+
+// BytesCodec stores byte slices as-is. Decode doesn't copy, so values read
+// through a TypedIterator are views into the tree, see Iterator.
+type BytesCodec struct{}
+
+func (BytesCodec) Encode(v []byte) []byte {
+	return v
+}
+
+func (BytesCodec) Decode(b []byte) ([]byte, error) {
+	return b, nil
+}
+
+// TimeCodec encodes a time.Time as 8 bytes of sign-flipped unix seconds
+// followed by 4 bytes of nanoseconds. Location and monotonic clock readings
+// are dropped; decoded values are in UTC.
+type TimeCodec struct{}
+
+func (TimeCodec) Encode(v time.Time) []byte {
+	buf := keyenc.AppendInt64(make([]byte, 0, 12), v.Unix())
+	return binary.BigEndian.AppendUint32(buf, uint32(v.Nanosecond()))
+}
+
+func (TimeCodec) Decode(b []byte) (time.Time, error) {
+	if len(b) != 12 {
+		return time.Time{}, fmt.Errorf("time codec: expected 12 bytes, got %d", len(b))
+	}
+	sec, err := keyenc.DecodeInt64(b[:8])
+	if err != nil {
+		return time.Time{}, err
+	}
+	nsec := int64(binary.BigEndian.Uint32(b[8:]))
+	return time.Unix(sec, nsec).UTC(), nil
+}
+
+// ByteArray is the byte array types ArrayCodec handles: the sizes of common
+// ids and hashes, from 32-bit ids up to SHA-512 digests.
+type ByteArray interface {
+	~[4]byte | ~[8]byte | ~[12]byte | ~[16]byte | ~[20]byte |
+		~[24]byte | ~[28]byte | ~[32]byte | ~[48]byte | ~[64]byte
+}
+
+// ArrayCodec stores fixed-size byte arrays such as [16]byte UUIDs or
+// [32]byte hashes, as their bytes.
+type ArrayCodec[A ByteArray] struct{}
+
+func (ArrayCodec[A]) Encode(v A) []byte {
+	b := make([]byte, len(v))
+	for i := range len(v) {
+		b[i] = v[i]
+	}
+	return b
+}
+
+func (ArrayCodec[A]) Decode(b []byte) (A, error) {
+	var v A
+	if len(b) != len(v) {
+		return v, fmt.Errorf("array codec: expected %d bytes, got %d", len(v), len(b))
+	}
+	for i := range len(v) {
+		v[i] = b[i]
+	}
+	return v, nil
+}
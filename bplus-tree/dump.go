@@ -0,0 +1,323 @@
+package bplustree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"storage-engine/common"
+	"storage-engine/layout"
+)
+
+// A dump holds the exact node structure of a tree, so it can be checked
+// offline with Verify (see cmd/btree-verify). Nodes are written in preorder
+// using the page layout, with a node's position standing in for its page id;
+// the root is page 1. The prev and next links of every leaf page follow, in
+// page order, as page ids: 0 for none, linkElsewhere for a node that isn't
+// part of the tree.
+//
+//	magic | uvarint order | uvarint dup order | uvarint len | merge operator |
+//	ttl (1 byte) | shared (1 byte) | uvarint node count |
+//	(uvarint len | node)... | (uvarint prev | uvarint next)...
+const dumpMagic = "btdump2\n"
+
+// linkElsewhere is the link of a leaf pointing at a node outside the tree.
+const linkElsewhere = math.MaxUint64
+
+// Dump writes the structure of the tree to w.
+func (b *BTree) Dump(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	header := []byte(dumpMagic)
+	header = binary.AppendUvarint(header, uint64(b.order))
+	header = binary.AppendUvarint(header, uint64(b.dups))
+	name := ""
+	if b.mergeOp != nil {
+		name = b.mergeOp.Name()
+	}
+	header = binary.AppendUvarint(header, uint64(len(name)))
+	header = append(header, name...)
+	ttl := byte(0)
+	if b.clock != nil {
+		ttl = 1
+	}
+	shared := byte(0)
+	if b.shared {
+		shared = 1
+	}
+	header = append(header, ttl, shared)
+
+	pages, leaves, ids, err := b.dumpPages()
+	if err != nil {
+		return err
+	}
+	header = binary.AppendUvarint(header, uint64(len(pages)))
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+
+	for _, page := range pages {
+		if _, err := bw.Write(binary.AppendUvarint(nil, uint64(len(page)))); err != nil {
+			return err
+		}
+		if _, err := bw.Write(page); err != nil {
+			return err
+		}
+	}
+
+	// links are written as they are, broken or not, for Verify to judge
+	link := func(n *Node) uint64 {
+		if n == nil {
+			return 0
+		}
+		if id, ok := ids[n]; ok {
+			return id
+		}
+		return linkElsewhere
+	}
+	var links []byte
+	for _, n := range leaves {
+		links = binary.AppendUvarint(links, link(n.prev))
+		links = binary.AppendUvarint(links, link(n.next))
+	}
+	if _, err := bw.Write(links); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// dumpPages encodes every node in preorder. It also returns the leaves in
+// page order and the page id of every node.
+func (b *BTree) dumpPages() ([][]byte, []*Node, map[*Node]uint64, error) {
+	pages := make([][]byte, 0)
+	leaves := make([]*Node, 0)
+	ids := make(map[*Node]uint64)
+	if b.root == nil {
+		return pages, leaves, ids, nil
+	}
+
+	var visit func(n *Node) (uint64, error)
+	visit = func(n *Node) (uint64, error) {
+		pages = append(pages, nil)
+		id := uint64(len(pages))
+		ids[n] = id
+
+		if n.IsLeaf() {
+			if len(n.value) != len(n.key) {
+				return 0, common.Corruptf("leaf has %d values for %d keys", len(n.value), len(n.key))
+			}
+			pages[id-1] = encodeLeaf(n)
+			leaves = append(leaves, n)
+			return id, nil
+		}
+
+		if len(n.children) != len(n.key)+1 {
+			return 0, common.Corruptf("internal node has %d children for %d keys", len(n.children), len(n.key))
+		}
+		children := make([]uint64, 0, len(n.children))
+		for _, c := range n.children {
+			cid, err := visit(c)
+			if err != nil {
+				return 0, err
+			}
+			children = append(children, cid)
+		}
+		pages[id-1] = layout.EncodeInternal(n.key, children)
+		return id, nil
+	}
+
+	if _, err := visit(b.root); err != nil {
+		return nil, nil, nil, err
+	}
+	return pages, leaves, ids, nil
+}
+
+// LoadDump rebuilds a tree written by Dump, node for node and link for link,
+// without fixing anything up, so Verify sees the structure as it was dumped.
+// A tree dumped with a merge operator needs the operator registered under the
+// same name, see RegisterMergeOperator. A dumped persistent tree loads as a
+// version.
+func LoadDump(r io.Reader) (*BTree, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(dumpMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != dumpMagic {
+		return nil, common.Corruptf("not a tree dump")
+	}
+
+	order, err := binary.ReadUvarint(br)
+	if err != nil || order == 0 || order > 1<<20 {
+		return nil, common.Corruptf("bad order in dump header")
+	}
+	dups, err := binary.ReadUvarint(br)
+	if err != nil || dups > uint64(DupValueOrder) {
+		return nil, common.Corruptf("bad duplicate order in dump header")
+	}
+	name, err := readDumpChunk(br)
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := br.ReadByte()
+	if err != nil {
+		return nil, common.Corruptf("truncated dump header")
+	}
+	shared, err := br.ReadByte()
+	if err != nil {
+		return nil, common.Corruptf("truncated dump header")
+	}
+
+	if dups != uint64(DupNone) && (len(name) > 0 || ttl == 1) {
+		return nil, common.Corruptf("dump of a multimap tree with value cells")
+	}
+	if shared == 1 && (dups != uint64(DupNone) || len(name) > 0 || ttl == 1) {
+		return nil, common.Corruptf("dump of a persistent tree with multimap or value cells")
+	}
+
+	opts := []Option{WithDuplicates(DupOrder(dups))}
+	if len(name) > 0 {
+		op, ok := LookupMergeOperator(string(name))
+		if !ok {
+			return nil, fmt.Errorf("merge operator %q is not registered", name)
+		}
+		opts = append(opts, WithMergeOperator(op))
+	}
+	if ttl == 1 {
+		opts = append(opts, WithTTL(nil))
+	}
+	if shared == 1 {
+		opts = append(opts, WithStructuralSharing())
+	}
+
+	count, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, common.Corruptf("truncated dump header")
+	}
+	pages := make([][]byte, 0)
+	for range count {
+		page, err := readDumpChunk(br)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+	links := make([][2]uint64, len(pages)+1)
+	for i, page := range pages {
+		if !isLeafPage(page) {
+			continue
+		}
+		prev, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, common.Corruptf("truncated dump")
+		}
+		next, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, common.Corruptf("truncated dump")
+		}
+		links[uint64(i+1)] = [2]uint64{prev, next}
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		return nil, common.Corruptf("trailing bytes after dump")
+	}
+
+	b := New(int(order), opts...)
+	if len(pages) == 0 {
+		return b, nil
+	}
+
+	nodes := make([]*Node, len(pages)+1)
+	var build func(id uint64) (*Node, error)
+	build = func(id uint64) (*Node, error) {
+		page := pages[id-1]
+
+		if isLeafPage(page) {
+			n, err := decodeLeaf(page)
+			if err != nil {
+				return nil, pageError(id, err)
+			}
+			nodes[id] = n
+			return n, nil
+		}
+
+		keys, children, err := layout.DecodeInternal[uint64](page)
+		if err != nil {
+			return nil, pageError(id, err)
+		}
+		n := &Node{key: keys}
+		nodes[id] = n
+		for _, cid := range children {
+			// children come after their parent in preorder, which also
+			// rules out cycles
+			if cid <= id || cid > uint64(len(pages)) || nodes[cid] != nil {
+				return nil, &CorruptionError{PageID: id, Reason: fmt.Sprintf("bad child id %d", cid)}
+			}
+			c, err := build(cid)
+			if err != nil {
+				return nil, err
+			}
+			n.children = append(n.children, c)
+		}
+		return n, nil
+	}
+
+	if b.root, err = build(1); err != nil {
+		return nil, err
+	}
+
+	// one node stands in for everything outside the tree
+	elsewhere := &Node{}
+	node := func(id int, link uint64) (*Node, error) {
+		switch {
+		case link == 0:
+			return nil, nil
+		case link == linkElsewhere:
+			return elsewhere, nil
+		case link > uint64(len(pages)) || nodes[link] == nil:
+			return nil, &CorruptionError{PageID: uint64(id), Reason: fmt.Sprintf("bad leaf link %d", link)}
+		}
+		return nodes[link], nil
+	}
+	for id, l := range links {
+		n := nodes[id]
+		if n == nil || !isLeafPage(pages[id-1]) {
+			// not a leaf, or a page the tree doesn't reach
+			continue
+		}
+		if n.prev, err = node(id, l[0]); err != nil {
+			return nil, err
+		}
+		if n.next, err = node(id, l[1]); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func isLeafPage(page []byte) bool {
+	return len(page) > 0 && page[0] == layout.Leaf
+}
+
+func readDumpChunk(br *bufio.Reader) ([]byte, error) {
+	l, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, common.Corruptf("truncated dump")
+	}
+	// copy rather than allocate l bytes up front, l may be garbage
+	var chunk bytes.Buffer
+	if _, err := io.CopyN(&chunk, br, int64(min(l, math.MaxInt64))); err != nil {
+		return nil, common.Corruptf("truncated dump")
+	}
+	return chunk.Bytes(), nil
+}
+
+// pageError attaches the page id to a corruption error from the page layout.
+func pageError(id uint64, err error) error {
+	var ce *CorruptionError
+	if errors.As(err, &ce) {
+		return &CorruptionError{PageID: id, Reason: ce.Reason}
+	}
+	return err
+}
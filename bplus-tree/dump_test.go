@@ -0,0 +1,136 @@
+package bplustree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"storage-engine/layout"
+)
+
+func TestDump_RoundTrip(t *testing.T) {
+	b := New(2, WithMergeOperator(Int64AddOperator{}))
+	for i := range 100 {
+		b.Merge(convertIntToByte(i), Int64Value(int64(i)))
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, b.Dump(&buf))
+
+	loaded, err := LoadDump(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, b.Verify().String(), loaded.Verify().String())
+
+	for i := range 100 {
+		v, err := loaded.GetInt(i)
+		assert.NoError(t, err)
+		assert.Equal(t, Int64Value(int64(i)), v)
+	}
+
+	// the loaded tree is a regular tree
+	assert.NoError(t, loaded.Merge(convertIntToByte(1), Int64Value(1)))
+	v, _ := loaded.GetInt(1)
+	assert.Equal(t, Int64Value(2), v)
+}
+
+func TestDump_KeepsBrokenStructure(t *testing.T) {
+	b := New(2, WithDuplicates(DupValueOrder))
+	for i := range 60 {
+		b.Insert([]byte(fmt.Sprintf("k%02d", i/2)), []byte(fmt.Sprint(i)))
+	}
+	leaf := b.leftmostLeaf()
+	leaf.key[0], leaf.key[1] = leaf.key[1], []byte("zz")
+
+	var buf bytes.Buffer
+	assert.NoError(t, b.Dump(&buf))
+	loaded, err := LoadDump(&buf)
+	assert.NoError(t, err)
+
+	r := loaded.Verify()
+	assert.False(t, r.OK())
+	assert.Equal(t, invariants(b.Verify()), invariants(r))
+}
+
+func TestDump_KeepsLeafLinks(t *testing.T) {
+	b := New(2)
+	for i := range 40 {
+		b.InsertInt(i, []byte("v"))
+	}
+	// skip the second leaf, and point the last one somewhere else entirely
+	first := b.leftmostLeaf()
+	first.next = first.next.next
+	b.rightmostLeaf().next = &Node{}
+
+	var buf bytes.Buffer
+	assert.NoError(t, b.Dump(&buf))
+	loaded, err := LoadDump(&buf)
+	assert.NoError(t, err)
+
+	r := loaded.Verify()
+	assert.Contains(t, invariants(r), InvariantLeafChain)
+	assert.Equal(t, b.Verify().String(), r.String())
+}
+
+func TestDump_Persistent(t *testing.T) {
+	v := New(2, WithStructuralSharing())
+	for i := range 40 {
+		v, _ = v.InsertVersion(convertIntToByte(i), []byte("v"))
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, v.Dump(&buf))
+	loaded, err := LoadDump(&buf)
+	assert.NoError(t, err)
+	assert.True(t, loaded.Verify().OK())
+	assert.ErrorIs(t, loaded.InsertInt(100, []byte("v")), ErrImmutable)
+}
+
+func TestDump_Corrupt(t *testing.T) {
+	b := New(2)
+	for i := range 30 {
+		b.InsertInt(i, []byte("v"))
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, b.Dump(&buf))
+	dump := buf.Bytes()
+
+	_, err := LoadDump(bytes.NewReader([]byte("garbage")))
+	assert.ErrorIs(t, err, ErrCorrupt)
+	_, err = LoadDump(bytes.NewReader(append(bytes.Clone(dump), 0)))
+	assert.ErrorIs(t, err, ErrCorrupt)
+
+	for _, n := range []int{len(dumpMagic), len(dump) / 2, len(dump) - 1} {
+		_, err = LoadDump(bytes.NewReader(dump[:n]))
+		assert.ErrorIs(t, err, ErrCorrupt, "truncated to %d bytes", n)
+	}
+
+	// break the node kind of the root page: magic, five one byte header
+	// fields, the node count and the length of the root page come first
+	rootKind := len(dumpMagic) + 7
+	assert.Equal(t, layout.Internal, dump[rootKind])
+	broken := bytes.Clone(dump)
+	broken[rootKind] = 9
+	_, err = LoadDump(bytes.NewReader(broken))
+	var ce *CorruptionError
+	assert.ErrorAs(t, err, &ce)
+	assert.Equal(t, uint64(1), ce.PageID)
+
+	// the next link of the last leaf ends the dump, make it point past the
+	// last page
+	broken = append(bytes.Clone(dump[:len(dump)-1]), 100)
+	_, err = LoadDump(bytes.NewReader(broken))
+	assert.ErrorAs(t, err, &ce)
+	assert.Contains(t, ce.Reason, "bad leaf link")
+}
+
+func TestDump_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, New(3).Dump(&buf))
+
+	loaded, err := LoadDump(&buf)
+	assert.NoError(t, err)
+	assert.True(t, loaded.Verify().OK())
+	assert.NoError(t, loaded.Insert([]byte("k"), []byte("v")))
+}
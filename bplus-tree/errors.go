@@ -0,0 +1,33 @@
+package bplustree
+
+import (
+	"errors"
+
+	"storage-engine/common"
+)
+
+// Errors returned by the tree. They are shared with the storage layers in
+// common, so callers can check them with errors.Is no matter where they come
+// from.
+var (
+	ErrNotFound    = common.ErrNotFound
+	ErrEmptyKey    = common.ErrEmptyKey
+	ErrClosed      = common.ErrClosed
+	ErrTxnConflict = common.ErrTxnConflict
+	ErrCorrupt     = common.ErrCorrupt
+)
+
+// ErrMultimap is returned by the read-modify-write methods of multimap trees,
+// where a key doesn't identify a single value.
+var ErrMultimap = errors.New("not supported on multimap trees")
+
+// CorruptionError is the structured form of ErrCorrupt, see errors.As.
+type CorruptionError = common.CorruptionError
+
+// ErrNoMergeOperator is returned by Merge on a tree created without
+// WithMergeOperator.
+var ErrNoMergeOperator = errors.New("no merge operator registered")
+
+// ErrMergeOperand is returned, wrapped, by the built-in merge operators for
+// values and operands they can't make sense of.
+var ErrMergeOperand = errors.New("malformed merge operand")
@@ -0,0 +1,68 @@
+package bplustree
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"storage-engine/layout"
+)
+
+func TestErrors_NotFound(t *testing.T) {
+	b := New(3)
+
+	// an empty tree is reported like a missing key
+	_, err := b.Get([]byte("k"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, b.Delete([]byte("k")), ErrNotFound)
+	_, err = b.Seek([]byte("k"))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	b.Insert([]byte("a"), []byte("v"))
+	_, err = b.Get([]byte("k"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, b.Delete([]byte("k")), ErrNotFound)
+	assert.ErrorIs(t, b.DeleteValue([]byte("a"), []byte("other")), ErrNotFound)
+	_, err = b.GetAll([]byte("k"))
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestErrors_EmptyKey(t *testing.T) {
+	b := New(3)
+
+	assert.ErrorIs(t, b.Insert(nil, []byte("v")), ErrEmptyKey)
+	assert.ErrorIs(t, b.Insert([]byte{}, []byte("v")), ErrEmptyKey)
+	_, err := b.Get(nil)
+	assert.ErrorIs(t, err, ErrEmptyKey)
+	assert.ErrorIs(t, b.Delete(nil), ErrEmptyKey)
+	_, err = b.Seek(nil)
+	assert.ErrorIs(t, err, ErrEmptyKey)
+	_, _, err = b.Swap(nil, []byte("v"))
+	assert.ErrorIs(t, err, ErrEmptyKey)
+}
+
+func TestErrors_Closed(t *testing.T) {
+	b := New(3)
+	b.Insert([]byte("a"), []byte("v"))
+
+	it := b.NewIter(nil)
+	assert.NoError(t, it.Close())
+	assert.ErrorIs(t, it.Error(), ErrClosed)
+}
+
+func TestErrors_Corrupt(t *testing.T) {
+	c := &CorruptionError{PageID: 7, Reason: "bad checksum"}
+	assert.ErrorIs(t, c, ErrCorrupt)
+	assert.Equal(t, "corrupt data on page 7: bad checksum", c.Error())
+
+	_, err := decodeLeaf([]byte{layout.Leaf, 1})
+	assert.ErrorIs(t, err, ErrCorrupt)
+
+	var ce *CorruptionError
+	assert.True(t, errors.As(err, &ce))
+	assert.Equal(t, uint64(0), ce.PageID)
+
+	_, err = decodeCell(nil)
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
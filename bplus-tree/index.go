@@ -0,0 +1,211 @@
+package bplustree
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// IndexFunc extracts the secondary keys of an entry. It must be
+// deterministic: the same key and value always give the same index keys.
+// Empty index keys are ignored.
+type IndexFunc func(key, value []byte) [][]byte
+
+// index is a secondary index: a value-ordered multimap from index key to the
+// primary keys of the entries that produced it.
+type index struct {
+	name    string
+	extract IndexFunc
+	tree    *BTree
+}
+
+// RegisterIndex adds a secondary index named name, kept up to date by every
+// write to the tree from then on. Entries already in the tree are not
+// indexed until RebuildIndex is called. Multimap trees can't have indexes.
+func (b *BTree) RegisterIndex(name string, fn IndexFunc) error {
+	if b.dups != DupNone {
+		return fmt.Errorf("indexes are not supported on multimap trees")
+	}
+	if b.shared {
+		return fmt.Errorf("indexes are not supported on persistent trees")
+	}
+	if b.findIndex(name) != nil {
+		return fmt.Errorf("index %q already registered", name)
+	}
+
+	b.indexes = append(b.indexes, &index{
+		name:    name,
+		extract: fn,
+		tree:    New(b.order, WithDuplicates(DupValueOrder)),
+	})
+	return nil
+}
+
+// LookupByIndex returns the primary keys of the live entries indexed under
+// indexKey by the index name, in ascending order.
+func (b *BTree) LookupByIndex(name string, indexKey []byte) ([][]byte, error) {
+	ix := b.findIndex(name)
+	if ix == nil {
+		return nil, fmt.Errorf("no index named %q", name)
+	}
+
+	primaryKeys, err := ix.tree.GetAll(indexKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// expired entries stay indexed until they are removed from the tree
+	live := make([][]byte, 0, len(primaryKeys))
+	for _, pk := range primaryKeys {
+		if _, err := b.Get(pk); err == nil {
+			live = append(live, pk)
+		}
+	}
+
+	if len(live) == 0 {
+		return nil, ErrNotFound
+	}
+	return live, nil
+}
+
+// RebuildIndex throws away the contents of the index name and indexes every
+// entry currently in the tree, for backfilling an index registered on a
+// non-empty tree.
+func (b *BTree) RebuildIndex(name string) (err error) {
+	ix := b.findIndex(name)
+	if ix == nil {
+		return fmt.Errorf("no index named %q", name)
+	}
+	if err := b.writable(); err != nil {
+		return err
+	}
+	defer b.recoverCorruption(&err)
+
+	tree := New(b.order, WithDuplicates(DupValueOrder))
+	for n := b.leftmostLeaf(); n != nil; n = n.next {
+		for i := range n.key {
+			key := n.leafKey(i)
+			value, err := b.readValue(key, n.value[i])
+			if err != nil {
+				return err
+			}
+			for _, ik := range uniqueIndexKeys(b.extract(ix, key, value)) {
+				_ = tree.Insert(ik, key)
+			}
+		}
+	}
+
+	ix.tree = tree
+	return nil
+}
+
+func (b *BTree) findIndex(name string) *index {
+	for _, ix := range b.indexes {
+		if ix.name == name {
+			return ix
+		}
+	}
+	return nil
+}
+
+// indexChange holds the index entries to remove and add for a write to a
+// single primary key.
+type indexChange struct {
+	ix      *index
+	removed [][]byte
+	added   [][]byte
+}
+
+// indexChanges works out how the indexes change when the stored value of key
+// goes from old to new, both as stored in the leaf. hadOld and hasNew tell
+// whether the key exists before and after the write. It is called before the
+// write is applied, so a value that can't be read leaves the tree and its
+// indexes untouched.
+func (b *BTree) indexChanges(key, old []byte, hadOld bool, new []byte, hasNew bool) ([]indexChange, error) {
+	if len(b.indexes) == 0 {
+		return nil, nil
+	}
+
+	var (
+		oldValue, newValue []byte
+		err                error
+	)
+	if hadOld {
+		// expired entries are still indexed, so they are read as well
+		if oldValue, err = b.readValue(key, old); err != nil {
+			return nil, err
+		}
+	}
+	if hasNew {
+		if newValue, err = b.readValue(key, new); err != nil {
+			return nil, err
+		}
+	}
+
+	changes := make([]indexChange, 0, len(b.indexes))
+	for _, ix := range b.indexes {
+		var before, after [][]byte
+		if hadOld {
+			before = uniqueIndexKeys(b.extract(ix, key, oldValue))
+		}
+		if hasNew {
+			after = uniqueIndexKeys(b.extract(ix, key, newValue))
+		}
+		changes = append(changes, indexChange{
+			ix:      ix,
+			removed: subtractKeys(before, after),
+			added:   subtractKeys(after, before),
+		})
+	}
+	return changes, nil
+}
+
+// extract returns the index keys ix has for key and value.
+func (b *BTree) extract(ix *index, key, value []byte) [][]byte {
+	var keys [][]byte
+	b.callback(func() { keys = ix.extract(key, value) })
+	return keys
+}
+
+// applyIndexChanges applies changes computed by indexChanges for key, once
+// the write itself is done.
+func (b *BTree) applyIndexChanges(key []byte, changes []indexChange) {
+	for _, c := range changes {
+		for _, ik := range c.removed {
+			_ = c.ix.tree.DeleteValue(ik, key)
+		}
+		for _, ik := range c.added {
+			_ = c.ix.tree.Insert(ik, key)
+		}
+	}
+}
+
+// uniqueIndexKeys drops empty and repeated keys from keys.
+func uniqueIndexKeys(keys [][]byte) [][]byte {
+	unique := make([][]byte, 0, len(keys))
+	for _, k := range keys {
+		if len(k) > 0 && !containsKey(unique, k) {
+			unique = append(unique, k)
+		}
+	}
+	return unique
+}
+
+// subtractKeys returns the keys of a that are not in b.
+func subtractKeys(a, b [][]byte) [][]byte {
+	diff := make([][]byte, 0)
+	for _, k := range a {
+		if !containsKey(b, k) {
+			diff = append(diff, k)
+		}
+	}
+	return diff
+}
+
+func containsKey(keys [][]byte, key []byte) bool {
+	for _, k := range keys {
+		if bytes.Equal(k, key) {
+			return true
+		}
+	}
+	return false
+}
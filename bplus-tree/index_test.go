@@ -0,0 +1,171 @@
+package bplustree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// byCity indexes values of the form "name|city" by city.
+func byCity(key, value []byte) [][]byte {
+	_, city, ok := bytes.Cut(value, []byte("|"))
+	if !ok {
+		return nil
+	}
+	return [][]byte{city}
+}
+
+func keysOf(keys [][]byte) []string {
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, string(k))
+	}
+	return out
+}
+
+func TestIndex_InsertDelete(t *testing.T) {
+	b := New(2)
+	assert.NoError(t, b.RegisterIndex("city", byCity))
+	assert.Error(t, b.RegisterIndex("city", byCity))
+
+	for i := range 30 {
+		city := []string{"berlin", "paris", "tokyo"}[i%3]
+		key := []byte(fmt.Sprintf("user%02d", i))
+		assert.NoError(t, b.Insert(key, []byte("name|"+city)))
+	}
+
+	got, err := b.LookupByIndex("city", []byte("paris"))
+	assert.NoError(t, err)
+	assert.Len(t, got, 10)
+	assert.Equal(t, "user01", string(got[0]))
+
+	// overwriting moves the entry to its new index key
+	assert.NoError(t, b.Insert([]byte("user01"), []byte("name|tokyo")))
+	got, _ = b.LookupByIndex("city", []byte("paris"))
+	assert.Len(t, got, 9)
+	assert.NotContains(t, keysOf(got), "user01")
+	got, _ = b.LookupByIndex("city", []byte("tokyo"))
+	assert.Contains(t, keysOf(got), "user01")
+
+	for i := 0; i < 30; i += 3 {
+		assert.NoError(t, b.Delete([]byte(fmt.Sprintf("user%02d", i))))
+	}
+	_, err = b.LookupByIndex("city", []byte("berlin"))
+	assert.Error(t, err)
+
+	_, err = b.LookupByIndex("country", []byte("de"))
+	assert.Error(t, err)
+}
+
+func TestIndex_MultipleKeys(t *testing.T) {
+	b := New(3)
+	tags := func(key, value []byte) [][]byte {
+		return bytes.Split(value, []byte(","))
+	}
+	assert.NoError(t, b.RegisterIndex("tags", tags))
+
+	b.Insert([]byte("post1"), []byte("go,db,go"))
+	b.Insert([]byte("post2"), []byte("db"))
+
+	got, err := b.LookupByIndex("tags", []byte("db"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"post1", "post2"}, keysOf(got))
+
+	got, err = b.LookupByIndex("tags", []byte("go"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"post1"}, keysOf(got))
+
+	// DeleteValue on a regular tree removes the index entries too
+	assert.NoError(t, b.DeleteValue([]byte("post1"), []byte("go,db,go")))
+	got, err = b.LookupByIndex("tags", []byte("db"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"post2"}, keysOf(got))
+}
+
+func TestIndex_Update(t *testing.T) {
+	b := New(3)
+	assert.NoError(t, b.RegisterIndex("city", byCity))
+
+	_, _, err := b.GetOrInsert([]byte("u1"), []byte("a|rome"))
+	assert.NoError(t, err)
+	_, _, err = b.Swap([]byte("u1"), []byte("a|oslo"))
+	assert.NoError(t, err)
+
+	_, err = b.LookupByIndex("city", []byte("rome"))
+	assert.Error(t, err)
+	got, err := b.LookupByIndex("city", []byte("oslo"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"u1"}, keysOf(got))
+
+	assert.NoError(t, b.Update([]byte("u1"), func(old []byte, exists bool) ([]byte, bool) {
+		return nil, true
+	}))
+	_, err = b.LookupByIndex("city", []byte("oslo"))
+	assert.Error(t, err)
+}
+
+func TestIndex_Merge(t *testing.T) {
+	b := New(3, WithMergeOperator(AppendOperator{}))
+	assert.NoError(t, b.RegisterIndex("city", byCity))
+
+	// the index sees the merged value
+	b.Merge([]byte("u1"), []byte("bob"))
+	_, err := b.LookupByIndex("city", []byte("lima"))
+	assert.Error(t, err)
+
+	b.Merge([]byte("u1"), []byte("|lima"))
+	got, err := b.LookupByIndex("city", []byte("lima"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"u1"}, keysOf(got))
+}
+
+func TestIndex_TTL(t *testing.T) {
+	clock := newFakeClock()
+	b := New(3, WithTTL(clock))
+	assert.NoError(t, b.RegisterIndex("city", byCity))
+
+	b.InsertWithTTL([]byte("u1"), []byte("a|kyiv"), time.Minute)
+	b.Insert([]byte("u2"), []byte("b|kyiv"))
+
+	clock.Advance(time.Minute)
+
+	// expired entries are hidden from lookups and dropped when reaped
+	got, err := b.LookupByIndex("city", []byte("kyiv"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"u2"}, keysOf(got))
+
+	assert.Equal(t, 1, b.ReapExpired(0))
+	ix := b.findIndex("city")
+	all, err := ix.tree.GetAll([]byte("kyiv"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"u2"}, keysOf(all))
+}
+
+func TestIndex_Rebuild(t *testing.T) {
+	b := New(2)
+	for i := range 20 {
+		b.InsertInt(i, []byte(fmt.Sprintf("n|c%d", i%4)))
+	}
+
+	assert.NoError(t, b.RegisterIndex("city", byCity))
+	_, err := b.LookupByIndex("city", []byte("c1"))
+	assert.Error(t, err)
+
+	assert.NoError(t, b.RebuildIndex("city"))
+	got, err := b.LookupByIndex("city", []byte("c1"))
+	assert.NoError(t, err)
+	assert.Len(t, got, 5)
+	for _, k := range got {
+		assert.Equal(t, 1, convertBytetoInt(k)%4)
+	}
+
+	assert.Error(t, b.RebuildIndex("missing"))
+}
+
+func TestIndex_Multimap(t *testing.T) {
+	b := New(3, WithDuplicates(DupInsertionOrder))
+	assert.Error(t, b.RegisterIndex("city", byCity))
+}
@@ -2,105 +2,342 @@ package bplustree
 
 import (
 	"bytes"
-	"fmt"
+
+	"storage-engine/keyenc"
 )
 
+// Iterator walks the keys of a tree in order. Positioning methods (First,
+// Last, the Seek* family, Next and Prev) report whether the iterator ended up
+// on a key; once it is invalid, Key and Value return nil. An iterator can be
+// repositioned any number of times and is released with Close.
+//
+// Key and Value return views into the tree, not copies: they must not be
+// modified and are only valid until the next change to the tree. Copy them
+// to keep them longer. A key whose leaf stores a shared prefix is put back
+// together in a buffer of the iterator, which the same rules apply to.
+type Iterator interface {
+	// First moves to the first key.
+	First() bool
+	// Last moves to the last key.
+	Last() bool
+	// SeekGE moves to the first key >= key.
+	SeekGE(key []byte) bool
+	// SeekLT moves to the last key < key.
+	SeekLT(key []byte) bool
+	// SeekLE moves to the last key <= key.
+	SeekLE(key []byte) bool
+
+	Next() bool
+	Prev() bool
+
+	Valid() bool
+	Key() []byte
+	Value() []byte
+
+	// Error returns the error, if any, that made the iterator invalid, or
+	// that Value last failed with, which leaves it valid. First, Last and
+	// the Seek methods clear it.
+	Error() error
+	// Close releases the iterator. It can't be used afterwards.
+	Close() error
+}
+
 type iterator struct {
 	node *Node // the node iterator points to
 	idx  int   // the index of the key in the node
+
+	buf []byte // where keys are put back together, see Key
+
+	tree   *BTree
+	opts   IterOptions
+	err    error
+	closed bool
+}
+
+var _ Iterator = (*iterator)(nil)
+
+// IterOptions restricts an iterator to a key range. A nil bound means the
+// range is open on that side. LowerBound is inclusive and UpperBound is
+// exclusive unless LowerExclusive / UpperInclusive say otherwise.
+type IterOptions struct {
+	LowerBound []byte
+	UpperBound []byte
+
+	LowerExclusive bool
+	UpperInclusive bool
+}
+
+// NewIter returns an unpositioned iterator limited to the bounds in opts;
+// call First, Last or SeekGE before using it. Next and Prev make the iterator
+// invalid once they step outside the bounds.
+func (b *BTree) NewIter(opts *IterOptions) Iterator {
+	return b.newIter(opts)
+}
+
+func (b *BTree) newIter(opts *IterOptions) *iterator {
+	i := &iterator{tree: b}
+	if opts != nil {
+		i.opts = *opts
+	}
+	return i
 }
 
-func (b *BTree) Seek(key []byte) (*iterator, error) {
+// ScanPrefix returns an iterator over every key starting with prefix,
+// positioned at the first one.
+func (b *BTree) ScanPrefix(prefix []byte) Iterator {
+	i := b.newIter(&IterOptions{
+		LowerBound: prefix,
+		UpperBound: keyenc.PrefixSuccessor(prefix),
+	})
+	i.First()
+	return i
+}
+
+func (b *BTree) Seek(key []byte) (Iterator, error) {
 	if len(key) == 0 {
-		return nil, fmt.Errorf("got empty key")
+		return nil, ErrEmptyKey
 	}
 
 	if b.root == nil {
-		return nil, fmt.Errorf("empty tree")
+		return nil, ErrNotFound
 	}
 
-	n := b.root
+	i := b.newIter(nil)
+	i.SeekGE(key)
+	return i, nil
+}
 
-	for n != nil && !n.IsLeaf() {
-		n = b.traverseRightOrLeft(n, key)
+// SeekInt is Seek for keys written with InsertInt.
+func (b *BTree) SeekInt(k int) (Iterator, error) {
+	return b.Seek(convertIntToByte(k))
+}
+
+func (b *BTree) SeekFirst() Iterator {
+	if b.root == nil {
+		return nil
+	}
+
+	i := b.newIter(nil)
+	i.First()
+	return i
+}
+
+func (b *BTree) SeekLast() Iterator {
+	if b.root == nil {
+		return nil
 	}
 
-	idx := 0
+	i := b.newIter(nil)
+	i.Last()
+	return i
+}
 
-	// Find first key >= search key
-	for idx < len(n.key) && bytes.Compare(n.key[idx], key) < 0 {
-		idx++
+// First moves to the first key inside the bounds and reports whether the
+// iterator is valid.
+func (i *iterator) First() (valid bool) {
+	if i.closed {
+		return false
 	}
+	i.err = nil
+	defer i.recoverCorruption()
 
-	// Past end of this leaf, move to next
-	if idx >= len(n.key) {
-		return &iterator{node: n.next, idx: 0}, nil
+	if i.opts.LowerBound == nil {
+		i.node, i.idx = i.tree.leftmostLeaf(), 0
+		return i.checkUpper()
 	}
 
-	return &iterator{node: n, idx: idx}, nil
+	i.node, i.idx = i.tree.findLeafPosition(i.opts.LowerBound)
+	i.normalizeForward()
+	// a multimap can hold the bound any number of times
+	for i.opts.LowerExclusive && i.Valid() && i.node.keyEqual(i.idx, i.opts.LowerBound) {
+		i.step()
+	}
+	return i.checkUpper()
 }
 
-func (b *BTree) SeekFirst() *iterator {
-	if b.root == nil {
-		return nil
+// Last moves to the last key inside the bounds and reports whether the
+// iterator is valid.
+func (i *iterator) Last() (valid bool) {
+	if i.closed {
+		return false
 	}
+	i.err = nil
+	defer i.recoverCorruption()
 
-	n := b.root
+	if i.opts.UpperBound == nil {
+		n := i.tree.rightmostLeaf()
+		i.node, i.idx = n, 0
+		if n != nil {
+			i.idx = len(n.key) - 1
+		}
+		return i.checkLower()
+	}
 
-	for n != nil && !n.IsLeaf() {
-		n = n.children[0]
+	return i.seekBefore(i.opts.UpperBound, i.opts.UpperInclusive)
+}
+
+// SeekGE moves to the first key >= key that is inside the bounds and reports
+// whether the iterator is valid. Keys below the lower bound behave like First.
+func (i *iterator) SeekGE(key []byte) (valid bool) {
+	if i.closed {
+		return false
+	}
+	i.err = nil
+	defer i.recoverCorruption()
+
+	if i.opts.LowerBound != nil && bytes.Compare(key, i.opts.LowerBound) <= 0 {
+		return i.First()
 	}
 
-	idx := 0
-	return &iterator{node: n, idx: idx}
+	i.node, i.idx = i.tree.findLeafPosition(key)
+	i.normalizeForward()
+	return i.checkUpper()
 }
 
-func (b *BTree) SeekLast() *iterator {
-	if b.root == nil {
-		return nil
+// SeekLT moves to the last key < key that is inside the bounds and reports
+// whether the iterator is valid. Keys above the upper bound behave like Last.
+func (i *iterator) SeekLT(key []byte) (valid bool) {
+	if i.closed {
+		return false
 	}
+	i.err = nil
+	defer i.recoverCorruption()
 
-	n := b.root
+	if i.opts.UpperBound != nil && bytes.Compare(key, i.opts.UpperBound) > 0 {
+		return i.Last()
+	}
+	return i.seekBefore(key, false)
+}
 
-	for n != nil && !n.IsLeaf() {
-		n = n.children[len(n.children)-1]
+// SeekLE moves to the last key <= key that is inside the bounds and reports
+// whether the iterator is valid. Keys at or above the upper bound behave like
+// Last.
+func (i *iterator) SeekLE(key []byte) (valid bool) {
+	if i.closed {
+		return false
 	}
+	i.err = nil
+	defer i.recoverCorruption()
 
-	idx := len(n.key) - 1
-	return &iterator{node: n, idx: idx}
+	if i.opts.UpperBound != nil && bytes.Compare(key, i.opts.UpperBound) >= 0 {
+		return i.Last()
+	}
+	return i.seekBefore(key, true)
 }
 
-func (i *iterator) Next() {
+func (i *iterator) Next() (valid bool) {
 	if !i.Valid() {
-		return
+		return false
 	}
+	defer i.recoverCorruption()
 
-	if i.idx+1 < len(i.node.key) {
-		i.idx++
+	i.step()
+	return i.checkUpper()
+}
+
+func (i *iterator) Prev() (valid bool) {
+	if !i.Valid() {
+		return false
+	}
+	defer i.recoverCorruption()
+
+	i.stepBack()
+	return i.checkLower()
+}
+
+// seekBefore moves to the last key < key, or <= key if inclusive, and checks
+// it against the lower bound.
+func (i *iterator) seekBefore(key []byte, inclusive bool) bool {
+	if inclusive {
+		i.node, i.idx = i.tree.findLeafPositionAfter(key)
 	} else {
-		if i.node.next != nil {
-			i.node = i.node.next
-			i.idx = 0
-		} else {
-			i.node = nil
+		i.node, i.idx = i.tree.findLeafPosition(key)
+	}
+	if i.node == nil {
+		return false
+	}
+
+	// idx is the first key past the ones we want, step back one
+	i.idx--
+	if i.idx < 0 {
+		i.node = i.tree.leafBefore(i.node)
+		if i.node != nil {
+			i.idx = len(i.node.key) - 1
 		}
 	}
+	return i.checkLower()
 }
 
-func (i *iterator) Prev() {
-	if !i.Valid() {
-		return
+// step moves one key forward without looking at the bounds.
+func (i *iterator) step() {
+	if i.idx+1 < len(i.node.key) {
+		i.idx++
+	} else {
+		i.node, i.idx = i.tree.leafAfter(i.node), 0
 	}
+}
 
+// stepBack moves one key backward without looking at the bounds.
+func (i *iterator) stepBack() {
 	if i.idx-1 >= 0 {
 		i.idx--
 	} else {
-		if i.node.prev != nil {
-			i.node = i.node.prev
+		i.node = i.tree.leafBefore(i.node)
+		if i.node != nil {
 			i.idx = len(i.node.key) - 1
-		} else {
+		}
+	}
+}
+
+// normalizeForward moves a position that is past the end of its leaf to the
+// start of the next leaf.
+func (i *iterator) normalizeForward() {
+	if i.node != nil && i.idx >= len(i.node.key) {
+		i.node, i.idx = i.tree.leafAfter(i.node), 0
+	}
+}
+
+// checkUpper moves forward past expired entries and invalidates the iterator
+// if it ends up past the upper bound.
+func (i *iterator) checkUpper() bool {
+	for {
+		if !i.Valid() {
 			i.node = nil
+			return false
+		}
+		if i.opts.UpperBound != nil {
+			c := i.node.compareKey(i.idx, i.opts.UpperBound)
+			if c > 0 || (c == 0 && !i.opts.UpperInclusive) {
+				i.node = nil
+				return false
+			}
+		}
+		if !i.tree.rawExpired(i.node.value[i.idx]) {
+			return true
 		}
+		i.step()
+	}
+}
+
+// checkLower moves backward past expired entries and invalidates the
+// iterator if it ends up before the lower bound.
+func (i *iterator) checkLower() bool {
+	for {
+		if !i.Valid() {
+			i.node = nil
+			return false
+		}
+		if i.opts.LowerBound != nil {
+			c := i.node.compareKey(i.idx, i.opts.LowerBound)
+			if c < 0 || (c == 0 && i.opts.LowerExclusive) {
+				i.node = nil
+				return false
+			}
+		}
+		if !i.tree.rawExpired(i.node.value[i.idx]) {
+			return true
+		}
+		i.stepBack()
 	}
 }
 
@@ -109,16 +346,111 @@ func (i *iterator) Key() []byte {
 		return nil
 	}
 
-	return i.node.key[i.idx]
+	n := i.node
+	if len(n.prefix) == 0 {
+		return n.key[i.idx]
+	}
+
+	// keys already handed out stay in the old buffer, like slabs
+	size := len(n.prefix) + len(n.key[i.idx])
+	if cap(i.buf)-len(i.buf) < size {
+		i.buf = make([]byte, 0, max(minSlabSize, 2*size))
+	}
+	start := len(i.buf)
+	i.buf = n.appendKey(i.buf, i.idx)
+	return i.buf[start:len(i.buf):len(i.buf)]
 }
 
+// Value returns the value at the current position. If it can't be read, for
+// example because a merge operator fails, Value returns nil and Error reports
+// why.
 func (i *iterator) Value() []byte {
 	if !i.Valid() {
 		return nil
 	}
-	return i.node.value[i.idx]
+	defer i.recoverCorruption()
+
+	// expired entries are skipped when the iterator moves, so an entry that
+	// expires while the iterator sits on it is still returned
+	// only merge operators need the key
+	var key []byte
+	if i.tree.usesCells() {
+		key = i.Key()
+	}
+	v, err := i.tree.readValue(key, i.node.value[i.idx])
+	if err != nil {
+		i.err = err
+		return nil
+	}
+	return v
 }
 
 func (i *iterator) Valid() bool {
 	return i.node != nil && i.idx >= 0 && i.idx < len(i.node.key)
 }
+
+func (i *iterator) Error() error {
+	return i.err
+}
+
+func (i *iterator) Close() error {
+	if i.closed {
+		return nil
+	}
+	i.closed = true
+	i.node = nil
+	i.tree = nil
+	i.err = ErrClosed
+	return nil
+}
+
+// findLeafPosition returns the leaf key would live in and the index of the
+// first key >= key in it, which is len(leaf.key) if every key is smaller.
+func (b *BTree) findLeafPosition(key []byte) (*Node, int) {
+	n := b.root
+	if n == nil {
+		return nil, 0
+	}
+
+	for !n.IsLeaf() {
+		if b.dups != DupNone {
+			// duplicates of key may sit left of an equal separator
+			n = b.traverseLowerBound(n, key)
+		} else {
+			n = b.traverseRightOrLeft(n, key)
+		}
+	}
+
+	return n, n.lowerBound(key)
+}
+
+// findLeafPositionAfter is findLeafPosition for the first key > key. Equal
+// keys stay to the left, which in a multimap means after every duplicate.
+func (b *BTree) findLeafPositionAfter(key []byte) (*Node, int) {
+	n := b.root
+	if n == nil {
+		return nil, 0
+	}
+
+	for !n.IsLeaf() {
+		n = b.traverseRightOrLeft(n, key)
+	}
+
+	return n, n.upperBound(key)
+}
+
+func (b *BTree) leftmostLeaf() *Node {
+	n := b.root
+	for n != nil && !n.IsLeaf() {
+		n = n.children[0]
+	}
+	return n
+}
+
+func (b *BTree) rightmostLeaf() *Node {
+	n := b.root
+	for n != nil && !n.IsLeaf() {
+		n = n.children[len(n.children)-1]
+	}
+	return n
+}
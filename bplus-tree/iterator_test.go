@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"testing"
 
+	"storage-engine/keyenc"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -169,3 +171,377 @@ func TestEmptyTree(t *testing.T) {
 	ite := b.SeekFirst()
 	assert.True(t, ite == nil)
 }
+
+func TestIntKeys_NegativeOrder(t *testing.T) {
+	b := New(3)
+	for i := -10; i < 10; i++ {
+		b.InsertInt(i, []byte(fmt.Sprintf("Value for %d", i)))
+	}
+
+	keys := make([]int, 0)
+	for ite := b.SeekFirst(); ite.Valid(); ite.Next() {
+		keys = append(keys, convertBytetoInt(ite.Key()))
+	}
+	for i := range keys {
+		assert.Equal(t, i-10, keys[i])
+	}
+
+	ite, err := b.SeekInt(-3)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("Value for -3"), ite.Value())
+
+	v, err := b.GetInt(-1)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("Value for -1"), v)
+}
+
+func TestMigrateLegacyIntKeys(t *testing.T) {
+	b := New(3)
+	for i := -5; i < 5; i++ {
+		b.Insert(keyenc.EncodeLegacyInt(i), []byte(fmt.Sprintf("Value for %d", i)))
+	}
+	b.Insert([]byte("name"), []byte("not an int"))
+
+	n, err := b.MigrateLegacyIntKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, 10, n)
+
+	for i := -5; i < 5; i++ {
+		v, err := b.GetInt(i)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("Value for %d", i)), v)
+	}
+
+	v, err := b.Get([]byte("name"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("not an int"), v)
+
+	// the first int key is now the most negative one
+	ite, err := b.SeekInt(-100)
+	assert.NoError(t, err)
+	assert.Equal(t, -5, convertBytetoInt(ite.Key()))
+
+	// a second run leaves the keys alone
+	n, err = b.MigrateLegacyIntKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	v, err = b.GetInt(-3)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("Value for -3"), v)
+}
+
+func TestMigrateLegacyIntKeys_Failure(t *testing.T) {
+	b := New(2, WithMergeOperator(Int64AddOperator{}))
+	assert.NoError(t, b.RegisterIndex("all", func(key, value []byte) [][]byte {
+		return [][]byte{[]byte("all")}
+	}))
+	for i := range 20 {
+		assert.NoError(t, b.Insert(keyenc.EncodeLegacyInt(i), Int64Value(int64(i))))
+	}
+
+	// a value that can't be read makes indexing it during the migration fail
+	leaf := b.rightmostLeaf()
+	leaf.value[len(leaf.value)-1] = []byte{0xff}
+
+	_, err := b.MigrateLegacyIntKeys()
+	assert.Error(t, err)
+
+	// the tree and the index are as they were
+	v, err := b.Get(keyenc.EncodeLegacyInt(3))
+	assert.NoError(t, err)
+	assert.Equal(t, Int64Value(3), v)
+	keys, err := b.findIndex("all").tree.GetAll([]byte("all"))
+	assert.NoError(t, err)
+	assert.Len(t, keys, 20)
+	assert.Equal(t, keyenc.EncodeLegacyInt(0), keys[0])
+}
+
+func collectForward(ite Iterator) []int {
+	keys := make([]int, 0)
+	for ; ite.Valid(); ite.Next() {
+		keys = append(keys, convertBytetoInt(ite.Key()))
+	}
+	return keys
+}
+
+func collectBackward(ite Iterator) []int {
+	keys := make([]int, 0)
+	for ; ite.Valid(); ite.Prev() {
+		keys = append(keys, convertBytetoInt(ite.Key()))
+	}
+	return keys
+}
+
+func TestBoundedIterator(t *testing.T) {
+	b := New(3)
+	for i := 0; i < 40; i += 2 { // 0, 2, ..., 38
+		b.InsertInt(i, []byte(fmt.Sprintf("Value for %d", i)))
+	}
+
+	tests := []struct {
+		name     string
+		opts     IterOptions
+		expected []int
+	}{
+		{
+			name:     "default inclusive lower exclusive upper",
+			opts:     IterOptions{LowerBound: convertIntToByte(10), UpperBound: convertIntToByte(16)},
+			expected: []int{10, 12, 14},
+		},
+		{
+			name: "exclusive lower inclusive upper",
+			opts: IterOptions{
+				LowerBound: convertIntToByte(10), UpperBound: convertIntToByte(16),
+				LowerExclusive: true, UpperInclusive: true,
+			},
+			expected: []int{12, 14, 16},
+		},
+		{
+			name:     "bounds between keys",
+			opts:     IterOptions{LowerBound: convertIntToByte(9), UpperBound: convertIntToByte(15)},
+			expected: []int{10, 12, 14},
+		},
+		{
+			name:     "only upper bound",
+			opts:     IterOptions{UpperBound: convertIntToByte(5)},
+			expected: []int{0, 2, 4},
+		},
+		{
+			name:     "only lower bound",
+			opts:     IterOptions{LowerBound: convertIntToByte(33)},
+			expected: []int{34, 36, 38},
+		},
+		{
+			name:     "empty range",
+			opts:     IterOptions{LowerBound: convertIntToByte(11), UpperBound: convertIntToByte(12)},
+			expected: []int{},
+		},
+		{
+			name:     "range past all keys",
+			opts:     IterOptions{LowerBound: convertIntToByte(100), UpperBound: convertIntToByte(200)},
+			expected: []int{},
+		},
+		{
+			name:     "range before all keys",
+			opts:     IterOptions{LowerBound: convertIntToByte(-10), UpperBound: convertIntToByte(0)},
+			expected: []int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ite := b.NewIter(&tt.opts)
+			assert.False(t, ite.Valid())
+
+			ite.First()
+			assert.Equal(t, tt.expected, collectForward(ite))
+
+			reversed := make([]int, 0, len(tt.expected))
+			for i := len(tt.expected) - 1; i >= 0; i-- {
+				reversed = append(reversed, tt.expected[i])
+			}
+			ite.Last()
+			assert.Equal(t, reversed, collectBackward(ite))
+		})
+	}
+}
+
+func TestBoundedIterator_SeekGE(t *testing.T) {
+	b := New(3)
+	for i := range 30 {
+		b.InsertInt(i, []byte(fmt.Sprintf("Value for %d", i)))
+	}
+
+	ite := b.NewIter(&IterOptions{LowerBound: convertIntToByte(10), UpperBound: convertIntToByte(20)})
+
+	// below the lower bound clamps to it
+	assert.True(t, ite.SeekGE(convertIntToByte(3)))
+	assert.Equal(t, 10, convertBytetoInt(ite.Key()))
+
+	assert.True(t, ite.SeekGE(convertIntToByte(19)))
+	assert.Equal(t, 19, convertBytetoInt(ite.Key()))
+	ite.Next()
+	assert.False(t, ite.Valid())
+
+	assert.False(t, ite.SeekGE(convertIntToByte(25)))
+}
+
+func TestScanPrefix(t *testing.T) {
+	b := New(3)
+	for _, k := range []string{"app", "apple", "apply", "apricot", "banana", "ap", "b"} {
+		b.Insert([]byte(k), []byte(k))
+	}
+	b.Insert([]byte{0xff, 0xff}, []byte("max"))
+	b.Insert([]byte{0xff, 0xff, 0x01}, []byte("max"))
+
+	keys := func(ite Iterator) []string {
+		out := make([]string, 0)
+		for ; ite.Valid(); ite.Next() {
+			out = append(out, string(ite.Key()))
+		}
+		return out
+	}
+
+	assert.Equal(t, []string{"app", "apple", "apply"}, keys(b.ScanPrefix([]byte("app"))))
+	assert.Equal(t, []string{"ap", "app", "apple", "apply", "apricot"}, keys(b.ScanPrefix([]byte("ap"))))
+	assert.Equal(t, []string{}, keys(b.ScanPrefix([]byte("c"))))
+	assert.Len(t, keys(b.ScanPrefix(nil)), 9)
+
+	// a prefix without a successor is only bounded below
+	assert.Equal(t, []string{"\xff\xff", "\xff\xff\x01"}, keys(b.ScanPrefix([]byte{0xff, 0xff})))
+
+	// SeekLast within a prefix lands on the last matching key
+	ite := b.ScanPrefix([]byte("ap"))
+	ite.Last()
+	assert.Equal(t, "apricot", string(ite.Key()))
+	ite.Prev()
+	assert.Equal(t, "apply", string(ite.Key()))
+}
+
+func TestBoundedIterator_EmptyTree(t *testing.T) {
+	b := New(3)
+
+	ite := b.NewIter(&IterOptions{LowerBound: []byte("a"), UpperBound: []byte("z")})
+	assert.False(t, ite.First())
+	assert.False(t, ite.Last())
+	assert.False(t, ite.SeekGE([]byte("m")))
+	assert.False(t, b.ScanPrefix([]byte("a")).Valid())
+}
+
+func TestIterator_SeekLTAndSeekLE(t *testing.T) {
+	b := New(3)
+	for i := 0; i < 40; i += 2 { // 0, 2, ..., 38
+		b.InsertInt(i, []byte(fmt.Sprintf("Value for %d", i)))
+	}
+
+	ite := b.NewIter(nil)
+
+	assert.True(t, ite.SeekLE(convertIntToByte(10)))
+	assert.Equal(t, 10, convertBytetoInt(ite.Key()))
+
+	assert.True(t, ite.SeekLE(convertIntToByte(11)))
+	assert.Equal(t, 10, convertBytetoInt(ite.Key()))
+
+	assert.True(t, ite.SeekLT(convertIntToByte(10)))
+	assert.Equal(t, 8, convertBytetoInt(ite.Key()))
+
+	assert.True(t, ite.SeekLT(convertIntToByte(100)))
+	assert.Equal(t, 38, convertBytetoInt(ite.Key()))
+
+	assert.False(t, ite.SeekLT(convertIntToByte(0)))
+	assert.False(t, ite.SeekLE(convertIntToByte(-1)))
+
+	assert.True(t, ite.SeekLE(convertIntToByte(0)))
+	assert.Equal(t, 0, convertBytetoInt(ite.Key()))
+	assert.True(t, ite.Next())
+	assert.Equal(t, 2, convertBytetoInt(ite.Key()))
+}
+
+func TestIterator_SeekLTWithBounds(t *testing.T) {
+	b := New(3)
+	for i := range 30 {
+		b.InsertInt(i, []byte(fmt.Sprintf("Value for %d", i)))
+	}
+
+	ite := b.NewIter(&IterOptions{LowerBound: convertIntToByte(10), UpperBound: convertIntToByte(20)})
+
+	// above the upper bound clamps to the last key inside it
+	assert.True(t, ite.SeekLT(convertIntToByte(25)))
+	assert.Equal(t, 19, convertBytetoInt(ite.Key()))
+
+	assert.True(t, ite.SeekLE(convertIntToByte(20)))
+	assert.Equal(t, 19, convertBytetoInt(ite.Key()))
+
+	assert.True(t, ite.SeekLE(convertIntToByte(15)))
+	assert.Equal(t, 15, convertBytetoInt(ite.Key()))
+
+	assert.False(t, ite.SeekLT(convertIntToByte(10)))
+	assert.False(t, ite.SeekLE(convertIntToByte(5)))
+}
+
+func TestIterator_Reposition(t *testing.T) {
+	b := New(3)
+	for i := range 20 {
+		b.InsertInt(i, []byte(fmt.Sprintf("Value for %d", i)))
+	}
+
+	ite := b.NewIter(nil)
+	for _, k := range []int{5, 17, 0, 12} {
+		assert.True(t, ite.SeekGE(convertIntToByte(k)))
+		assert.Equal(t, k, convertBytetoInt(ite.Key()))
+	}
+
+	assert.True(t, ite.Last())
+	assert.Equal(t, 19, convertBytetoInt(ite.Key()))
+	assert.False(t, ite.Next())
+
+	assert.True(t, ite.First())
+	assert.Equal(t, 0, convertBytetoInt(ite.Key()))
+	assert.False(t, ite.Prev())
+	assert.NoError(t, ite.Error())
+}
+
+func TestIterator_RepositionClearsError(t *testing.T) {
+	b := New(3, WithMergeOperator(Int64AddOperator{}))
+	assert.NoError(t, b.Merge([]byte("a"), Int64Value(1)))
+	assert.NoError(t, b.Merge([]byte("b"), []byte("boom")))
+
+	ite := b.NewIter(nil)
+	assert.True(t, ite.Last())
+	assert.Nil(t, ite.Value())
+	assert.ErrorIs(t, ite.Error(), ErrMergeOperand)
+	// a value that can't be read leaves the iterator where it was
+	assert.True(t, ite.Valid())
+
+	for _, reposition := range []func() bool{
+		ite.First,
+		func() bool { return ite.SeekGE([]byte("a")) },
+		func() bool { return ite.SeekLT([]byte("b")) },
+		func() bool { return ite.SeekLE([]byte("a")) },
+	} {
+		ite.Last()
+		ite.Value()
+		assert.True(t, reposition())
+		assert.NoError(t, ite.Error())
+		assert.Equal(t, Int64Value(1), ite.Value())
+	}
+
+	keys := 0
+	for range Pairs(ite) {
+		keys++
+	}
+	assert.Equal(t, 1, keys)
+}
+
+func TestIterator_Close(t *testing.T) {
+	b := New(3)
+	for i := range 10 {
+		b.InsertInt(i, []byte(fmt.Sprintf("Value for %d", i)))
+	}
+
+	ite := b.SeekFirst()
+	assert.True(t, ite.Valid())
+
+	assert.NoError(t, ite.Close())
+	assert.False(t, ite.Valid())
+	assert.False(t, ite.First())
+	assert.False(t, ite.SeekGE(convertIntToByte(3)))
+	assert.Error(t, ite.Error())
+
+	// closing twice is fine
+	assert.NoError(t, ite.Close())
+}
+
+// iterators can be stored in structs through the exported interface
+type cursor struct {
+	it Iterator
+}
+
+func TestIterator_InStruct(t *testing.T) {
+	b := New(3)
+	b.InsertInt(1, []byte("one"))
+
+	c := cursor{it: b.NewIter(nil)}
+	assert.True(t, c.it.First())
+	assert.Equal(t, []byte("one"), c.it.Value())
+}
@@ -0,0 +1,40 @@
+package bplustree
+
+import (
+	"storage-engine/common"
+	"storage-engine/layout"
+)
+
+// Keys in a node tend to share long prefixes (tenant ids, paths), so nodes
+// don't store them in full, in memory as on a page (see package layout):
+// separators in internal nodes are cut down to the shortest key that still
+// tells the two children apart, and a leaf keeps the prefix shared by all of
+// its keys once, see arena.go.
+
+// leafSeparator returns the separator to store in the parent between the
+// leaves left and right: the shortest key bigger than every key in left and
+// no bigger than the first key of right.
+func leafSeparator(left, right *Node) []byte {
+	common.Assert(len(right.key) > 0, "no separator for an empty right leaf")
+	if len(left.key) == 0 {
+		return right.leafKey(0)
+	}
+	return layout.ShortestSeparator(left.leafKey(len(left.key)-1), right.leafKey(0))
+}
+
+// encodeLeaf writes the leaf n in the page layout.
+func encodeLeaf(n *Node) []byte {
+	common.Assert(n.IsLeaf(), "encodeLeaf called with an internal node")
+	return layout.EncodeLeaf(n.prefix, n.key, n.value)
+}
+
+// decodeLeaf reads a leaf written by encodeLeaf. The page prefix becomes the
+// leaf's prefix, so the result is a regular in-memory leaf; keys and values
+// point into page.
+func decodeLeaf(page []byte) (*Node, error) {
+	prefix, keys, values, err := layout.DecodeLeaf(page)
+	if err != nil {
+		return nil, err
+	}
+	return &Node{prefix: prefix, key: keys, value: values}, nil
+}
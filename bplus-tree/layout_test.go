@@ -0,0 +1,82 @@
+package bplustree
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuffixTruncation(t *testing.T) {
+	b := New(2)
+	keys := make([]string, 0)
+	for i := range 200 {
+		keys = append(keys, fmt.Sprintf("tenant-0042/objects/%08d/name", i))
+	}
+	rand.New(rand.NewSource(1)).Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+
+	for _, k := range keys {
+		assert.NoError(t, b.Insert([]byte(k), []byte(k)))
+	}
+
+	// every separator is shorter than the keys it was cut from
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if n.IsLeaf() {
+			return
+		}
+		for _, sep := range n.key {
+			assert.Less(t, len(sep), len(keys[0]))
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(b.root)
+
+	for _, k := range keys {
+		v, err := b.Get([]byte(k))
+		assert.NoError(t, err)
+		assert.Equal(t, k, string(v))
+	}
+
+	for _, k := range keys[:150] {
+		assert.NoError(t, b.Delete([]byte(k)))
+	}
+	for _, k := range keys[150:] {
+		_, err := b.Get([]byte(k))
+		assert.NoError(t, err)
+	}
+}
+
+func TestLeafLayout(t *testing.T) {
+	n := &Node{}
+	for i := range 10 {
+		n.key = append(n.key, []byte(fmt.Sprintf("/home/user/documents/%02d.txt", i)))
+		n.value = append(n.value, []byte(fmt.Sprint(i)))
+	}
+
+	page := encodeLeaf(n)
+	full := 0
+	for i := range n.key {
+		full += len(n.key[i]) + len(n.value[i])
+	}
+	assert.Less(t, len(page), full/2)
+
+	got, err := decodeLeaf(page)
+	assert.NoError(t, err)
+	// the shared prefix becomes the prefix of the decoded leaf
+	assert.Equal(t, "/home/user/documents/0", string(got.prefix))
+	for i := range n.key {
+		assert.Equal(t, n.key[i], got.leafKey(i))
+	}
+	assert.Equal(t, n.value, got.value)
+
+	empty, err := decodeLeaf(encodeLeaf(&Node{}))
+	assert.NoError(t, err)
+	assert.Empty(t, empty.key)
+
+	_, err = decodeLeaf(page[:len(page)-1])
+	assert.Error(t, err)
+}
@@ -0,0 +1,226 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"storage-engine/common"
+)
+
+// MergeOperator combines a value with a list of updates (operands) recorded
+// by BTree.Merge. It lets callers do blind writes, such as incrementing a
+// counter, without reading the current value first.
+type MergeOperator interface {
+	// Name identifies the operator, so data written with it can be matched
+	// with the same operator later.
+	Name() string
+	// Merge applies operands, oldest first, on top of existing. existing is
+	// nil if the key had no value before the first operand.
+	Merge(key, existing []byte, operands [][]byte) ([]byte, error)
+}
+
+// maxPendingOperands is how many operands a key collects before Merge folds
+// them eagerly, so reads don't get slower without bound.
+const maxPendingOperands = 16
+
+// WithMergeOperator enables BTree.Merge using op. It can't be combined with
+// WithDuplicates.
+func WithMergeOperator(op MergeOperator) Option {
+	return func(b *BTree) {
+		b.mergeOp = op
+	}
+}
+
+// Merge records operand as an update to key. Operands are combined with the
+// current value lazily when the key is read through Get or an iterator, and
+// folded into a plain value by FoldMerges, by Insert or after
+// maxPendingOperands merges to the same key.
+func (b *BTree) Merge(key, operand []byte) error {
+	if b.mergeOp == nil {
+		return ErrNoMergeOperator
+	}
+
+	return b.updateRaw(key, func(raw []byte, exists bool) ([]byte, updateOp, error) {
+		c := cell{flags: cellMerge}
+
+		if exists {
+			old, err := decodeCell(raw)
+			if err != nil {
+				return nil, opKeep, err
+			}
+			switch {
+			case b.cellExpired(old):
+				// start over as if the key was missing
+			case old.isMerge():
+				c = old
+			default:
+				c.value, c.hasBase = old.value, true
+				c = c.withExpiryOf(old)
+			}
+		}
+		c.operands = append(c.operands, operand)
+
+		if len(c.operands) >= maxPendingOperands {
+			folded, err := b.foldCell(key, c)
+			if err != nil {
+				return nil, opKeep, err
+			}
+			c = folded
+		}
+		return encodeCell(c), opStore, nil
+	})
+}
+
+// FoldMerges applies every pending merge operand in the tree and stores the
+// results as plain values. It is the compaction step for merge operands:
+// nothing calls it on its own, and between calls operands are only folded per
+// key, once maxPendingOperands pile up. Run it when the tree goes quiet or
+// before handing its pages to storage, so readers don't pay for the folding.
+func (b *BTree) FoldMerges() (err error) {
+	if b.mergeOp == nil {
+		return nil
+	}
+	if err := b.writable(); err != nil {
+		return err
+	}
+	defer b.recoverCorruption(&err)
+
+	for n := b.leftmostLeaf(); n != nil; n = n.next {
+		for i := range n.key {
+			c, err := decodeCell(n.value[i])
+			if err != nil {
+				return err
+			}
+			if !c.isMerge() {
+				continue
+			}
+			if c, err = b.foldCell(n.leafKey(i), c); err != nil {
+				return err
+			}
+			n.value[i] = n.store(encodeCell(c))
+		}
+	}
+	return nil
+}
+
+func (b *BTree) foldCell(key []byte, c cell) (cell, error) {
+	var base []byte
+	if c.hasBase {
+		base = c.value
+	}
+
+	var (
+		merged []byte
+		err    error
+	)
+	b.callback(func() { merged, err = b.mergeOp.Merge(key, base, c.operands) })
+	if err != nil {
+		return cell{}, err
+	}
+	return cell{value: merged}.withExpiryOf(c), nil
+}
+
+var (
+	registryMu     sync.RWMutex
+	mergeOperators = make(map[string]MergeOperator)
+)
+
+// RegisterMergeOperator makes op available through LookupMergeOperator under
+// op.Name(), for code that only knows which operator data was written with by
+// name. Registering the same name twice panics.
+func RegisterMergeOperator(op MergeOperator) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	_, dup := mergeOperators[op.Name()]
+	common.Assert(!dup, "merge operator %q registered twice", op.Name())
+	mergeOperators[op.Name()] = op
+}
+
+func LookupMergeOperator(name string) (MergeOperator, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	op, ok := mergeOperators[name]
+	return op, ok
+}
+
+func init() {
+	RegisterMergeOperator(Int64AddOperator{})
+	RegisterMergeOperator(Int64MaxOperator{})
+	RegisterMergeOperator(AppendOperator{})
+}
+
+// Int64AddOperator treats the value and operands as 8 byte big-endian int64s
+// and adds them up. A missing value counts as 0.
+type Int64AddOperator struct{}
+
+func (Int64AddOperator) Name() string { return "int64add" }
+
+func (Int64AddOperator) Merge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	return foldInt64(existing, operands, func(acc, v int64) int64 { return acc + v })
+}
+
+// Int64MaxOperator treats the value and operands as 8 byte big-endian int64s
+// and keeps the largest.
+type Int64MaxOperator struct{}
+
+func (Int64MaxOperator) Name() string { return "int64max" }
+
+func (Int64MaxOperator) Merge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	if existing == nil && len(operands) > 0 {
+		existing, operands = operands[0], operands[1:]
+	}
+	return foldInt64(existing, operands, func(acc, v int64) int64 { return max(acc, v) })
+}
+
+// AppendOperator appends every operand to the value.
+type AppendOperator struct{}
+
+func (AppendOperator) Name() string { return "append" }
+
+func (AppendOperator) Merge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	size := len(existing)
+	for _, op := range operands {
+		size += len(op)
+	}
+
+	out := make([]byte, 0, size)
+	out = append(out, existing...)
+	for _, op := range operands {
+		out = append(out, op...)
+	}
+	return out, nil
+}
+
+// Int64Value encodes n the way Int64AddOperator and Int64MaxOperator expect.
+func Int64Value(n int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(n))
+}
+
+func decodeInt64Value(b []byte) (int64, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("%w: expected 8 byte int64, got %d bytes", ErrMergeOperand, len(b))
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+func foldInt64(existing []byte, operands [][]byte, fn func(acc, v int64) int64) ([]byte, error) {
+	var acc int64
+	if existing != nil {
+		var err error
+		if acc, err = decodeInt64Value(existing); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, op := range operands {
+		v, err := decodeInt64Value(op)
+		if err != nil {
+			return nil, err
+		}
+		acc = fn(acc, v)
+	}
+	return Int64Value(acc), nil
+}
@@ -0,0 +1,189 @@
+package bplustree
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"storage-engine/common"
+)
+
+func TestMerge_Int64Add(t *testing.T) {
+	b := New(3, WithMergeOperator(Int64AddOperator{}))
+
+	// blind increments on a missing key
+	for range 5 {
+		assert.NoError(t, b.Merge([]byte("hits"), Int64Value(2)))
+	}
+	v, err := b.Get([]byte("hits"))
+	assert.NoError(t, err)
+	assert.Equal(t, Int64Value(10), v)
+
+	// merges on top of a regular value
+	assert.NoError(t, b.Insert([]byte("balance"), Int64Value(100)))
+	assert.NoError(t, b.Merge([]byte("balance"), Int64Value(-30)))
+	v, err = b.Get([]byte("balance"))
+	assert.NoError(t, err)
+	assert.Equal(t, Int64Value(70), v)
+
+	// Insert replaces pending operands
+	assert.NoError(t, b.Insert([]byte("hits"), Int64Value(1)))
+	assert.NoError(t, b.Merge([]byte("hits"), Int64Value(1)))
+	v, err = b.Get([]byte("hits"))
+	assert.NoError(t, err)
+	assert.Equal(t, Int64Value(2), v)
+}
+
+func TestMerge_Int64Max(t *testing.T) {
+	b := New(3, WithMergeOperator(Int64MaxOperator{}))
+
+	for _, n := range []int64{-5, 12, 3, -100} {
+		assert.NoError(t, b.Merge([]byte("peak"), Int64Value(n)))
+	}
+	v, err := b.Get([]byte("peak"))
+	assert.NoError(t, err)
+	assert.Equal(t, Int64Value(12), v)
+
+	// a single negative operand on a missing key stays negative
+	assert.NoError(t, b.Merge([]byte("low"), Int64Value(-7)))
+	v, err = b.Get([]byte("low"))
+	assert.NoError(t, err)
+	assert.Equal(t, Int64Value(-7), v)
+}
+
+func TestMerge_Append(t *testing.T) {
+	b := New(2, WithMergeOperator(AppendOperator{}))
+
+	for i := range 40 {
+		key := []byte(fmt.Sprintf("list%d", i%4))
+		assert.NoError(t, b.Merge(key, []byte{byte('a' + i/4)}))
+	}
+
+	// lazily merged values show up through iterators too
+	keys := make([]string, 0)
+	for k, v := range b.All() {
+		keys = append(keys, string(k))
+		assert.Equal(t, []byte("abcdefghij"), v)
+	}
+	assert.Equal(t, []string{"list0", "list1", "list2", "list3"}, keys)
+
+	assert.NoError(t, b.FoldMerges())
+	v, err := b.Get([]byte("list2"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("abcdefghij"), v)
+
+	// the folded values are copied into the slabs of their leaves
+	inSlab := func(n *Node, v []byte) bool {
+		slab := n.arena[:cap(n.arena)]
+		for i := range slab {
+			if &slab[i] == &v[0] {
+				return true
+			}
+		}
+		return false
+	}
+	for n := b.leftmostLeaf(); n != nil; n = n.next {
+		for _, v := range n.value {
+			assert.True(t, inSlab(n, v))
+		}
+	}
+}
+
+func TestMerge_EagerFold(t *testing.T) {
+	b := New(3, WithMergeOperator(Int64AddOperator{}))
+
+	for range maxPendingOperands*3 + 1 {
+		assert.NoError(t, b.Merge([]byte("k"), Int64Value(1)))
+	}
+
+	// the stored cell never collects more than maxPendingOperands operands
+	n, idx := b.findLeafPosition([]byte("k"))
+	c, err := decodeCell(n.value[idx])
+	assert.NoError(t, err)
+	assert.Less(t, len(c.operands), maxPendingOperands)
+
+	v, err := b.Get([]byte("k"))
+	assert.NoError(t, err)
+	assert.Equal(t, Int64Value(int64(maxPendingOperands*3+1)), v)
+}
+
+func TestMerge_OperatorError(t *testing.T) {
+	b := New(3, WithMergeOperator(Int64AddOperator{}))
+
+	assert.NoError(t, b.Merge([]byte("k"), []byte("not an int")))
+	_, err := b.Get([]byte("k"))
+	assert.ErrorIs(t, err, ErrMergeOperand)
+
+	ite := b.SeekFirst()
+	assert.Nil(t, ite.Value())
+	assert.Error(t, ite.Error())
+
+	assert.Error(t, b.Update([]byte("k"), func(old []byte, exists bool) ([]byte, bool) {
+		return old, false
+	}))
+}
+
+func TestMerge_WithUpdate(t *testing.T) {
+	b := New(3, WithMergeOperator(Int64AddOperator{}))
+
+	b.Merge([]byte("k"), Int64Value(5))
+	b.Merge([]byte("k"), Int64Value(5))
+
+	swapped, err := b.CompareAndSwap([]byte("k"), Int64Value(10), Int64Value(0))
+	assert.NoError(t, err)
+	assert.True(t, swapped)
+
+	v, _ := b.Get([]byte("k"))
+	assert.Equal(t, Int64Value(0), v)
+}
+
+func TestMerge_NoOperator(t *testing.T) {
+	b := New(3)
+	assert.ErrorIs(t, b.Merge([]byte("k"), []byte("v")), ErrNoMergeOperator)
+}
+
+func TestMergeOperatorRegistry(t *testing.T) {
+	for _, name := range []string{"int64add", "int64max", "append"} {
+		op, ok := LookupMergeOperator(name)
+		assert.True(t, ok)
+		assert.Equal(t, name, op.Name())
+	}
+
+	_, ok := LookupMergeOperator("missing")
+	assert.False(t, ok)
+
+	assert.Panics(t, func() { RegisterMergeOperator(AppendOperator{}) })
+}
+
+func TestCell_RoundTrip(t *testing.T) {
+	cells := []cell{
+		{value: []byte("plain")},
+		{value: []byte{}},
+		{flags: cellMerge, operands: [][]byte{[]byte("a"), {}}},
+		{flags: cellMerge, hasBase: true, value: []byte("base"), operands: [][]byte{[]byte("op")}},
+	}
+	for _, c := range cells {
+		got, err := decodeCell(encodeCell(c))
+		assert.NoError(t, err)
+		assert.Equal(t, c.flags, got.flags)
+		assert.Equal(t, c.hasBase, got.hasBase)
+		assert.Equal(t, string(c.value), string(got.value))
+		assert.Equal(t, len(c.operands), len(got.operands))
+	}
+
+	_, err := decodeCell(nil)
+	assert.Error(t, err)
+	_, err = decodeCell([]byte{cellMerge, 1, 10, 'a'})
+	assert.Error(t, err)
+	// an absurd operand count is caught before anything is allocated for it
+	huge := []byte{cellMerge, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 'a'}
+	_, err = decodeCell(huge)
+	assert.ErrorIs(t, err, common.ErrCorrupt)
+}
+
+func TestMerge_NotWithDuplicates(t *testing.T) {
+	assert.Panics(t, func() {
+		New(3, WithDuplicates(DupInsertionOrder), WithMergeOperator(AppendOperator{}))
+	})
+}
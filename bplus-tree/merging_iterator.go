@@ -0,0 +1,260 @@
+package bplustree
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+)
+
+// DuplicatePolicy decides what a MergingIterator does when several sources
+// hold the same key.
+type DuplicatePolicy int
+
+const (
+	// FirstSourceWins emits a key once, with the value from the source that
+	// was passed first to NewMergingIterator.
+	FirstSourceWins DuplicatePolicy = iota
+	// EmitAll emits the key once per source holding it, in source order when
+	// moving forward and reverse source order when moving backward. Source
+	// tells the entries apart.
+	EmitAll
+)
+
+// MergingIterator combines several iterators, for example one per shard,
+// into a single ordered view. It works with anything implementing Iterator,
+// so sources can be in-memory or disk-backed trees, or other merging
+// iterators.
+type MergingIterator struct {
+	iters   []Iterator
+	policy  DuplicatePolicy
+	h       mergeHeap
+	forward bool
+	closed  bool
+}
+
+var _ Iterator = (*MergingIterator)(nil)
+
+// NewMergingIterator returns an unpositioned iterator over the union of
+// iters. The merging iterator takes ownership of iters: they are repositioned
+// by it and closed by Close.
+func NewMergingIterator(policy DuplicatePolicy, iters ...Iterator) *MergingIterator {
+	m := &MergingIterator{
+		iters:   iters,
+		policy:  policy,
+		forward: true,
+	}
+	m.h.m = m
+	return m
+}
+
+// Source returns the index, in the order passed to NewMergingIterator, of the
+// iterator the current entry comes from, or -1 if the iterator is invalid.
+func (m *MergingIterator) Source() int {
+	if !m.Valid() {
+		return -1
+	}
+	return m.h.items[0]
+}
+
+func (m *MergingIterator) First() bool {
+	return m.position(true, func(_ int, it Iterator) bool { return it.First() })
+}
+
+func (m *MergingIterator) Last() bool {
+	return m.position(false, func(_ int, it Iterator) bool { return it.Last() })
+}
+
+func (m *MergingIterator) SeekGE(key []byte) bool {
+	return m.position(true, func(_ int, it Iterator) bool { return it.SeekGE(key) })
+}
+
+func (m *MergingIterator) SeekLT(key []byte) bool {
+	return m.position(false, func(_ int, it Iterator) bool { return it.SeekLT(key) })
+}
+
+func (m *MergingIterator) SeekLE(key []byte) bool {
+	return m.position(false, func(_ int, it Iterator) bool { return it.SeekLE(key) })
+}
+
+func (m *MergingIterator) Next() bool {
+	if !m.Valid() {
+		return false
+	}
+	if !m.forward {
+		m.switchToForward()
+		return m.Valid()
+	}
+
+	m.advance(func(it Iterator) bool { return it.Next() })
+	return m.Valid()
+}
+
+func (m *MergingIterator) Prev() bool {
+	if !m.Valid() {
+		return false
+	}
+	if m.forward {
+		m.switchToReverse()
+		return m.Valid()
+	}
+
+	m.advance(func(it Iterator) bool { return it.Prev() })
+	return m.Valid()
+}
+
+func (m *MergingIterator) Valid() bool {
+	return !m.closed && len(m.h.items) > 0
+}
+
+func (m *MergingIterator) Key() []byte {
+	if !m.Valid() {
+		return nil
+	}
+	return m.iters[m.h.items[0]].Key()
+}
+
+func (m *MergingIterator) Value() []byte {
+	if !m.Valid() {
+		return nil
+	}
+	return m.iters[m.h.items[0]].Value()
+}
+
+// Error returns the first error reported by a source.
+func (m *MergingIterator) Error() error {
+	for _, it := range m.iters {
+		if err := it.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every source and returns their errors joined together.
+func (m *MergingIterator) Close() error {
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	m.h.items = nil
+
+	var errs []error
+	for _, it := range m.iters {
+		if err := it.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// position moves every source with seek and rebuilds the heap.
+func (m *MergingIterator) position(forward bool, seek func(i int, it Iterator) bool) bool {
+	if m.closed {
+		return false
+	}
+
+	m.forward = forward
+	m.h.items = m.h.items[:0]
+	for i, it := range m.iters {
+		if seek(i, it) {
+			m.h.items = append(m.h.items, i)
+		}
+	}
+	heap.Init(&m.h)
+	return m.Valid()
+}
+
+// advance moves past the current entry. With FirstSourceWins every source
+// sitting on the current key is moved, so the key isn't emitted again.
+func (m *MergingIterator) advance(step func(it Iterator) bool) {
+	top := m.h.items[0]
+	if m.policy == EmitAll {
+		m.stepSource(top, step)
+		return
+	}
+
+	key := bytes.Clone(m.iters[top].Key())
+	for len(m.h.items) > 0 {
+		top := m.h.items[0]
+		if !bytes.Equal(m.iters[top].Key(), key) {
+			return
+		}
+		m.stepSource(top, step)
+	}
+}
+
+func (m *MergingIterator) stepSource(src int, step func(it Iterator) bool) {
+	if step(m.iters[src]) {
+		heap.Fix(&m.h, 0)
+	} else {
+		heap.Pop(&m.h)
+	}
+}
+
+// switchToForward repositions every source just after the current entry.
+// Entries that come after (key, src) going forward are the same key in later
+// sources (EmitAll only) and then bigger keys.
+func (m *MergingIterator) switchToForward() {
+	key := bytes.Clone(m.Key())
+	src := m.Source()
+
+	m.position(true, func(i int, it Iterator) bool {
+		if !it.SeekGE(key) {
+			return false
+		}
+		if bytes.Equal(it.Key(), key) && (m.policy == FirstSourceWins || i <= src) {
+			return it.Next()
+		}
+		return true
+	})
+}
+
+// switchToReverse repositions every source just before the current entry.
+func (m *MergingIterator) switchToReverse() {
+	key := bytes.Clone(m.Key())
+	src := m.Source()
+
+	m.position(false, func(i int, it Iterator) bool {
+		if m.policy == EmitAll && i < src {
+			return it.SeekLE(key)
+		}
+		return it.SeekLT(key)
+	})
+}
+
+// mergeHeap orders source indexes by their current key, ascending when moving
+// forward and descending when moving backward. Ties go to the lower source
+// index, except for EmitAll in reverse, where they go to the higher one so
+// that Prev retraces Next.
+type mergeHeap struct {
+	m     *MergingIterator
+	items []int
+}
+
+func (h *mergeHeap) Len() int { return len(h.items) }
+
+func (h *mergeHeap) Less(a, b int) bool {
+	ia, ib := h.items[a], h.items[b]
+	c := bytes.Compare(h.m.iters[ia].Key(), h.m.iters[ib].Key())
+	if c != 0 {
+		if h.m.forward {
+			return c < 0
+		}
+		return c > 0
+	}
+	if !h.m.forward && h.m.policy == EmitAll {
+		return ia > ib
+	}
+	return ia < ib
+}
+
+func (h *mergeHeap) Swap(a, b int) { h.items[a], h.items[b] = h.items[b], h.items[a] }
+
+func (h *mergeHeap) Push(x any) { h.items = append(h.items, x.(int)) }
+
+func (h *mergeHeap) Pop() any {
+	n := len(h.items)
+	x := h.items[n-1]
+	h.items = h.items[:n-1]
+	return x
+}
@@ -0,0 +1,218 @@
+package bplustree
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mergedEntry struct {
+	key int
+	src int
+	val string
+}
+
+func shardedTrees(shards ...[]int) []*BTree {
+	trees := make([]*BTree, len(shards))
+	for s, keys := range shards {
+		trees[s] = New(3)
+		for _, k := range keys {
+			trees[s].InsertInt(k, []byte(fmt.Sprintf("s%d:%d", s, k)))
+		}
+	}
+	return trees
+}
+
+func newMerging(policy DuplicatePolicy, trees []*BTree) *MergingIterator {
+	iters := make([]Iterator, len(trees))
+	for i, t := range trees {
+		iters[i] = t.NewIter(nil)
+	}
+	return NewMergingIterator(policy, iters...)
+}
+
+func mergedForward(m *MergingIterator) []mergedEntry {
+	out := make([]mergedEntry, 0)
+	for ; m.Valid(); m.Next() {
+		out = append(out, mergedEntry{convertBytetoInt(m.Key()), m.Source(), string(m.Value())})
+	}
+	return out
+}
+
+func mergedBackward(m *MergingIterator) []mergedEntry {
+	out := make([]mergedEntry, 0)
+	for ; m.Valid(); m.Prev() {
+		out = append(out, mergedEntry{convertBytetoInt(m.Key()), m.Source(), string(m.Value())})
+	}
+	return out
+}
+
+func TestMergingIterator_Disjoint(t *testing.T) {
+	trees := shardedTrees(
+		[]int{0, 3, 6, 9, 12, 15},
+		[]int{1, 4, 7, 10, 13},
+		[]int{2, 5, 8, 11, 14},
+	)
+	m := newMerging(FirstSourceWins, trees)
+
+	m.First()
+	keys := make([]int, 0)
+	for _, e := range mergedForward(m) {
+		keys = append(keys, e.key)
+		assert.Equal(t, e.key%3, e.src)
+	}
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, keys)
+
+	m.Last()
+	keys = keys[:0]
+	for _, e := range mergedBackward(m) {
+		keys = append(keys, e.key)
+	}
+	assert.Equal(t, []int{15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0}, keys)
+}
+
+func TestMergingIterator_FirstSourceWins(t *testing.T) {
+	trees := shardedTrees(
+		[]int{1, 3, 5},
+		[]int{1, 2, 3, 4},
+		[]int{3, 5, 6},
+	)
+	m := newMerging(FirstSourceWins, trees)
+
+	m.First()
+	assert.Equal(t, []mergedEntry{
+		{1, 0, "s0:1"},
+		{2, 1, "s1:2"},
+		{3, 0, "s0:3"},
+		{4, 1, "s1:4"},
+		{5, 0, "s0:5"},
+		{6, 2, "s2:6"},
+	}, mergedForward(m))
+
+	m.Last()
+	assert.Equal(t, []mergedEntry{
+		{6, 2, "s2:6"},
+		{5, 0, "s0:5"},
+		{4, 1, "s1:4"},
+		{3, 0, "s0:3"},
+		{2, 1, "s1:2"},
+		{1, 0, "s0:1"},
+	}, mergedBackward(m))
+}
+
+func TestMergingIterator_EmitAll(t *testing.T) {
+	trees := shardedTrees(
+		[]int{1, 3},
+		[]int{1, 2, 3},
+		[]int{3},
+	)
+	m := newMerging(EmitAll, trees)
+
+	forward := []mergedEntry{
+		{1, 0, "s0:1"},
+		{1, 1, "s1:1"},
+		{2, 1, "s1:2"},
+		{3, 0, "s0:3"},
+		{3, 1, "s1:3"},
+		{3, 2, "s2:3"},
+	}
+
+	m.First()
+	assert.Equal(t, forward, mergedForward(m))
+
+	m.Last()
+	backward := mergedBackward(m)
+	for i := range forward {
+		assert.Equal(t, forward[len(forward)-1-i], backward[i])
+	}
+}
+
+func TestMergingIterator_DirectionSwitch(t *testing.T) {
+	for _, policy := range []DuplicatePolicy{FirstSourceWins, EmitAll} {
+		trees := shardedTrees(
+			[]int{1, 3, 5, 7},
+			[]int{2, 3, 4, 7, 8},
+			[]int{3, 6, 7},
+		)
+		m := newMerging(policy, trees)
+
+		m.First()
+		all := mergedForward(m)
+
+		// walk forward to every position, then check Prev and Next around it
+		for pos := range all {
+			m.First()
+			for range pos {
+				m.Next()
+			}
+			assert.Equal(t, all[pos], mergedEntry{convertBytetoInt(m.Key()), m.Source(), string(m.Value())})
+
+			if pos > 0 {
+				assert.True(t, m.Prev())
+				assert.Equal(t, all[pos-1].key, convertBytetoInt(m.Key()))
+				assert.Equal(t, all[pos-1].src, m.Source())
+				assert.True(t, m.Next())
+			} else {
+				assert.False(t, m.Prev())
+				m.First()
+			}
+			assert.Equal(t, all[pos].key, convertBytetoInt(m.Key()))
+			assert.Equal(t, all[pos].src, m.Source())
+		}
+	}
+}
+
+func TestMergingIterator_Seek(t *testing.T) {
+	trees := shardedTrees(
+		[]int{0, 10, 20},
+		[]int{5, 15, 25},
+	)
+	m := newMerging(FirstSourceWins, trees)
+
+	assert.True(t, m.SeekGE(convertIntToByte(11)))
+	assert.Equal(t, 15, convertBytetoInt(m.Key()))
+	assert.Equal(t, 1, m.Source())
+
+	assert.True(t, m.SeekLE(convertIntToByte(11)))
+	assert.Equal(t, 10, convertBytetoInt(m.Key()))
+
+	assert.True(t, m.SeekLT(convertIntToByte(10)))
+	assert.Equal(t, 5, convertBytetoInt(m.Key()))
+	assert.True(t, m.Next())
+	assert.Equal(t, 10, convertBytetoInt(m.Key()))
+
+	assert.False(t, m.SeekGE(convertIntToByte(26)))
+	assert.Equal(t, -1, m.Source())
+}
+
+func TestMergingIterator_BoundedSources(t *testing.T) {
+	trees := shardedTrees(
+		[]int{0, 1, 2, 3, 4, 5},
+		[]int{0, 1, 2, 3, 4, 5},
+	)
+	opts := &IterOptions{LowerBound: convertIntToByte(2), UpperBound: convertIntToByte(4)}
+	m := NewMergingIterator(EmitAll, trees[0].NewIter(opts), trees[1].NewIter(opts))
+
+	m.First()
+	assert.Equal(t, []mergedEntry{
+		{2, 0, "s0:2"}, {2, 1, "s1:2"}, {3, 0, "s0:3"}, {3, 1, "s1:3"},
+	}, mergedForward(m))
+}
+
+func TestMergingIterator_EmptyAndClose(t *testing.T) {
+	trees := shardedTrees([]int{}, []int{1}, []int{})
+	m := newMerging(FirstSourceWins, trees)
+
+	assert.True(t, m.First())
+	assert.Equal(t, 1, convertBytetoInt(m.Key()))
+	assert.False(t, m.Next())
+
+	assert.NoError(t, m.Close())
+	assert.False(t, m.First())
+	assert.Nil(t, m.Key())
+
+	empty := NewMergingIterator(EmitAll)
+	assert.False(t, empty.First())
+	assert.False(t, empty.Last())
+}
@@ -0,0 +1,216 @@
+package bplustree
+
+import (
+	"bytes"
+
+	"storage-engine/common"
+)
+
+// DupOrder decides how a multimap tree orders the values stored under the
+// same key.
+type DupOrder int
+
+const (
+	// DupNone is a regular tree: Insert on an existing key overwrites it.
+	DupNone DupOrder = iota
+	// DupInsertionOrder keeps every inserted value, oldest first.
+	DupInsertionOrder
+	// DupValueOrder keeps values sorted by bytes.Compare. Inserting a key and
+	// value pair that is already present is a no-op.
+	DupValueOrder
+)
+
+// WithDuplicates turns the tree into a multimap that keeps every value
+// inserted for a key instead of overwriting it. Get returns the first value,
+// GetAll every value, Delete removes all of them and DeleteValue a single
+// one. Iterators step through every duplicate.
+func WithDuplicates(order DupOrder) Option {
+	return func(b *BTree) {
+		b.dups = order
+	}
+}
+
+// GetAll returns copies of every value stored for key, in the tree's
+// duplicate order. For a regular tree it returns at most one value.
+func (b *BTree) GetAll(key []byte) ([][]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrEmptyKey
+	}
+	if b.root == nil {
+		return nil, ErrNotFound
+	}
+
+	it := b.newIter(&IterOptions{LowerBound: key, UpperBound: key, UpperInclusive: true})
+	values := make([][]byte, 0)
+	for it.First(); it.Valid(); it.Next() {
+		values = append(values, bytes.Clone(it.Value()))
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+
+	if len(values) == 0 {
+		return nil, ErrNotFound
+	}
+	return values, nil
+}
+
+// DeleteValue removes a single entry matching both key and value.
+func (b *BTree) DeleteValue(key, value []byte) (err error) {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	if err := b.writable(); err != nil {
+		return err
+	}
+	defer b.recoverCorruption(&err)
+
+	if b.root == nil {
+		return ErrNotFound
+	}
+
+	leaf, idx, path, found := b.findEntry(key, value)
+	if !found {
+		return ErrNotFound
+	}
+
+	b.deleteFromLeaf(leaf, idx, path)
+	return nil
+}
+
+// insertDuplicate adds key/value to a multimap tree without touching existing
+// entries for key.
+func (b *BTree) insertDuplicate(key, value []byte) error {
+	curr := b.root
+	path := make([]*Node, 0)
+
+	for !curr.IsLeaf() {
+		path = append(path, curr)
+		if b.dups == DupInsertionOrder {
+			// land after every existing duplicate
+			curr = b.traverseRightOrLeft(curr, key)
+		} else {
+			curr = b.traverseLowerBound(curr, key)
+		}
+	}
+
+	// entryBefore reports whether the entry at idx of n sorts before the new
+	// one, i.e. whether the new one goes after it
+	entryBefore := func(n *Node, idx int) bool {
+		c := n.compareKey(idx, key)
+		if c != 0 {
+			return c < 0
+		}
+		return b.dups == DupInsertionOrder || bytes.Compare(n.value[idx], value) < 0
+	}
+
+	idx := 0
+	for {
+		for idx < len(curr.key) && entryBefore(curr, idx) {
+			idx++
+		}
+		// a run of equal keys can continue in the next leaf
+		if idx < len(curr.key) || curr.next == nil || !entryBefore(curr.next, 0) {
+			break
+		}
+		curr, path = b.nextLeaf(curr, path)
+		idx = 0
+	}
+
+	if b.dups == DupValueOrder {
+		// the entry that would follow the new one, possibly in the next leaf
+		n, i := curr, idx
+		if i == len(n.key) {
+			n, i = n.next, 0
+		}
+		if n != nil && i < len(n.key) && n.keyEqual(i, key) && bytes.Equal(n.value[i], value) {
+			return nil
+		}
+	}
+
+	b.insertKVInLeafInPlace(curr, key, value, idx)
+	if b.checkMaxKeys(len(curr.key)) {
+		_, _ = b.splitNode(curr, path)
+	}
+	return nil
+}
+
+// deleteAll removes every value stored for key in a multimap tree.
+func (b *BTree) deleteAll(key []byte) error {
+	deleted := 0
+	for {
+		leaf, idx, path, found := b.findEntry(key, nil)
+		if !found {
+			break
+		}
+		b.deleteFromLeaf(leaf, idx, path)
+		deleted++
+	}
+
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// findEntry finds the first entry for key, or the first one for key holding
+// value if value is non-nil. It returns the leaf, the index inside it and the
+// path of ancestors leading to the leaf, as needed by deleteFromLeaf.
+func (b *BTree) findEntry(key, value []byte) (*Node, int, []*Node, bool) {
+	curr := b.root
+	path := make([]*Node, 0)
+
+	for !curr.IsLeaf() {
+		path = append(path, curr)
+		curr = b.traverseLowerBound(curr, key)
+	}
+
+	idx := curr.lowerBound(key)
+	for curr != nil {
+		for ; idx < len(curr.key); idx++ {
+			if !curr.keyEqual(idx, key) {
+				return nil, 0, nil, false
+			}
+			if value == nil || bytes.Equal(curr.value[idx], value) {
+				return curr, idx, path, true
+			}
+		}
+		curr, path = b.nextLeaf(curr, path)
+		idx = 0
+	}
+	return nil, 0, nil, false
+}
+
+// traverseLowerBound picks the leftmost child that can hold key. Unlike
+// traverseRightOrLeft it goes left on a separator equal to key, since in a
+// multimap a run of equal keys can span the separator.
+func (b *BTree) traverseLowerBound(node *Node, key []byte) *Node {
+	common.Assert(len(node.children) == len(node.key)+1,
+		"internal node has %d children but %d keys (expected %d children)",
+		len(node.children), len(node.key), len(node.key)+1)
+
+	return node.children[lowerBound(node.key, key)]
+}
+
+// nextLeaf returns the leaf after leaf together with the path of ancestors
+// leading to it, or nil if leaf is the last one.
+func (b *BTree) nextLeaf(leaf *Node, path []*Node) (*Node, []*Node) {
+	child := leaf
+	for depth := len(path) - 1; depth >= 0; depth-- {
+		parent := path[depth]
+		idx := b.getChildIndexFromParentChildren(parent, child)
+		common.Assert(idx >= 0, "node not found in parent's children while moving to next leaf")
+
+		if idx+1 < len(parent.children) {
+			newPath := append(make([]*Node, 0, len(path)), path[:depth+1]...)
+			n := parent.children[idx+1]
+			for !n.IsLeaf() {
+				newPath = append(newPath, n)
+				n = n.children[0]
+			}
+			return n, newPath
+		}
+		child = parent
+	}
+	return nil, nil
+}
@@ -0,0 +1,198 @@
+package bplustree
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultimap_InsertionOrder(t *testing.T) {
+	b := New(2, WithDuplicates(DupInsertionOrder))
+
+	// enough duplicates that the run of "b" spans several leaves
+	for i := range 30 {
+		assert.NoError(t, b.Insert([]byte("b"), []byte(fmt.Sprintf("v%02d", i))))
+		assert.NoError(t, b.Insert([]byte("a"), []byte(fmt.Sprintf("a%02d", i%3))))
+		assert.NoError(t, b.Insert([]byte("c"), []byte(fmt.Sprintf("c%02d", i%3))))
+	}
+
+	values, err := b.GetAll([]byte("b"))
+	assert.NoError(t, err)
+	assert.Len(t, values, 30)
+	for i, v := range values {
+		assert.Equal(t, []byte(fmt.Sprintf("v%02d", i)), v)
+	}
+
+	v, err := b.Get([]byte("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v00"), v)
+
+	// iterators step through every duplicate
+	count := 0
+	for k := range b.All() {
+		if bytes.Equal(k, []byte("b")) {
+			count++
+		}
+	}
+	assert.Equal(t, 30, count)
+
+	ite, err := b.Seek([]byte("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v00"), ite.Value())
+	assert.True(t, ite.SeekLE([]byte("b")))
+	assert.Equal(t, []byte("v29"), ite.Value())
+}
+
+func TestMultimap_ExclusiveLowerBound(t *testing.T) {
+	b := New(2, WithDuplicates(DupInsertionOrder))
+
+	// the run of "b" spans several leaves
+	for i := range 12 {
+		assert.NoError(t, b.Insert([]byte("b"), []byte(fmt.Sprintf("v%02d", i))))
+	}
+	assert.NoError(t, b.Insert([]byte("a"), []byte("a")))
+	assert.NoError(t, b.Insert([]byte("c"), []byte("c")))
+
+	ite := b.NewIter(&IterOptions{LowerBound: []byte("b"), LowerExclusive: true})
+	assert.True(t, ite.First())
+	assert.Equal(t, []byte("c"), ite.Key())
+	assert.False(t, ite.Prev())
+
+	ite = b.NewIter(&IterOptions{LowerBound: []byte("b"), LowerExclusive: true, UpperBound: []byte("c")})
+	assert.False(t, ite.First())
+}
+
+func TestMultimap_ValueOrder(t *testing.T) {
+	b := New(2, WithDuplicates(DupValueOrder))
+
+	perm := rand.New(rand.NewSource(1)).Perm(40)
+	for _, i := range perm {
+		assert.NoError(t, b.Insert([]byte("term"), []byte(fmt.Sprintf("doc%02d", i))))
+	}
+	// identical pairs are stored once
+	assert.NoError(t, b.Insert([]byte("term"), []byte("doc05")))
+
+	values, err := b.GetAll([]byte("term"))
+	assert.NoError(t, err)
+	assert.Len(t, values, 40)
+	for i, v := range values {
+		assert.Equal(t, []byte(fmt.Sprintf("doc%02d", i)), v)
+	}
+}
+
+func TestMultimap_DeleteValue(t *testing.T) {
+	b := New(2, WithDuplicates(DupInsertionOrder))
+	for i := range 20 {
+		b.Insert([]byte("k"), []byte(fmt.Sprintf("v%02d", i)))
+	}
+
+	assert.NoError(t, b.DeleteValue([]byte("k"), []byte("v07")))
+	assert.Error(t, b.DeleteValue([]byte("k"), []byte("v07")))
+	assert.Error(t, b.DeleteValue([]byte("missing"), []byte("v01")))
+
+	values, err := b.GetAll([]byte("k"))
+	assert.NoError(t, err)
+	assert.Len(t, values, 19)
+	assert.NotContains(t, values, []byte("v07"))
+
+	// Delete removes every value
+	assert.NoError(t, b.Delete([]byte("k")))
+	_, err = b.GetAll([]byte("k"))
+	assert.Error(t, err)
+	assert.Error(t, b.Delete([]byte("k")))
+}
+
+func TestGetAll_UniqueTree(t *testing.T) {
+	b := New(3)
+	b.Insert([]byte("k"), []byte("v1"))
+	b.Insert([]byte("k"), []byte("v2"))
+
+	values, err := b.GetAll([]byte("k"))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("v2")}, values)
+
+	assert.Error(t, b.DeleteValue([]byte("k"), []byte("v1")))
+	assert.NoError(t, b.DeleteValue([]byte("k"), []byte("v2")))
+	_, err = b.Get([]byte("k"))
+	assert.Error(t, err)
+}
+
+// TestMultimap_Randomized mixes inserts, single value deletes and full key
+// deletes over a small key space so runs of duplicates keep crossing leaf
+// boundaries, then compares against a reference.
+func TestMultimap_Randomized(t *testing.T) {
+	for _, dups := range []DupOrder{DupInsertionOrder, DupValueOrder} {
+		t.Run(fmt.Sprintf("dups=%d", dups), func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(7))
+			b := New(2, WithDuplicates(dups))
+			ref := make(map[string][]string)
+
+			for range 3000 {
+				k := fmt.Sprintf("k%d", rnd.Intn(8))
+				switch op := rnd.Intn(10); {
+				case op < 6:
+					v := fmt.Sprintf("v%03d", rnd.Intn(200))
+					assert.NoError(t, b.Insert([]byte(k), []byte(v)))
+					if dups == DupValueOrder {
+						if !slices.Contains(ref[k], v) {
+							ref[k] = append(ref[k], v)
+							slices.Sort(ref[k])
+						}
+					} else {
+						ref[k] = append(ref[k], v)
+					}
+				case op < 9:
+					if len(ref[k]) == 0 {
+						assert.Error(t, b.DeleteValue([]byte(k), []byte("v000")))
+						continue
+					}
+					v := ref[k][rnd.Intn(len(ref[k]))]
+					assert.NoError(t, b.DeleteValue([]byte(k), []byte(v)))
+					i := slices.Index(ref[k], v)
+					ref[k] = slices.Delete(ref[k], i, i+1)
+				default:
+					err := b.Delete([]byte(k))
+					if len(ref[k]) == 0 {
+						assert.Error(t, err)
+					} else {
+						assert.NoError(t, err)
+					}
+					delete(ref, k)
+				}
+			}
+
+			for i := range 8 {
+				k := fmt.Sprintf("k%d", i)
+				values, err := b.GetAll([]byte(k))
+				if len(ref[k]) == 0 {
+					assert.Error(t, err, "key %s", k)
+					continue
+				}
+				assert.NoError(t, err)
+				got := make([]string, len(values))
+				for j, v := range values {
+					got[j] = string(v)
+				}
+				assert.Equal(t, ref[k], got, "key %s", k)
+			}
+
+			// forward iteration sees every entry in key order
+			total := 0
+			for _, vs := range ref {
+				total += len(vs)
+			}
+			var prev []byte
+			n := 0
+			for k := range b.All() {
+				assert.True(t, bytes.Compare(prev, k) <= 0)
+				prev = k
+				n++
+			}
+			assert.Equal(t, total, n)
+		})
+	}
+}
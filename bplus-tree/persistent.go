@@ -0,0 +1,192 @@
+package bplustree
+
+import (
+	"errors"
+	"slices"
+	"sync/atomic"
+
+	"storage-engine/common"
+)
+
+// Persistent trees. A tree created with WithStructuralSharing is a version
+// that never changes: InsertVersion and DeleteVersion return a new version
+// that shares every node off the path to the changed leaf with the old one,
+// so keeping old versions around as snapshots costs nothing but the nodes
+// that were copied.
+//
+// A transient, see Transient, is a writable copy of a version for bulk
+// changes. It copies a shared node the first time it changes it and changes
+// the copy in place from then on, instead of copying the path again on every
+// write. Persistent turns it back into a version.
+//
+// Leaves of a persistent tree aren't linked: one copied leaf would drag the
+// whole chain along. Iterators find the leaf next to theirs by descending from
+// the root instead, and multimaps, merge operators, TTLs and indexes, which
+// walk the chain, aren't supported.
+
+// ErrImmutable is returned by writes to a version of a persistent tree.
+var ErrImmutable = errors.New("tree version is immutable, use a transient to change it")
+
+// ErrNotPersistent is returned by the version and transient methods of a tree
+// created without WithStructuralSharing.
+var ErrNotPersistent = errors.New("tree is not persistent, create it WithStructuralSharing")
+
+// edits hands out the tokens that tell the nodes of a transient apart. 0 is
+// the token of trees that don't share nodes.
+var edits atomic.Uint64
+
+// WithStructuralSharing makes the tree persistent: an empty version to build
+// others from with InsertVersion, DeleteVersion or a transient.
+func WithStructuralSharing() Option {
+	return func(b *BTree) {
+		b.shared = true
+	}
+}
+
+// InsertVersion returns a version of the tree with key set to value. b is
+// left as it was.
+func (b *BTree) InsertVersion(key, value []byte) (*BTree, error) {
+	t, err := b.Transient()
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Insert(key, value); err != nil {
+		return nil, err
+	}
+	return t.Persistent()
+}
+
+// DeleteVersion returns a version of the tree without key. b is left as it
+// was.
+func (b *BTree) DeleteVersion(key []byte) (*BTree, error) {
+	t, err := b.Transient()
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Delete(key); err != nil {
+		return nil, err
+	}
+	return t.Persistent()
+}
+
+// Transient returns a writable tree starting out with the contents of b,
+// which it shares nodes with until it changes them. Insert, Delete and Update
+// work on it as on any tree. b, whether a version or a transient, is left as
+// it was.
+func (b *BTree) Transient() (*BTree, error) {
+	// b may be a transient itself, it must not change the nodes it now shares
+	t, err := b.Persistent()
+	if err != nil {
+		return nil, err
+	}
+	t.edit = edits.Add(1)
+	return t, nil
+}
+
+// Persistent returns the current contents of the transient b as a version.
+// b stays usable, further writes to it copy the nodes they change again.
+func (b *BTree) Persistent() (*BTree, error) {
+	if !b.shared {
+		return nil, ErrNotPersistent
+	}
+	v := *b
+	v.edit = 0
+	if b.edit != 0 {
+		b.edit = edits.Add(1)
+	}
+	return &v, nil
+}
+
+// own returns n if b may change it in place, or a copy that b owns otherwise.
+// Keys, values and the leaf prefix are shared with n: leaves never change their
+// bytes in place, and the copy starts a slab of its own for new ones.
+func (b *BTree) own(n *Node) *Node {
+	if !b.shared || n.edit == b.edit {
+		return n
+	}
+	return &Node{
+		key:      slices.Clone(n.key),
+		value:    slices.Clone(n.value),
+		children: slices.Clone(n.children),
+		prefix:   n.prefix,
+		edit:     b.edit,
+	}
+}
+
+// ownChild is own for child of parent, which b must already own. The copy
+// replaces child in parent.
+func (b *BTree) ownChild(parent, child *Node) *Node {
+	owned := b.own(child)
+	if owned != child {
+		idx := b.getChildIndexFromParentChildren(parent, child)
+		common.Assert(idx >= 0, "node not found in parent's children while copying it")
+		parent.children[idx] = owned
+	}
+	return owned
+}
+
+// pathToLeaf returns the leaf key belongs in and its ancestors, root first.
+// Nodes on the path are owned by b, so a write can change all of them in
+// place.
+func (b *BTree) pathToLeaf(key []byte) (*Node, []*Node) {
+	b.root = b.own(b.root)
+	curr := b.root
+	path := make([]*Node, 0)
+
+	for curr != nil && !curr.IsLeaf() {
+		path = append(path, curr)
+		curr = b.ownChild(curr, b.traverseRightOrLeft(curr, key))
+	}
+	return curr, path
+}
+
+// leafAfter returns the leaf following n in key order, or nil if n is the
+// last one.
+func (b *BTree) leafAfter(n *Node) *Node {
+	if !b.shared {
+		return n.next
+	}
+	if len(n.key) == 0 {
+		// only an empty root leaf has no keys
+		return nil
+	}
+
+	key := n.leafKey(len(n.key) - 1)
+	var next *Node
+	for c := b.root; !c.IsLeaf(); {
+		i := upperBound(c.key, key)
+		if i+1 < len(c.children) {
+			next = c.children[i+1]
+		}
+		c = c.children[i]
+	}
+	for next != nil && !next.IsLeaf() {
+		next = next.children[0]
+	}
+	return next
+}
+
+// leafBefore returns the leaf preceding n in key order, or nil if n is the
+// first one.
+func (b *BTree) leafBefore(n *Node) *Node {
+	if !b.shared {
+		return n.prev
+	}
+	if len(n.key) == 0 {
+		return nil
+	}
+
+	key := n.leafKey(0)
+	var prev *Node
+	for c := b.root; !c.IsLeaf(); {
+		i := upperBound(c.key, key)
+		if i > 0 {
+			prev = c.children[i-1]
+		}
+		c = c.children[i]
+	}
+	for prev != nil && !prev.IsLeaf() {
+		prev = prev.children[len(prev.children)-1]
+	}
+	return prev
+}
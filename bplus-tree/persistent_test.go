@@ -0,0 +1,171 @@
+package bplustree
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// contents returns the entries of b in iteration order.
+func contents(b *BTree) map[string]string {
+	got := make(map[string]string)
+	it := b.NewIter(nil)
+	defer it.Close()
+	for ok := it.First(); ok; ok = it.Next() {
+		got[string(it.Key())] = string(it.Value())
+	}
+	return got
+}
+
+func TestPersistent_Versions(t *testing.T) {
+	v0 := New(2, WithStructuralSharing())
+	versions := []*BTree{v0}
+	want := []map[string]string{{}}
+
+	r := rand.New(rand.NewSource(3))
+	curr := map[string]string{}
+	for i := range 400 {
+		v := versions[len(versions)-1]
+		k := fmt.Sprintf("k%03d", r.Intn(150))
+		var err error
+		if _, ok := curr[k]; ok && r.Intn(3) == 0 {
+			v, err = v.DeleteVersion([]byte(k))
+			delete(curr, k)
+		} else {
+			v, err = v.InsertVersion([]byte(k), []byte(fmt.Sprint(i)))
+			curr[k] = fmt.Sprint(i)
+		}
+		assert.NoError(t, err)
+
+		snap := make(map[string]string, len(curr))
+		for k, v := range curr {
+			snap[k] = v
+		}
+		versions = append(versions, v)
+		want = append(want, snap)
+	}
+
+	// every old version still holds what it did when it was made
+	for i, v := range versions {
+		assert.Equal(t, want[i], contents(v), "version %d", i)
+		assert.True(t, v.Verify().OK(), "version %d: %s", i, v.Verify())
+	}
+}
+
+func TestPersistent_SharesNodes(t *testing.T) {
+	v1, _ := New(2, WithStructuralSharing()).Transient()
+	for i := range 200 {
+		assert.NoError(t, v1.InsertInt(i, []byte("v")))
+	}
+	v1, _ = v1.Persistent()
+
+	v2, err := v1.InsertVersion(convertIntToByte(50), []byte("w"))
+	assert.NoError(t, err)
+
+	// only the path to the changed leaf is new
+	old := make(map[*Node]bool)
+	var walk func(n *Node, fn func(*Node))
+	walk = func(n *Node, fn func(*Node)) {
+		fn(n)
+		for _, c := range n.children {
+			walk(c, fn)
+		}
+	}
+	walk(v1.root, func(n *Node) { old[n] = true })
+	copied := 0
+	walk(v2.root, func(n *Node) {
+		if !old[n] {
+			copied++
+		}
+	})
+	assert.Equal(t, v2.Verify().Height, copied)
+
+	v, _ := v1.GetInt(50)
+	assert.Equal(t, []byte("v"), v)
+	v, _ = v2.GetInt(50)
+	assert.Equal(t, []byte("w"), v)
+}
+
+func TestPersistent_Immutable(t *testing.T) {
+	v, err := New(2, WithStructuralSharing()).InsertVersion([]byte("a"), []byte("1"))
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, v.Insert([]byte("b"), []byte("2")), ErrImmutable)
+	assert.ErrorIs(t, v.Delete([]byte("a")), ErrImmutable)
+	assert.ErrorIs(t, v.Update([]byte("a"), func([]byte, bool) ([]byte, bool) { return nil, true }), ErrImmutable)
+	assert.Error(t, v.RegisterIndex("x", nil))
+
+	_, err = v.DeleteVersion([]byte("nope"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, map[string]string{"a": "1"}, contents(v))
+}
+
+func TestPersistent_NotPersistent(t *testing.T) {
+	b := New(2)
+	assert.NoError(t, b.Insert([]byte("a"), []byte("1")))
+
+	_, err := b.Transient()
+	assert.ErrorIs(t, err, ErrNotPersistent)
+	_, err = b.Persistent()
+	assert.ErrorIs(t, err, ErrNotPersistent)
+	_, err = b.InsertVersion([]byte("b"), []byte("2"))
+	assert.ErrorIs(t, err, ErrNotPersistent)
+	_, err = b.DeleteVersion([]byte("a"))
+	assert.ErrorIs(t, err, ErrNotPersistent)
+	assert.Equal(t, map[string]string{"a": "1"}, contents(b))
+}
+
+func TestPersistent_Transient(t *testing.T) {
+	base, _ := New(3, WithStructuralSharing()).Transient()
+	for i := range 300 {
+		assert.NoError(t, base.InsertInt(i, []byte("base")))
+	}
+	v1, _ := base.Persistent()
+
+	// writes to the transient after Persistent don't leak into the version,
+	// and neither do writes to a transient made from a transient
+	for i := range 300 {
+		if i%2 == 0 {
+			assert.NoError(t, base.DeleteInt(i))
+		}
+	}
+	child, _ := base.Transient()
+	for i := range 300 {
+		assert.NoError(t, base.InsertInt(i, []byte("later")))
+	}
+	assert.NoError(t, child.InsertInt(1000, []byte("child")))
+
+	assert.Len(t, contents(v1), 300)
+	for _, v := range contents(v1) {
+		assert.Equal(t, "base", v)
+	}
+	assert.Len(t, contents(child), 151)
+	assert.Len(t, contents(base), 300)
+	for _, tree := range []*BTree{v1, child, base} {
+		assert.True(t, tree.Verify().OK(), tree.Verify().String())
+	}
+}
+
+func TestPersistent_Iterator(t *testing.T) {
+	tr, _ := New(2, WithStructuralSharing()).Transient()
+	for i := range 100 {
+		assert.NoError(t, tr.InsertInt(i*2, nil))
+	}
+	v, _ := tr.Persistent()
+
+	it := v.NewIter(nil)
+	defer it.Close()
+	n := 0
+	for ok := it.Last(); ok; ok = it.Prev() {
+		assert.Equal(t, (99-n)*2, convertBytetoInt(it.Key()))
+		n++
+	}
+	assert.Equal(t, 100, n)
+
+	assert.True(t, it.SeekLT(convertIntToByte(51)))
+	assert.Equal(t, 50, convertBytetoInt(it.Key()))
+	assert.True(t, it.Next())
+	assert.Equal(t, 52, convertBytetoInt(it.Key()))
+}
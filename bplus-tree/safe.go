@@ -0,0 +1,119 @@
+package bplustree
+
+import (
+	"runtime"
+
+	"storage-engine/common"
+)
+
+// WithCorruptionErrors makes the tree report broken structural invariants,
+// which otherwise panic, as a *CorruptionError returned by the method that
+// ran into them. A tree that hit one may be half way through a change, so it
+// is poisoned: reads carry on, but every write returns the first corruption
+// error from then on. Iterators that run into one become invalid and report
+// it from Error. The error's Reason names the broken invariant; PageID is 0
+// for nodes that don't come from a page. Panics in caller code the tree runs,
+// update functions, index functions and merge operators, are never taken for
+// corruption: they carry on as they are.
+func WithCorruptionErrors() Option {
+	return func(b *BTree) {
+		b.corruptionErrors = true
+	}
+}
+
+// Poisoned returns the corruption error that made the tree read-only, or nil.
+func (b *BTree) Poisoned() error {
+	if b.poisoned == nil {
+		return nil
+	}
+	return b.poisoned
+}
+
+// writable returns the error writes fail with once the tree is poisoned, or
+// if it is a version of a persistent tree.
+func (b *BTree) writable() error {
+	if b.shared && b.edit == 0 {
+		return ErrImmutable
+	}
+	return b.Poisoned()
+}
+
+// recoverCorruption is deferred by the methods that walk or change the
+// tree. With WithCorruptionErrors it turns a failed assertion or a runtime
+// error into a *CorruptionError stored in err, if non-nil, and poisons the
+// tree. Any other panic, or any panic without WithCorruptionErrors, carries
+// on.
+func (b *BTree) recoverCorruption(err *error) {
+	if !b.corruptionErrors {
+		return
+	}
+	if r := recover(); r != nil {
+		cerr := b.corruption(r)
+		if err != nil {
+			*err = cerr
+		}
+	}
+}
+
+// recoverCorruption is BTree.recoverCorruption for iterator methods: the
+// error is kept in i.err and the iterator becomes invalid.
+func (i *iterator) recoverCorruption() {
+	if !i.tree.corruptionErrors {
+		return
+	}
+	if r := recover(); r != nil {
+		i.err = i.tree.corruption(r)
+		i.node = nil
+	}
+}
+
+// callerPanic carries a panic raised by caller code run by the tree, such as
+// an UpdateFunc, past recoverCorruption: a bug in the caller isn't corruption.
+type callerPanic struct {
+	value any
+}
+
+// callback runs fn, which calls into caller code. With WithCorruptionErrors a
+// panic in it is wrapped in a callerPanic, which corruption raises again as
+// it was. Every method that runs callbacks defers recoverCorruption, so the
+// wrapper never reaches the caller.
+func (b *BTree) callback(fn func()) {
+	if !b.corruptionErrors {
+		fn()
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			panic(callerPanic{r})
+		}
+	}()
+	fn()
+}
+
+// corruption converts the recovered panic value r into a *CorruptionError
+// and poisons the tree with it. Besides failed assertions, runtime errors
+// such as a nil dereference or an index out of range count: a broken node
+// trips those before any assertion gets to look at it. Failed type
+// assertions, panics in callbacks and every other panic are bugs, not
+// corruption, and are passed on.
+func (b *BTree) corruption(r any) *CorruptionError {
+	var reason string
+	switch e := r.(type) {
+	case callerPanic:
+		panic(e.value)
+	case *common.AssertionError:
+		reason = e.Msg
+	case *runtime.TypeAssertionError:
+		panic(r)
+	case runtime.Error:
+		reason = e.Error()
+	default:
+		panic(r)
+	}
+
+	cerr := &CorruptionError{Reason: reason}
+	if b.poisoned == nil {
+		b.poisoned = cerr
+	}
+	return cerr
+}
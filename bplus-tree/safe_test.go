@@ -0,0 +1,156 @@
+package bplustree
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// breakTree drops the last child of the root, so the root has one key too
+// many for its children.
+func breakTree(b *BTree) {
+	b.root.children = b.root.children[:len(b.root.children)-1]
+}
+
+func TestCorruptionErrors(t *testing.T) {
+	b := New(2, WithCorruptionErrors())
+	for i := range 50 {
+		assert.NoError(t, b.InsertInt(i, []byte("v")))
+	}
+	assert.NoError(t, b.Poisoned())
+
+	breakTree(b)
+
+	_, err := b.GetInt(10)
+	assert.ErrorIs(t, err, ErrCorrupt)
+
+	var ce *CorruptionError
+	assert.True(t, errors.As(err, &ce))
+	assert.Contains(t, ce.Reason, "children")
+
+	// the tree is read-only from now on
+	assert.ErrorIs(t, b.Poisoned(), ErrCorrupt)
+	assert.Equal(t, b.Poisoned(), b.InsertInt(100, []byte("v")))
+	assert.Equal(t, b.Poisoned(), b.DeleteInt(1))
+	_, _, err = b.Swap(convertIntToByte(1), []byte("v"))
+	assert.Equal(t, b.Poisoned(), err)
+}
+
+func TestCorruptionErrors_Iterator(t *testing.T) {
+	b := New(2, WithCorruptionErrors())
+	for i := range 50 {
+		b.InsertInt(i, []byte("v"))
+	}
+	breakTree(b)
+
+	it := b.NewIter(nil)
+	assert.False(t, it.SeekGE(convertIntToByte(10)))
+	assert.False(t, it.Valid())
+	assert.ErrorIs(t, it.Error(), ErrCorrupt)
+
+	_, err := b.GetAll(convertIntToByte(10))
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
+
+func TestCorruptionErrors_Disabled(t *testing.T) {
+	b := New(2)
+	for i := range 50 {
+		b.InsertInt(i, []byte("v"))
+	}
+	breakTree(b)
+
+	assert.Panics(t, func() { _, _ = b.GetInt(10) })
+	assert.NoError(t, b.Poisoned())
+}
+
+func TestCorruptionErrors_OtherPanics(t *testing.T) {
+	b := New(2, WithCorruptionErrors())
+	b.Insert([]byte("k"), []byte("v"))
+
+	// panics that aren't failed assertions are not swallowed
+	assert.PanicsWithValue(t, "boom", func() {
+		b.Update([]byte("k"), func(old []byte, exists bool) ([]byte, bool) {
+			panic("boom")
+		})
+	})
+	assert.NoError(t, b.Poisoned())
+}
+
+func TestCorruptionErrors_IndexUntouched(t *testing.T) {
+	b := New(2, WithCorruptionErrors())
+	assert.NoError(t, b.RegisterIndex("city", byCity))
+	for _, k := range []string{"b", "c", "d", "e"} {
+		assert.NoError(t, b.Insert([]byte(k), []byte("name|paris")))
+	}
+
+	// put the full root leaf out of order, so splitting it fails an
+	// assertion once "a" went in
+	b.root.key[1] = []byte("a0")
+	err := b.Insert([]byte("a"), []byte("name|rome"))
+	assert.ErrorIs(t, err, ErrCorrupt)
+
+	// "a" made it into the leaf, but the index never heard of it
+	_, err = b.LookupByIndex("city", []byte("rome"))
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestCorruptionErrors_RuntimeError(t *testing.T) {
+	v := New(2, WithStructuralSharing(), WithCorruptionErrors())
+	for i := range 20 {
+		v, _ = v.InsertVersion(convertIntToByte(i), []byte("v"))
+	}
+
+	it := v.NewIter(nil)
+	assert.True(t, it.First())
+	// leaving the first leaf descends from the root and runs into the hole
+	v.root.children[0] = nil
+	for it.Next() {
+	}
+	assert.False(t, it.Valid())
+	assert.ErrorIs(t, it.Error(), ErrCorrupt)
+	assert.Contains(t, it.Error().Error(), "nil pointer")
+	assert.ErrorIs(t, v.Poisoned(), ErrCorrupt)
+}
+
+func TestCorruptionErrors_CallerBugs(t *testing.T) {
+	b := New(2, WithCorruptionErrors())
+	assert.NoError(t, b.RegisterIndex("bug", func(key, value []byte) [][]byte {
+		if string(value) == "bug" {
+			var m map[string]int
+			m["x"] = 1
+		}
+		return nil
+	}))
+	assert.NoError(t, b.Insert([]byte("k"), []byte("v")))
+
+	// runtime errors in callbacks panic as they are and don't poison the tree
+	assert.Panics(t, func() {
+		_ = b.Update([]byte("k"), func(old []byte, exists bool) ([]byte, bool) {
+			var m map[string]int
+			m["x"] = 1
+			return nil, false
+		})
+	})
+	assert.Panics(t, func() { _ = b.Insert([]byte("k"), []byte("bug")) })
+	assert.NoError(t, b.Poisoned())
+	assert.NoError(t, b.Insert([]byte("k2"), []byte("v")))
+
+	m := New(2, WithCorruptionErrors(), WithMergeOperator(buggyOperator{}))
+	assert.NoError(t, m.Merge([]byte("m"), []byte("x")))
+	assert.Panics(t, func() { _, _ = m.Get([]byte("m")) })
+	it := m.NewIter(nil)
+	assert.True(t, it.First())
+	assert.Panics(t, func() { _ = it.Value() })
+	assert.Panics(t, func() { _ = m.FoldMerges() })
+	assert.NoError(t, m.Poisoned())
+}
+
+// buggyOperator is a merge operator that indexes out of range.
+type buggyOperator struct{}
+
+func (buggyOperator) Name() string { return "buggy" }
+
+func (buggyOperator) Merge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	return operands[len(operands)], nil
+}
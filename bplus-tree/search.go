@@ -0,0 +1,37 @@
+package bplustree
+
+import "bytes"
+
+// Searches within a node. Binary search is on par with a linear scan for the
+// handful of keys in an order 3 node and far ahead from there on, so there is
+// no linear cutoff for small nodes, see BenchmarkNodeSearch.
+
+// lowerBound returns the index of the first key in keys that is >= key, or
+// len(keys) if there is none. keys must be sorted.
+func lowerBound(keys [][]byte, key []byte) int {
+	lo, hi := 0, len(keys)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if bytes.Compare(keys[mid], key) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// upperBound returns the index of the first key in keys that is > key, or
+// len(keys) if there is none. keys must be sorted.
+func upperBound(keys [][]byte, key []byte) int {
+	lo, hi := 0, len(keys)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if bytes.Compare(keys[mid], key) <= 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
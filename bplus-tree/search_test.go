@@ -0,0 +1,125 @@
+package bplustree
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var benchOrders = []int{3, 8, 32, 128, 512}
+
+// linearLowerBound is the scan lowerBound replaced, kept as a reference.
+func linearLowerBound(keys [][]byte, key []byte) int {
+	for i, k := range keys {
+		if bytes.Compare(k, key) >= 0 {
+			return i
+		}
+	}
+	return len(keys)
+}
+
+func benchKeys(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("tenant-0042/objects/%08d", i))
+	}
+	rand.New(rand.NewSource(1)).Shuffle(n, func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+	return keys
+}
+
+func TestSearchBounds(t *testing.T) {
+	for n := range 40 {
+		keys := make([][]byte, 0, n)
+		for i := range n {
+			// every key twice, to cover runs of duplicates
+			keys = append(keys, []byte{byte(i / 2 * 2)})
+		}
+		for probe := range 45 {
+			key := []byte{byte(probe)}
+			assert.Equal(t, linearLowerBound(keys, key), lowerBound(keys, key))
+
+			want := len(keys)
+			for i, k := range keys {
+				if bytes.Compare(k, key) > 0 {
+					want = i
+					break
+				}
+			}
+			assert.Equal(t, want, upperBound(keys, key))
+		}
+	}
+}
+
+func BenchmarkNodeSearch(b *testing.B) {
+	for _, order := range benchOrders {
+		keys := benchKeys(2 * order)
+		sorted := make([][]byte, len(keys))
+		copy(sorted, keys)
+		slices.SortFunc(sorted, bytes.Compare)
+
+		b.Run(fmt.Sprintf("order=%d/linear", order), func(b *testing.B) {
+			for i := 0; b.Loop(); i++ {
+				linearLowerBound(sorted, keys[i%len(keys)])
+			}
+		})
+		b.Run(fmt.Sprintf("order=%d/binary", order), func(b *testing.B) {
+			for i := 0; b.Loop(); i++ {
+				lowerBound(sorted, keys[i%len(keys)])
+			}
+		})
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	keys := benchKeys(100_000)
+	for _, order := range benchOrders {
+		tree := New(order)
+		for _, k := range keys {
+			tree.Insert(k, k)
+		}
+
+		b.Run(fmt.Sprintf("order=%d", order), func(b *testing.B) {
+			for i := 0; b.Loop(); i++ {
+				if _, err := tree.Get(keys[i%len(keys)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkInsert(b *testing.B) {
+	keys := benchKeys(100_000)
+	for _, order := range benchOrders {
+		b.Run(fmt.Sprintf("order=%d", order), func(b *testing.B) {
+			tree := New(order)
+			for i := 0; b.Loop(); i++ {
+				k := keys[i%len(keys)]
+				tree.Insert(k, k)
+			}
+		})
+	}
+}
+
+func BenchmarkSeek(b *testing.B) {
+	keys := benchKeys(100_000)
+	for _, order := range benchOrders {
+		tree := New(order)
+		for _, k := range keys {
+			tree.Insert(k, k)
+		}
+		it := tree.NewIter(nil)
+
+		b.Run(fmt.Sprintf("order=%d", order), func(b *testing.B) {
+			for i := 0; b.Loop(); i++ {
+				if !it.SeekGE(keys[i%len(keys)]) {
+					b.Fatal("seek failed")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package bplustree
+
+import (
+	"iter"
+
+	"storage-engine/keyenc"
+)
+
+// Range-over-func helpers, for use as `for k, v := range tree.All()`.
+// The tree must not be modified while a loop over one of these is running.
+// Like Iterator.Key and Iterator.Value, the yielded slices are views into the
+// tree that are only valid until the next change to it.
+//
+// A sequence stops at the first error, such as a merge operator failing or
+// corruption, without yielding the entry it failed on. All, Backward, Range
+// and Prefix have nowhere to report it; loop over Pairs or PairsBackward of
+// an iterator instead and check its Error afterwards when that matters.
+
+// All yields every key/value pair in ascending key order.
+func (b *BTree) All() iter.Seq2[[]byte, []byte] {
+	return b.Range(nil, nil)
+}
+
+// Backward yields every key/value pair in descending key order.
+func (b *BTree) Backward() iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		PairsBackward(b.NewIter(nil))(yield)
+	}
+}
+
+// Range yields the pairs with start <= key < end in ascending order. A nil
+// start or end leaves that side unbounded.
+func (b *BTree) Range(start, end []byte) iter.Seq2[[]byte, []byte] {
+	return b.seq(&IterOptions{LowerBound: start, UpperBound: end})
+}
+
+// Prefix yields the pairs whose key starts with p in ascending order.
+func (b *BTree) Prefix(p []byte) iter.Seq2[[]byte, []byte] {
+	return b.seq(&IterOptions{LowerBound: p, UpperBound: keyenc.PrefixSuccessor(p)})
+}
+
+func (b *BTree) seq(opts *IterOptions) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		Pairs(b.NewIter(opts))(yield)
+	}
+}
+
+// Pairs yields the pairs of it from its first one on, in ascending order. It
+// stops at the first error, which it.Error reports once the loop is done:
+//
+//	it := tree.NewIter(nil)
+//	for k, v := range bplustree.Pairs(it) {
+//		...
+//	}
+//	if err := it.Error(); err != nil {
+//		...
+//	}
+func Pairs(it Iterator) iter.Seq2[[]byte, []byte] {
+	return pairs(it, it.First, it.Next)
+}
+
+// PairsBackward is Pairs in descending order, from the last pair of it on.
+func PairsBackward(it Iterator) iter.Seq2[[]byte, []byte] {
+	return pairs(it, it.Last, it.Prev)
+}
+
+func pairs(it Iterator, start, step func() bool) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		for ok := start(); ok; ok = step() {
+			k, v := it.Key(), it.Value()
+			if it.Error() != nil || !yield(k, v) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package bplustree
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAll(t *testing.T) {
+	b := New(3)
+	for i := range 50 {
+		b.InsertInt(i, []byte(fmt.Sprintf("Value for %d", i)))
+	}
+
+	n := 0
+	for k, v := range b.All() {
+		assert.Equal(t, n, convertBytetoInt(k))
+		assert.Equal(t, []byte(fmt.Sprintf("Value for %d", n)), v)
+		n++
+	}
+	assert.Equal(t, 50, n)
+}
+
+func TestBackward(t *testing.T) {
+	b := New(3)
+	for i := range 50 {
+		b.InsertInt(i, []byte(fmt.Sprintf("Value for %d", i)))
+	}
+
+	n := 49
+	for k := range b.Backward() {
+		assert.Equal(t, n, convertBytetoInt(k))
+		n--
+	}
+	assert.Equal(t, -1, n)
+}
+
+func TestRangeSeq(t *testing.T) {
+	b := New(3)
+	for i := range 50 {
+		b.InsertInt(i, []byte(fmt.Sprintf("Value for %d", i)))
+	}
+
+	keys := make([]int, 0)
+	for k := range b.Range(convertIntToByte(10), convertIntToByte(15)) {
+		keys = append(keys, convertBytetoInt(k))
+	}
+	assert.Equal(t, []int{10, 11, 12, 13, 14}, keys)
+
+	keys = keys[:0]
+	for k := range b.Range(nil, convertIntToByte(3)) {
+		keys = append(keys, convertBytetoInt(k))
+	}
+	assert.Equal(t, []int{0, 1, 2}, keys)
+
+	keys = keys[:0]
+	for k := range b.Range(convertIntToByte(47), nil) {
+		keys = append(keys, convertBytetoInt(k))
+	}
+	assert.Equal(t, []int{47, 48, 49}, keys)
+}
+
+func TestPrefixSeq(t *testing.T) {
+	b := New(3)
+	for _, k := range []string{"user/1", "user/2", "users", "order/1", "user/3"} {
+		b.Insert([]byte(k), []byte(k))
+	}
+
+	keys := make([]string, 0)
+	for k, v := range b.Prefix([]byte("user/")) {
+		assert.Equal(t, k, v)
+		keys = append(keys, string(k))
+	}
+	assert.Equal(t, []string{"user/1", "user/2", "user/3"}, keys)
+}
+
+func TestSeq_EarlyBreak(t *testing.T) {
+	b := New(3)
+	for i := range 50 {
+		b.InsertInt(i, []byte(fmt.Sprintf("Value for %d", i)))
+	}
+
+	keys := make([]int, 0)
+	for k := range b.All() {
+		if len(keys) == 3 {
+			break
+		}
+		keys = append(keys, convertBytetoInt(k))
+	}
+	assert.Equal(t, []int{0, 1, 2}, keys)
+
+	keys = keys[:0]
+	for k := range b.Backward() {
+		keys = append(keys, convertBytetoInt(k))
+		if len(keys) == 2 {
+			break
+		}
+	}
+	assert.Equal(t, []int{49, 48}, keys)
+}
+
+func TestSeq_EmptyTree(t *testing.T) {
+	b := New(3)
+
+	for range b.All() {
+		t.Fatal("empty tree should yield nothing")
+	}
+	for range b.Backward() {
+		t.Fatal("empty tree should yield nothing")
+	}
+	for range b.Prefix([]byte("a")) {
+		t.Fatal("empty tree should yield nothing")
+	}
+}
+
+func TestSeq_StopsAtError(t *testing.T) {
+	b := New(3, WithMergeOperator(Int64AddOperator{}))
+	for _, k := range []string{"a", "b", "d"} {
+		assert.NoError(t, b.Merge([]byte(k), Int64Value(1)))
+	}
+	assert.NoError(t, b.Merge([]byte("c"), []byte("not an int")))
+
+	keys := make([]string, 0)
+	for k := range b.All() {
+		keys = append(keys, string(k))
+	}
+	assert.Equal(t, []string{"a", "b"}, keys)
+
+	it := b.NewIter(nil)
+	keys = keys[:0]
+	for k, v := range PairsBackward(it) {
+		assert.Equal(t, Int64Value(1), v)
+		keys = append(keys, string(k))
+	}
+	assert.Equal(t, []string{"d"}, keys)
+	assert.ErrorIs(t, it.Error(), ErrMergeOperand)
+}
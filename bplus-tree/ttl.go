@@ -0,0 +1,174 @@
+package bplustree
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Clock tells the tree what time it is when deciding whether an entry has
+// expired, and paces the reaper. Tests can pass a fake one to control expiry.
+type Clock interface {
+	Now() time.Time
+	// NewTicker returns a ticker that ticks every d, like time.NewTicker.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers the ticks of a Clock on C until it is stopped.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	t *time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time { return t.t.C }
+
+func (t systemTicker) Stop() { t.t.Stop() }
+
+// WithTTL enables InsertWithTTL. Expired entries are hidden from Get,
+// iterators and the read-modify-write helpers straight away, and removed for
+// good by Delete, ReapExpired or a reaper. A nil clock uses the system clock.
+// It can't be combined with WithDuplicates.
+func WithTTL(clock Clock) Option {
+	return func(b *BTree) {
+		if clock == nil {
+			clock = systemClock{}
+		}
+		b.clock = clock
+	}
+}
+
+// InsertWithTTL is Insert for an entry that expires after ttl.
+func (b *BTree) InsertWithTTL(key, value []byte, ttl time.Duration) error {
+	if b.clock == nil {
+		return fmt.Errorf("tree was not created with WithTTL")
+	}
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+
+	c := cell{
+		flags:     cellExpiry,
+		expiresAt: b.clock.Now().Add(ttl).UnixNano(),
+		value:     value,
+	}
+	return b.insert(key, encodeCell(c))
+}
+
+// ReapExpired deletes up to limit expired entries, or all of them if limit
+// is <= 0, and returns how many it deleted.
+func (b *BTree) ReapExpired(limit int) int {
+	deleted, _ := b.reapFrom(nil, limit)
+	return deleted
+}
+
+// reapFrom deletes up to limit (or unlimited if <= 0) expired entries with
+// key >= start. It returns how many were deleted and the key to continue
+// from, or nil once the end of the tree was reached.
+func (b *BTree) reapFrom(start []byte, limit int) (int, []byte) {
+	if b.clock == nil || b.root == nil {
+		return 0, nil
+	}
+
+	// collect first: deleting rebalances the leaves we are walking
+	expired := make([][]byte, 0)
+	var next []byte
+
+	n, idx := b.leftmostLeaf(), 0
+	if start != nil {
+		n, idx = b.findLeafPosition(start)
+	}
+
+scan:
+	for ; n != nil; n, idx = n.next, 0 {
+		for ; idx < len(n.key); idx++ {
+			if limit > 0 && len(expired) == limit {
+				next = n.leafKey(idx)
+				break scan
+			}
+			if b.rawExpired(n.value[idx]) {
+				expired = append(expired, n.leafKey(idx))
+			}
+		}
+	}
+
+	deleted := 0
+	for _, key := range expired {
+		// check again, the entry may have been replaced in the meantime
+		del := false
+		err := b.updateRaw(key, func(raw []byte, exists bool) ([]byte, updateOp, error) {
+			if del = exists && b.rawExpired(raw); !del {
+				return nil, opKeep, nil
+			}
+			return nil, opDelete, nil
+		})
+		if del && err == nil {
+			deleted++
+		}
+	}
+	return deleted, next
+}
+
+// Reaper deletes expired entries in the background, see StartReaper.
+type Reaper struct {
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// StartReaper starts a goroutine that deletes up to batchSize expired
+// entries every interval of the tree's clock, walking the tree a batch at a
+// time. The tree is not safe for concurrent use, so the reaper holds mu while
+// it touches the tree; every other user of the tree must hold the same lock.
+// The tree must have been created with WithTTL, and interval must be
+// positive.
+func (b *BTree) StartReaper(mu sync.Locker, interval time.Duration, batchSize int) (*Reaper, error) {
+	if b.clock == nil {
+		return nil, fmt.Errorf("tree was not created with WithTTL")
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("reaper interval must be positive, got %v", interval)
+	}
+
+	r := &Reaper{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(r.done)
+
+		ticker := b.clock.NewTicker(interval)
+		defer ticker.Stop()
+
+		var cursor []byte
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C():
+				mu.Lock()
+				_, cursor = b.reapFrom(cursor, batchSize)
+				mu.Unlock()
+			}
+		}
+	}()
+	return r, nil
+}
+
+// Stop stops the reaper and waits for it to exit.
+func (r *Reaper) Stop() {
+	r.once.Do(func() { close(r.stop) })
+	<-r.done
+}
@@ -0,0 +1,275 @@
+package bplustree
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{c: make(chan time.Time, 1), every: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the clock on by d, ticking every ticker that is due. Like a
+// time.Ticker, a ticker whose last tick wasn't received drops the new one.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		if t.stopped.Load() || t.next.After(c.now) {
+			continue
+		}
+		for !t.next.After(c.now) {
+			t.next = t.next.Add(t.every)
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+	}
+}
+
+type fakeTicker struct {
+	c       chan time.Time
+	every   time.Duration
+	next    time.Time
+	stopped atomic.Bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Stop() { t.stopped.Store(true) }
+
+func TestInsertWithTTL_Get(t *testing.T) {
+	clock := newFakeClock()
+	b := New(3, WithTTL(clock))
+
+	assert.NoError(t, b.InsertWithTTL([]byte("session"), []byte("alice"), time.Minute))
+	assert.NoError(t, b.Insert([]byte("forever"), []byte("bob")))
+
+	v, err := b.Get([]byte("session"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("alice"), v)
+
+	clock.Advance(time.Minute)
+
+	_, err = b.Get([]byte("session"))
+	assert.Error(t, err)
+
+	v, err = b.Get([]byte("forever"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("bob"), v)
+
+	// inserting again brings the key back without a TTL
+	assert.NoError(t, b.Insert([]byte("session"), []byte("carol")))
+	clock.Advance(time.Hour)
+	v, err = b.Get([]byte("session"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("carol"), v)
+}
+
+func TestInsertWithTTL_Iterators(t *testing.T) {
+	clock := newFakeClock()
+	b := New(2, WithTTL(clock))
+
+	// even keys expire after a minute, odd keys after an hour
+	for i := range 40 {
+		ttl := time.Hour
+		if i%2 == 0 {
+			ttl = time.Minute
+		}
+		assert.NoError(t, b.InsertWithTTL(convertIntToByte(i), []byte(fmt.Sprint(i)), ttl))
+	}
+	clock.Advance(2 * time.Minute)
+
+	forward := make([]int, 0)
+	for k, v := range b.All() {
+		forward = append(forward, convertBytetoInt(k))
+		assert.Equal(t, []byte(fmt.Sprint(convertBytetoInt(k))), v)
+	}
+	assert.Len(t, forward, 20)
+	for _, k := range forward {
+		assert.Equal(t, 1, k%2)
+	}
+
+	backward := make([]int, 0)
+	for k := range b.Backward() {
+		backward = append(backward, convertBytetoInt(k))
+	}
+	assert.Len(t, backward, 20)
+
+	ite := b.NewIter(nil)
+	assert.True(t, ite.SeekGE(convertIntToByte(10)))
+	assert.Equal(t, 11, convertBytetoInt(ite.Key()))
+	assert.True(t, ite.SeekLE(convertIntToByte(10)))
+	assert.Equal(t, 9, convertBytetoInt(ite.Key()))
+	assert.True(t, ite.First())
+	assert.Equal(t, 1, convertBytetoInt(ite.Key()))
+
+	clock.Advance(time.Hour)
+	assert.False(t, b.NewIter(nil).First())
+	assert.False(t, b.NewIter(nil).Last())
+}
+
+func TestInsertWithTTL_Delete(t *testing.T) {
+	clock := newFakeClock()
+	b := New(3, WithTTL(clock))
+
+	b.InsertWithTTL([]byte("k"), []byte("v"), time.Second)
+	clock.Advance(time.Second)
+
+	// the expired entry is removed but reported as missing
+	assert.Error(t, b.Delete([]byte("k")))
+	assert.Equal(t, 0, b.ReapExpired(0))
+}
+
+func TestInsertWithTTL_Update(t *testing.T) {
+	clock := newFakeClock()
+	b := New(3, WithTTL(clock))
+
+	b.InsertWithTTL([]byte("k"), []byte("v1"), time.Minute)
+
+	// updates keep the TTL
+	prev, loaded, err := b.Swap([]byte("k"), []byte("v2"))
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+	assert.Equal(t, []byte("v1"), prev)
+
+	clock.Advance(time.Minute)
+
+	// expired entries look missing to the update helpers
+	actual, loaded, err := b.GetOrInsert([]byte("k"), []byte("v3"))
+	assert.NoError(t, err)
+	assert.False(t, loaded)
+	assert.Equal(t, []byte("v3"), actual)
+
+	clock.Advance(time.Hour)
+	v, err := b.Get([]byte("k"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v3"), v)
+}
+
+func TestInsertWithTTL_Merge(t *testing.T) {
+	clock := newFakeClock()
+	b := New(3, WithTTL(clock), WithMergeOperator(Int64AddOperator{}))
+
+	b.InsertWithTTL([]byte("rate"), Int64Value(1), time.Minute)
+	b.Merge([]byte("rate"), Int64Value(1))
+
+	v, err := b.Get([]byte("rate"))
+	assert.NoError(t, err)
+	assert.Equal(t, Int64Value(2), v)
+
+	// the window expires together with its merges, then starts over
+	clock.Advance(time.Minute)
+	_, err = b.Get([]byte("rate"))
+	assert.Error(t, err)
+
+	b.Merge([]byte("rate"), Int64Value(1))
+	v, err = b.Get([]byte("rate"))
+	assert.NoError(t, err)
+	assert.Equal(t, Int64Value(1), v)
+}
+
+func TestInsertWithTTL_NotEnabled(t *testing.T) {
+	b := New(3)
+	assert.Error(t, b.InsertWithTTL([]byte("k"), []byte("v"), time.Minute))
+}
+
+func TestReapExpired(t *testing.T) {
+	clock := newFakeClock()
+	b := New(2, WithTTL(clock))
+
+	for i := range 100 {
+		ttl := time.Hour
+		if i%3 == 0 {
+			ttl = time.Minute
+		}
+		b.InsertWithTTL(convertIntToByte(i), []byte("v"), ttl)
+	}
+	clock.Advance(time.Minute)
+
+	assert.Equal(t, 10, b.ReapExpired(10))
+	assert.Equal(t, 24, b.ReapExpired(0))
+	assert.Equal(t, 0, b.ReapExpired(0))
+
+	// the expired keys are really gone, the others are untouched
+	n := 0
+	for node := b.leftmostLeaf(); node != nil; node = node.next {
+		for i := range node.key {
+			assert.NotEqual(t, 0, convertBytetoInt(node.leafKey(i))%3)
+			n++
+		}
+	}
+	assert.Equal(t, 66, n)
+}
+
+func TestReaper(t *testing.T) {
+	clock := newFakeClock()
+	b := New(2, WithTTL(clock))
+	var mu sync.Mutex
+
+	for i := range 50 {
+		b.InsertWithTTL(convertIntToByte(i), []byte("v"), time.Minute)
+	}
+	clock.Advance(time.Minute)
+
+	_, err := b.StartReaper(&mu, 0, 7)
+	assert.Error(t, err)
+	_, err = New(2).StartReaper(&mu, time.Second, 7)
+	assert.Error(t, err)
+
+	r, err := b.StartReaper(&mu, time.Second, 7)
+	assert.NoError(t, err)
+	defer r.Stop()
+
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		n := 0
+		for l := b.leftmostLeaf(); l != nil; l = l.next {
+			n += len(l.key)
+		}
+		return n
+	}
+
+	// the reaper runs on the tree's clock, not on the wall clock
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 50, count())
+
+	clock.Advance(time.Second)
+	assert.Eventually(t, func() bool { return count() == 43 }, 5*time.Second, time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		clock.Advance(time.Second)
+		return count() == 0
+	}, 5*time.Second, time.Millisecond)
+
+	r.Stop()
+	r.Stop() // stopping twice is fine
+}
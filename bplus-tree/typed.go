@@ -0,0 +1,155 @@
+package bplustree
+
+import "errors"
+
+// TypedTree wraps a BTree and handles encoding of keys and values so callers
+// don't have to deal with []byte directly.
+type TypedTree[K, V any] struct {
+	tree   *BTree
+	keys   Codec[K]
+	values Codec[V]
+}
+
+// NewTyped creates a typed tree of the given order. keys must be an
+// order-preserving codec.
+func NewTyped[K, V any](order int, keys Codec[K], values Codec[V]) *TypedTree[K, V] {
+	return &TypedTree[K, V]{
+		tree:   New(order),
+		keys:   keys,
+		values: values,
+	}
+}
+
+// Tree returns the underlying byte-keyed tree.
+func (t *TypedTree[K, V]) Tree() *BTree {
+	return t.tree
+}
+
+func (t *TypedTree[K, V]) Put(k K, v V) error {
+	return t.tree.Insert(t.keys.Encode(k), t.values.Encode(v))
+}
+
+// Get returns the value stored for k. The bool is false if the key is
+// missing, which is not an error; reading the value or decoding it failing
+// is.
+func (t *TypedTree[K, V]) Get(k K) (V, bool, error) {
+	var zero V
+
+	raw, err := t.tree.Get(t.keys.Encode(k))
+	if errors.Is(err, ErrNotFound) {
+		return zero, false, nil
+	}
+	if err != nil {
+		return zero, false, err
+	}
+
+	v, err := t.values.Decode(raw)
+	if err != nil {
+		return zero, false, err
+	}
+	return v, true, nil
+}
+
+func (t *TypedTree[K, V]) Delete(k K) error {
+	return t.tree.Delete(t.keys.Encode(k))
+}
+
+func (t *TypedTree[K, V]) Seek(k K) (*TypedIterator[K, V], error) {
+	it, err := t.tree.Seek(t.keys.Encode(k))
+	if err != nil {
+		return nil, err
+	}
+	return t.wrap(it), nil
+}
+
+func (t *TypedTree[K, V]) SeekFirst() *TypedIterator[K, V] {
+	return t.wrap(t.tree.SeekFirst())
+}
+
+func (t *TypedTree[K, V]) SeekLast() *TypedIterator[K, V] {
+	return t.wrap(t.tree.SeekLast())
+}
+
+// Range calls fn for every entry with start <= key < end in ascending order,
+// stopping early if fn returns false.
+func (t *TypedTree[K, V]) Range(start, end K, fn func(k K, v V) bool) error {
+	it := t.wrap(t.tree.NewIter(&IterOptions{
+		LowerBound: t.keys.Encode(start),
+		UpperBound: t.keys.Encode(end),
+	}))
+	it.it.First()
+
+	for ; it.Valid(); it.Next() {
+		k, v, err := it.decode()
+		if err != nil {
+			return err
+		}
+		if !fn(k, v) {
+			break
+		}
+	}
+	return nil
+}
+
+func (t *TypedTree[K, V]) wrap(it Iterator) *TypedIterator[K, V] {
+	if it == nil {
+		// empty tree, hand back an iterator that is never valid
+		it = t.tree.NewIter(nil)
+	}
+	return &TypedIterator[K, V]{it: it, tree: t}
+}
+
+// TypedIterator walks a TypedTree, decoding entries as it goes. Decoding
+// failures make Key and Value return zero values and are reported by Err.
+type TypedIterator[K, V any] struct {
+	it   Iterator
+	tree *TypedTree[K, V]
+	err  error
+}
+
+func (i *TypedIterator[K, V]) Valid() bool {
+	return i.it.Valid()
+}
+
+func (i *TypedIterator[K, V]) Next() {
+	i.it.Next()
+}
+
+func (i *TypedIterator[K, V]) Prev() {
+	i.it.Prev()
+}
+
+func (i *TypedIterator[K, V]) Key() K {
+	k, err := i.tree.keys.Decode(i.it.Key())
+	if err != nil {
+		i.err = err
+	}
+	return k
+}
+
+func (i *TypedIterator[K, V]) Value() V {
+	v, err := i.tree.values.Decode(i.it.Value())
+	if err != nil {
+		i.err = err
+	}
+	return v
+}
+
+// Err returns the first decoding error seen by the iterator.
+func (i *TypedIterator[K, V]) Err() error {
+	return i.err
+}
+
+func (i *TypedIterator[K, V]) decode() (K, V, error) {
+	var (
+		k K
+		v V
+	)
+
+	k, err := i.tree.keys.Decode(i.it.Key())
+	if err != nil {
+		return k, v, err
+	}
+	v, err = i.tree.values.Decode(i.it.Value())
+	return k, v, err
+}
@@ -0,0 +1,161 @@
+package bplustree
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypedTree_PutGet(t *testing.T) {
+	tree := NewTyped[int64, string](3, IntCodec[int64]{}, StringCodec{})
+
+	for i := int64(-20); i < 20; i++ {
+		assert.NoError(t, tree.Put(i, fmt.Sprintf("v%d", i)))
+	}
+
+	v, ok, err := tree.Get(-7)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "v-7", v)
+
+	_, ok, err = tree.Get(100)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, tree.Delete(-7))
+	_, ok, err = tree.Get(-7)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// a value that doesn't decode is an error, not a missing key
+	ints := NewTyped[int64, int64](3, IntCodec[int64]{}, IntCodec[int64]{})
+	assert.NoError(t, ints.Tree().Insert(IntCodec[int64]{}.Encode(-7), []byte("x")))
+	_, ok, err = ints.Get(-7)
+	assert.Error(t, err)
+	assert.False(t, ok)
+}
+
+func TestTypedTree_IterationOrder(t *testing.T) {
+	tree := NewTyped[int, int](3, IntCodec[int]{}, IntCodec[int]{})
+
+	for _, k := range []int{5, -1, 0, -100, 42, 7, -3} {
+		assert.NoError(t, tree.Put(k, k*2))
+	}
+
+	keys := make([]int, 0)
+	for it := tree.SeekFirst(); it.Valid(); it.Next() {
+		keys = append(keys, it.Key())
+		assert.Equal(t, it.Key()*2, it.Value())
+	}
+	assert.Equal(t, []int{-100, -3, -1, 0, 5, 7, 42}, keys)
+
+	keys = keys[:0]
+	for it := tree.SeekLast(); it.Valid(); it.Prev() {
+		keys = append(keys, it.Key())
+	}
+	assert.Equal(t, []int{42, 7, 5, 0, -1, -3, -100}, keys)
+}
+
+func TestTypedTree_Range(t *testing.T) {
+	tree := NewTyped[uint32, string](3, UintCodec[uint32]{}, StringCodec{})
+
+	for i := range uint32(50) {
+		assert.NoError(t, tree.Put(i, fmt.Sprintf("v%d", i)))
+	}
+
+	got := make([]uint32, 0)
+	err := tree.Range(10, 15, func(k uint32, v string) bool {
+		got = append(got, k)
+		assert.Equal(t, fmt.Sprintf("v%d", k), v)
+		return true
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{10, 11, 12, 13, 14}, got)
+
+	// stop early
+	got = got[:0]
+	err = tree.Range(0, 50, func(k uint32, v string) bool {
+		got = append(got, k)
+		return len(got) < 3
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{0, 1, 2}, got)
+}
+
+func TestTypedTree_TimeKeys(t *testing.T) {
+	tree := NewTyped[time.Time, string](3, TimeCodec{}, StringCodec{})
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	times := []time.Time{
+		base.Add(time.Hour),
+		base.Add(-time.Hour),
+		time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC),
+		base.Add(time.Nanosecond),
+		base,
+	}
+	for _, ts := range times {
+		assert.NoError(t, tree.Put(ts, ts.String()))
+	}
+
+	prev := time.Time{}
+	for it := tree.SeekFirst(); it.Valid(); it.Next() {
+		assert.True(t, it.Key().After(prev))
+		prev = it.Key()
+	}
+	assert.NoError(t, tree.SeekFirst().Err())
+
+	v, ok, err := tree.Get(base.Add(time.Nanosecond))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, base.Add(time.Nanosecond).String(), v)
+}
+
+func TestTypedTree_ArrayKeys(t *testing.T) {
+	type uuid [16]byte
+
+	tree := NewTyped[uuid, []byte](3, ArrayCodec[uuid]{}, BytesCodec{})
+
+	id := uuid{0xde, 0xad, 0xbe, 0xef}
+	assert.NoError(t, tree.Put(id, []byte("v")))
+
+	v, ok, err := tree.Get(id)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("v"), v)
+
+	it := tree.SeekFirst()
+	assert.Equal(t, id, it.Key())
+
+	hash, err := ArrayCodec[[32]byte]{}.Decode(make([]byte, 32))
+	assert.NoError(t, err)
+	assert.Equal(t, [32]byte{}, hash)
+	_, err = ArrayCodec[uuid]{}.Decode(make([]byte, 15))
+	assert.Error(t, err)
+}
+
+func TestTypedTree_Empty(t *testing.T) {
+	tree := NewTyped[int, int](3, IntCodec[int]{}, IntCodec[int]{})
+
+	assert.False(t, tree.SeekFirst().Valid())
+	assert.False(t, tree.SeekLast().Valid())
+
+	_, ok, err := tree.Get(1)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestTypedTree_DescAndFloatKeys(t *testing.T) {
+	tree := NewTyped[float64, string](3, DescCodec[float64]{Codec: FloatCodec[float64]{}}, StringCodec{})
+
+	for _, f := range []float64{1.5, -2, 0, 100, -0.25} {
+		assert.NoError(t, tree.Put(f, fmt.Sprint(f)))
+	}
+
+	keys := make([]float64, 0)
+	for it := tree.SeekFirst(); it.Valid(); it.Next() {
+		keys = append(keys, it.Key())
+	}
+	assert.Equal(t, []float64{100, 1.5, 0, -0.25, -2}, keys)
+}
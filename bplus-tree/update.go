@@ -0,0 +1,196 @@
+package bplustree
+
+import "bytes"
+
+// Read-modify-write helpers. Each one finds the key with a single descent and
+// applies the change directly at the leaf, instead of a Get followed by an
+// Insert or Delete. They are not supported on multimap trees, where a key
+// doesn't identify a single value.
+
+// UpdateFunc receives the current value of a key, or nil and false if the key
+// doesn't exist. It returns the value to store, or del == true to delete the
+// key (a no-op if it doesn't exist). old is a view into the tree: it must not
+// be modified or kept after the function returns. The returned value is
+// copied, as with Insert.
+type UpdateFunc func(old []byte, exists bool) (new []byte, del bool)
+
+// Update applies fn to the current value of key.
+func (b *BTree) Update(key []byte, fn UpdateFunc) error {
+	return b.update(key, func(old []byte, exists bool) ([]byte, updateOp) {
+		var (
+			value []byte
+			del   bool
+		)
+		b.callback(func() { value, del = fn(old, exists) })
+		if del {
+			return nil, opDelete
+		}
+		return value, opStore
+	})
+}
+
+// updateOp is what an update does with its key.
+type updateOp int
+
+const (
+	opStore  updateOp = iota // store the value returned along with it
+	opDelete                 // delete the key, if it exists
+	opKeep                   // leave the key as it is
+)
+
+// update is Update with the option to leave the key alone, for callers that
+// only write depending on what they find.
+func (b *BTree) update(key []byte, fn func(old []byte, exists bool) ([]byte, updateOp)) error {
+	return b.updateRaw(key, func(raw []byte, exists bool) ([]byte, updateOp, error) {
+		if !b.usesCells() {
+			value, op := fn(raw, exists)
+			return value, op, nil
+		}
+
+		var (
+			old  []byte
+			prev cell
+		)
+		if exists {
+			var err error
+			if prev, err = decodeCell(raw); err != nil {
+				return nil, opKeep, err
+			}
+			if b.cellExpired(prev) {
+				exists, prev = false, cell{}
+			} else if old, err = b.cellValue(key, prev); err != nil {
+				return nil, opKeep, err
+			}
+		}
+
+		value, op := fn(old, exists)
+		if op != opStore {
+			return nil, op, nil
+		}
+		// an update keeps the TTL of the entry it replaces
+		return encodeCell(cell{value: value}.withExpiryOf(prev)), op, nil
+	})
+}
+
+// updateRaw is update working on the value as stored in the leaf, so callers
+// such as Merge can look at cells. If fn fails the tree is left unchanged.
+// When fn leaves the key as it is, nothing is written: that works on trees
+// that can't be written to, such as versions of a persistent tree.
+func (b *BTree) updateRaw(key []byte, fn func(raw []byte, exists bool) ([]byte, updateOp, error)) (err error) {
+	if b.dups != DupNone {
+		return ErrMultimap
+	}
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	defer b.recoverCorruption(&err)
+
+	var (
+		curr *Node
+		path []*Node
+	)
+	for curr = b.root; curr != nil && !curr.IsLeaf(); curr = b.traverseRightOrLeft(curr, key) {
+		path = append(path, curr)
+	}
+
+	var (
+		idx    int
+		exists bool
+		old    []byte
+	)
+	if curr != nil {
+		idx = b.findKeyIndexInNode(curr, key)
+		exists = idx < len(curr.key) && curr.keyEqual(idx, key)
+	}
+	if exists {
+		old = curr.value[idx]
+	}
+
+	raw, op, err := fn(old, exists)
+	if err != nil {
+		return err
+	}
+	if op == opKeep || op == opDelete && !exists {
+		return nil
+	}
+	if err := b.writable(); err != nil {
+		return err
+	}
+
+	changes, err := b.indexChanges(key, old, exists, raw, op == opStore)
+	if err != nil {
+		return err
+	}
+
+	if curr == nil {
+		b.root = newLeaf(key, raw)
+		b.root.edit = b.edit
+		b.applyIndexChanges(key, changes)
+		return nil
+	}
+	if b.shared {
+		// the nodes found above may belong to another version
+		curr, path = b.pathToLeaf(key)
+	}
+
+	switch {
+	case op == opDelete:
+		b.deleteFromLeaf(curr, idx, path)
+	case exists:
+		curr.value[idx] = curr.store(raw)
+	default:
+		b.insertKVInLeafInPlace(curr, key, raw, idx)
+		if b.checkMaxKeys(len(curr.key)) {
+			_, _ = b.splitNode(curr, path)
+		}
+	}
+	b.applyIndexChanges(key, changes)
+	return nil
+}
+
+// CompareAndSwap stores new for key if its current value equals old and
+// reports whether it did. A missing key never matches. On error nothing was
+// swapped.
+func (b *BTree) CompareAndSwap(key, old, new []byte) (bool, error) {
+	swapped := false
+	err := b.update(key, func(curr []byte, exists bool) ([]byte, updateOp) {
+		if !exists || !bytes.Equal(curr, old) {
+			return nil, opKeep
+		}
+		swapped = true
+		return new, opStore
+	})
+	return swapped && err == nil, err
+}
+
+// GetOrInsert returns the existing value for key if present. Otherwise it
+// stores value and returns it. loaded reports whether the value was already
+// there. On error actual is nil and loaded false.
+func (b *BTree) GetOrInsert(key, value []byte) (actual []byte, loaded bool, err error) {
+	err = b.update(key, func(curr []byte, exists bool) ([]byte, updateOp) {
+		loaded = exists
+		if exists {
+			actual = bytes.Clone(curr)
+			return nil, opKeep
+		}
+		actual = value
+		return value, opStore
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return actual, loaded, nil
+}
+
+// Swap stores value for key and returns the previous value, if any. loaded
+// reports whether the key existed. On error previous is nil and loaded false.
+func (b *BTree) Swap(key, value []byte) (previous []byte, loaded bool, err error) {
+	err = b.Update(key, func(curr []byte, exists bool) ([]byte, bool) {
+		previous, loaded = bytes.Clone(curr), exists
+		return value, false
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return previous, loaded, nil
+}
@@ -0,0 +1,174 @@
+package bplustree
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdate(t *testing.T) {
+	b := New(3)
+
+	incr := func(old []byte, exists bool) ([]byte, bool) {
+		n := 0
+		if exists {
+			n, _ = strconv.Atoi(string(old))
+		}
+		return []byte(strconv.Itoa(n + 1)), false
+	}
+
+	// insert into an empty tree, then keep updating
+	for range 5 {
+		assert.NoError(t, b.Update([]byte("counter"), incr))
+	}
+	v, err := b.Get([]byte("counter"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("5"), v)
+
+	// delete through Update
+	assert.NoError(t, b.Update([]byte("counter"), func(old []byte, exists bool) ([]byte, bool) {
+		assert.True(t, exists)
+		return nil, true
+	}))
+	_, err = b.Get([]byte("counter"))
+	assert.Error(t, err)
+
+	// deleting a missing key is a no-op
+	assert.NoError(t, b.Update([]byte("missing"), func(old []byte, exists bool) ([]byte, bool) {
+		assert.False(t, exists)
+		assert.Nil(t, old)
+		return nil, true
+	}))
+}
+
+func TestUpdate_Randomized(t *testing.T) {
+	rnd := rand.New(rand.NewSource(3))
+	b := New(2)
+	ref := make(map[string][]byte)
+
+	for range 3000 {
+		k := fmt.Sprintf("k%03d", rnd.Intn(200))
+		del := rnd.Intn(3) == 0
+		v := []byte(fmt.Sprintf("v%d", rnd.Intn(1000)))
+
+		err := b.Update([]byte(k), func(old []byte, exists bool) ([]byte, bool) {
+			want, ok := ref[k]
+			assert.Equal(t, ok, exists)
+			assert.Equal(t, want, old)
+			return v, del
+		})
+		assert.NoError(t, err)
+
+		if del {
+			delete(ref, k)
+		} else {
+			ref[k] = v
+		}
+	}
+
+	n := 0
+	for k, v := range b.All() {
+		assert.Equal(t, ref[string(k)], v)
+		n++
+	}
+	assert.Equal(t, len(ref), n)
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	b := New(3)
+	b.Insert([]byte("k"), []byte("v1"))
+
+	swapped, err := b.CompareAndSwap([]byte("k"), []byte("nope"), []byte("v2"))
+	assert.NoError(t, err)
+	assert.False(t, swapped)
+
+	swapped, err = b.CompareAndSwap([]byte("k"), []byte("v1"), []byte("v2"))
+	assert.NoError(t, err)
+	assert.True(t, swapped)
+
+	v, _ := b.Get([]byte("k"))
+	assert.Equal(t, []byte("v2"), v)
+
+	// missing keys never match and are not created
+	swapped, err = b.CompareAndSwap([]byte("missing"), nil, []byte("v"))
+	assert.NoError(t, err)
+	assert.False(t, swapped)
+	_, err = b.Get([]byte("missing"))
+	assert.Error(t, err)
+}
+
+func TestGetOrInsert(t *testing.T) {
+	b := New(3)
+
+	actual, loaded, err := b.GetOrInsert([]byte("k"), []byte("first"))
+	assert.NoError(t, err)
+	assert.False(t, loaded)
+	assert.Equal(t, []byte("first"), actual)
+
+	actual, loaded, err = b.GetOrInsert([]byte("k"), []byte("second"))
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+	assert.Equal(t, []byte("first"), actual)
+}
+
+func TestUpdate_ReadOnlyOutcomes(t *testing.T) {
+	// a compare that fails or a key that is already there doesn't write, so
+	// it works on trees that can't be written to
+	v, err := New(2, WithStructuralSharing()).InsertVersion([]byte("k"), []byte("v1"))
+	assert.NoError(t, err)
+
+	swapped, err := v.CompareAndSwap([]byte("k"), []byte("nope"), []byte("v2"))
+	assert.NoError(t, err)
+	assert.False(t, swapped)
+	actual, loaded, err := v.GetOrInsert([]byte("k"), []byte("v2"))
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+	assert.Equal(t, []byte("v1"), actual)
+	assert.NoError(t, v.Update([]byte("missing"), func([]byte, bool) ([]byte, bool) { return nil, true }))
+
+	// writes fail, and report that nothing happened
+	swapped, err = v.CompareAndSwap([]byte("k"), []byte("v1"), []byte("v2"))
+	assert.ErrorIs(t, err, ErrImmutable)
+	assert.False(t, swapped)
+	actual, loaded, err = v.GetOrInsert([]byte("other"), []byte("v"))
+	assert.ErrorIs(t, err, ErrImmutable)
+	assert.Nil(t, actual)
+	assert.False(t, loaded)
+	actual, loaded, err = v.Swap([]byte("k"), []byte("v2"))
+	assert.ErrorIs(t, err, ErrImmutable)
+	assert.Nil(t, actual)
+	assert.False(t, loaded)
+	assert.Equal(t, map[string]string{"k": "v1"}, contents(v))
+}
+
+func TestSwap(t *testing.T) {
+	b := New(3)
+
+	prev, loaded, err := b.Swap([]byte("k"), []byte("v1"))
+	assert.NoError(t, err)
+	assert.False(t, loaded)
+	assert.Nil(t, prev)
+
+	prev, loaded, err = b.Swap([]byte("k"), []byte("v2"))
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+	assert.Equal(t, []byte("v1"), prev)
+
+	v, _ := b.Get([]byte("k"))
+	assert.Equal(t, []byte("v2"), v)
+}
+
+func TestUpdate_Multimap(t *testing.T) {
+	b := New(3, WithDuplicates(DupInsertionOrder))
+
+	err := b.Update([]byte("k"), func(old []byte, exists bool) ([]byte, bool) {
+		return []byte("v"), false
+	})
+	assert.ErrorIs(t, err, ErrMultimap)
+
+	_, err = b.CompareAndSwap([]byte("k"), nil, []byte("v"))
+	assert.ErrorIs(t, err, ErrMultimap)
+}
@@ -0,0 +1,277 @@
+package bplustree
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Invariants checked by Verify, as reported in Violation.Invariant.
+const (
+	InvariantKeyOrder  = "key order"
+	InvariantSeparator = "separator bounds"
+	InvariantFanout    = "children count"
+	InvariantOccupancy = "occupancy"
+	InvariantLeafDepth = "leaf depth"
+	InvariantLeafChain = "leaf chain"
+	InvariantValue     = "value"
+	InvariantShape     = "shape"
+)
+
+// Violation is a broken invariant found by Verify.
+type Violation struct {
+	// Path holds the child indexes leading from the root to the node; it is
+	// empty for the root.
+	Path      []int
+	Invariant string
+	Detail    string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("node %v: %s: %s", v.Path, v.Invariant, v.Detail)
+}
+
+// VerifyReport is the result of Verify.
+type VerifyReport struct {
+	Height     int // number of levels, 0 for an empty tree
+	Nodes      int
+	Leaves     int
+	Entries    int
+	Violations []Violation
+}
+
+// OK reports whether no invariant is broken.
+func (r *VerifyReport) OK() bool {
+	return len(r.Violations) == 0
+}
+
+// Err returns nil if the tree is fine, or a *CorruptionError describing the
+// first violation otherwise.
+func (r *VerifyReport) Err() error {
+	if r.OK() {
+		return nil
+	}
+	return &CorruptionError{Reason: fmt.Sprintf("%s (%d violations in total)", r.Violations[0], len(r.Violations))}
+}
+
+func (r *VerifyReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "height %d, %d nodes, %d leaves, %d entries, %d violations",
+		r.Height, r.Nodes, r.Leaves, r.Entries, len(r.Violations))
+	for _, v := range r.Violations {
+		sb.WriteString("\n  ")
+		sb.WriteString(v.String())
+	}
+	return sb.String()
+}
+
+// Verify walks the whole tree and reports every broken structural invariant:
+// key order within and across nodes, keys outside the bounds set by the
+// separators above them, children counts, node occupancy, leaves at
+// different depths and a leaf chain that doesn't match the in-order leaves.
+// It doesn't rely on the invariants it checks, so it is safe to run on a
+// corrupt tree.
+func (b *BTree) Verify() *VerifyReport {
+	r := &VerifyReport{}
+	if b.root == nil {
+		return r
+	}
+
+	v := &verifier{
+		b:         b,
+		r:         r,
+		seen:      make(map[*Node]bool),
+		leafDepth: -1,
+	}
+	v.node(b.root, nil, nil, nil)
+	v.leafChain()
+	return r
+}
+
+type verifier struct {
+	b *BTree
+	r *VerifyReport
+
+	seen      map[*Node]bool
+	leafDepth int
+	leaves    []*Node // in order
+
+	// the last entry seen, to check order across leaves
+	hasPrev   bool
+	prevKey   []byte
+	prevValue []byte
+}
+
+func (v *verifier) add(path []int, invariant, format string, args ...any) {
+	v.r.Violations = append(v.r.Violations, Violation{
+		Path:      append([]int(nil), path...),
+		Invariant: invariant,
+		Detail:    fmt.Sprintf(format, args...),
+	})
+}
+
+// node checks n, reached through path, whose keys must lie within lo and hi
+// as set by the separators above it (nil for no bound).
+func (v *verifier) node(n *Node, path []int, lo, hi []byte) {
+	if n == nil {
+		v.add(path, InvariantShape, "missing node")
+		return
+	}
+	if v.seen[n] {
+		v.add(path, InvariantShape, "node reachable through more than one path")
+		return
+	}
+	v.seen[n] = true
+	v.r.Nodes++
+	v.r.Height = max(v.r.Height, len(path)+1)
+
+	v.occupancy(n, path)
+	v.bounds(n, path, lo, hi)
+
+	if n.IsLeaf() {
+		v.leaf(n, path)
+		return
+	}
+
+	if len(n.children) != len(n.key)+1 {
+		v.add(path, InvariantFanout, "%d children for %d keys", len(n.children), len(n.key))
+	}
+	for i := 1; i < len(n.key); i++ {
+		if !v.ordered(n.key[i-1], n.key[i]) {
+			v.add(path, InvariantKeyOrder, "separator %d (%x) out of order with %x", i, n.key[i], n.key[i-1])
+		}
+	}
+
+	for i, child := range n.children {
+		clo, chi := lo, hi
+		if i > 0 && i-1 < len(n.key) {
+			clo = n.key[i-1]
+		}
+		if i < len(n.key) {
+			chi = n.key[i]
+		}
+		v.node(child, append(path, i), clo, chi)
+	}
+}
+
+func (v *verifier) occupancy(n *Node, path []int) {
+	if len(path) == 0 {
+		if !n.IsLeaf() && len(n.key) == 0 {
+			v.add(path, InvariantOccupancy, "internal root without keys")
+		}
+		return
+	}
+
+	// splits leave order keys in the left node, and deletes rebalance a node
+	// once it drops below that
+	if len(n.key) < v.b.order {
+		v.add(path, InvariantOccupancy, "%d keys, expected at least %d", len(n.key), v.b.order)
+	}
+	if v.b.checkMaxKeys(len(n.key)) {
+		v.add(path, InvariantOccupancy, "%d keys, expected at most %d", len(n.key), 2*v.b.order)
+	}
+}
+
+// bounds checks every key of n against the separators above it: keys go
+// right of an equal separator, except in a multimap where a run of equal
+// keys can end on either side of it.
+func (v *verifier) bounds(n *Node, path []int, lo, hi []byte) {
+	for i, k := range n.key {
+		if n.IsLeaf() {
+			k = n.leafKey(i)
+		}
+		if lo != nil && bytes.Compare(k, lo) < 0 {
+			v.add(path, InvariantSeparator, "key %d (%x) below separator %x", i, k, lo)
+		}
+		if hi == nil {
+			continue
+		}
+		if c := bytes.Compare(k, hi); c > 0 || c == 0 && v.b.dups == DupNone {
+			v.add(path, InvariantSeparator, "key %d (%x) not below separator %x", i, k, hi)
+		}
+	}
+}
+
+func (v *verifier) leaf(n *Node, path []int) {
+	v.r.Leaves++
+	v.leaves = append(v.leaves, n)
+
+	if v.leafDepth == -1 {
+		v.leafDepth = len(path)
+	} else if len(path) != v.leafDepth {
+		v.add(path, InvariantLeafDepth, "leaf at depth %d, expected %d", len(path), v.leafDepth)
+	}
+
+	if len(n.value) != len(n.key) {
+		v.add(path, InvariantShape, "%d values for %d keys", len(n.value), len(n.key))
+		return
+	}
+
+	for i := range n.key {
+		k := n.leafKey(i)
+		v.r.Entries++
+
+		if v.hasPrev && !v.entryOrdered(v.prevKey, v.prevValue, k, n.value[i]) {
+			v.add(path, InvariantKeyOrder, "entry %d (%x) out of order with the one before (%x)", i, k, v.prevKey)
+		}
+		v.hasPrev, v.prevKey, v.prevValue = true, k, n.value[i]
+
+		if v.b.usesCells() {
+			if _, err := decodeCell(n.value[i]); err != nil {
+				v.add(path, InvariantValue, "entry %d (%x): %v", i, k, err)
+			}
+		}
+	}
+}
+
+// ordered reports whether a may come before b in a node: strictly ascending,
+// or ascending in a multimap.
+func (v *verifier) ordered(a, b []byte) bool {
+	c := bytes.Compare(a, b)
+	return c < 0 || c == 0 && v.b.dups != DupNone
+}
+
+func (v *verifier) entryOrdered(prevKey, prevValue, key, value []byte) bool {
+	if !v.ordered(prevKey, key) {
+		return false
+	}
+	if v.b.dups == DupValueOrder && bytes.Equal(prevKey, key) {
+		return bytes.Compare(prevValue, value) < 0
+	}
+	return true
+}
+
+// leafChain checks that following next from the first leaf visits the
+// leaves in order, and that prev points back. Persistent trees have no chain.
+func (v *verifier) leafChain() {
+	if len(v.leaves) == 0 {
+		return
+	}
+	if v.b.shared {
+		// leaves of persistent trees aren't linked, see WithStructuralSharing
+		for i, n := range v.leaves {
+			if n.next != nil || n.prev != nil {
+				v.add(nil, InvariantLeafChain, "leaf %d of a persistent tree is linked", i)
+			}
+		}
+		return
+	}
+	if v.leaves[0].prev != nil {
+		v.add(nil, InvariantLeafChain, "first leaf has a prev pointer")
+	}
+
+	n := v.leaves[0]
+	for i, want := range v.leaves {
+		if n != want {
+			v.add(nil, InvariantLeafChain, "leaf %d in the chain is not leaf %d in key order", i, i)
+			return
+		}
+		if i > 0 && n.prev != v.leaves[i-1] {
+			v.add(nil, InvariantLeafChain, "prev pointer of leaf %d doesn't point at leaf %d", i, i-1)
+		}
+		n = n.next
+	}
+	if n != nil {
+		v.add(nil, InvariantLeafChain, "last leaf has a next pointer")
+	}
+}
@@ -0,0 +1,154 @@
+package bplustree
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func invariants(r *VerifyReport) []string {
+	names := make([]string, 0, len(r.Violations))
+	for _, v := range r.Violations {
+		names = append(names, v.Invariant)
+	}
+	return names
+}
+
+func verifiedTree(t *testing.T, order, n int) *BTree {
+	b := New(order)
+	for i := range n {
+		b.InsertInt(i, []byte("v"))
+	}
+	r := b.Verify()
+	assert.True(t, r.OK(), r.String())
+	return b
+}
+
+func TestVerify_Healthy(t *testing.T) {
+	for _, dups := range []DupOrder{DupNone, DupInsertionOrder, DupValueOrder} {
+		for order := 1; order <= 4; order++ {
+			r := rand.New(rand.NewSource(int64(order)))
+			b := New(order, WithDuplicates(dups))
+
+			for op := range 2000 {
+				k := []byte(fmt.Sprintf("k%03d", r.Intn(150)))
+				if r.Intn(3) > 0 {
+					b.Insert(k, []byte(fmt.Sprint(r.Intn(4))))
+				} else {
+					b.Delete(k)
+				}
+				if op%50 == 0 {
+					rep := b.Verify()
+					assert.True(t, rep.OK(), "dups %d order %d op %d: %s", dups, order, op, rep)
+				}
+			}
+		}
+	}
+
+	empty := New(3).Verify()
+	assert.True(t, empty.OK())
+	assert.Equal(t, 0, empty.Height)
+	assert.NoError(t, empty.Err())
+}
+
+// Merging two internal nodes used to leave the result with one key more than
+// the maximum.
+func TestVerify_InternalMergeOverflow(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	b := New(1)
+	for op := range 200 {
+		k := []byte(fmt.Sprintf("k%03d", r.Intn(120)))
+		if r.Intn(3) > 0 {
+			b.Insert(k, []byte(fmt.Sprint(r.Intn(4))))
+		} else {
+			b.Delete(k)
+		}
+		rep := b.Verify()
+		assert.True(t, rep.OK(), "op %d: %s", op, rep)
+	}
+}
+
+func TestVerify_Counts(t *testing.T) {
+	b := verifiedTree(t, 2, 100)
+	r := b.Verify()
+
+	assert.Equal(t, 100, r.Entries)
+	assert.Greater(t, r.Height, 2)
+	assert.Greater(t, r.Nodes, r.Leaves)
+}
+
+func TestVerify_KeyOrder(t *testing.T) {
+	b := verifiedTree(t, 2, 100)
+	leaf := b.leftmostLeaf()
+	leaf.key[0], leaf.key[1] = leaf.key[1], leaf.key[0]
+
+	r := b.Verify()
+	assert.Contains(t, invariants(r), InvariantKeyOrder)
+	assert.ErrorIs(t, r.Err(), ErrCorrupt)
+}
+
+func TestVerify_Separator(t *testing.T) {
+	b := verifiedTree(t, 2, 100)
+	leaf := b.leftmostLeaf().next
+	leaf.key[len(leaf.key)-1] = convertIntToByte(1000)
+
+	assert.Contains(t, invariants(b.Verify()), InvariantSeparator)
+}
+
+func TestVerify_Fanout(t *testing.T) {
+	b := verifiedTree(t, 2, 100)
+	b.root.children = b.root.children[:len(b.root.children)-1]
+
+	assert.Contains(t, invariants(b.Verify()), InvariantFanout)
+}
+
+func TestVerify_Occupancy(t *testing.T) {
+	b := verifiedTree(t, 2, 100)
+	leaf := b.leftmostLeaf()
+	leaf.key, leaf.value = leaf.key[:1], leaf.value[:1]
+
+	assert.Contains(t, invariants(b.Verify()), InvariantOccupancy)
+}
+
+func TestVerify_LeafDepth(t *testing.T) {
+	b := verifiedTree(t, 2, 100)
+	// replace the first subtree with one of its leaves
+	b.root.children[0] = b.leftmostLeaf()
+
+	assert.Contains(t, invariants(b.Verify()), InvariantLeafDepth)
+}
+
+func TestVerify_LeafChain(t *testing.T) {
+	b := verifiedTree(t, 2, 100)
+	first := b.leftmostLeaf()
+	first.next = first.next.next
+
+	assert.Contains(t, invariants(b.Verify()), InvariantLeafChain)
+
+	b = verifiedTree(t, 2, 100)
+	b.leftmostLeaf().next.prev = nil
+	r := b.Verify()
+	assert.Equal(t, []string{InvariantLeafChain}, invariants(r))
+	assert.Equal(t, []int(nil), r.Violations[0].Path)
+}
+
+func TestVerify_Cycle(t *testing.T) {
+	b := verifiedTree(t, 2, 100)
+	inner := b.root.children[0]
+	inner.children[1] = b.root
+
+	assert.Contains(t, invariants(b.Verify()), InvariantShape)
+}
+
+func TestVerify_Values(t *testing.T) {
+	b := New(2, WithMergeOperator(Int64AddOperator{}))
+	for i := range 20 {
+		b.Merge(convertIntToByte(i), Int64Value(1))
+	}
+	assert.True(t, b.Verify().OK())
+
+	b.leftmostLeaf().value[0] = []byte{cellMerge}
+	assert.Equal(t, []string{InvariantValue}, invariants(b.Verify()))
+}
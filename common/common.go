@@ -2,9 +2,19 @@ package common
 
 import "fmt"
 
+// AssertionError is the value Assert panics with, so code that turns
+// invariant violations into errors can tell them apart from other panics.
+type AssertionError struct {
+	Msg string
+}
+
+func (e *AssertionError) Error() string {
+	return "assertion failed: " + e.Msg
+}
+
 // Assert panics with a formatted message if the given condition is false.
 func Assert(condition bool, msg string, v ...any) {
 	if !condition {
-		panic(fmt.Sprintf("assertion failed: "+msg, v...))
+		panic(&AssertionError{Msg: fmt.Sprintf(msg, v...)})
 	}
 }
@@ -0,0 +1,49 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors shared by the tree and the storage layers under it. Check them with
+// errors.Is, or errors.As for *CorruptionError.
+var (
+	// ErrNotFound is returned when a key, or key and value pair, doesn't
+	// exist. An empty tree is no different from a tree missing the key.
+	ErrNotFound = errors.New("no key found")
+	// ErrEmptyKey is returned for operations given an empty key.
+	ErrEmptyKey = errors.New("got empty key")
+	// ErrClosed is returned when using something that was closed.
+	ErrClosed = errors.New("already closed")
+	// ErrTxnConflict is returned when a transaction can't commit because
+	// another one changed the data it depends on.
+	ErrTxnConflict = errors.New("transaction conflict")
+	// ErrCorrupt matches every *CorruptionError.
+	ErrCorrupt = errors.New("corrupt data")
+)
+
+// CorruptionError reports data that breaks the expected format or a
+// structural invariant.
+type CorruptionError struct {
+	// PageID is the page the corruption was found on, or 0 if it wasn't on a
+	// page (page 0 is never a data page).
+	PageID uint64
+	// Reason describes what is wrong.
+	Reason string
+}
+
+// Corruptf returns a *CorruptionError not tied to a page.
+func Corruptf(format string, v ...any) error {
+	return &CorruptionError{Reason: fmt.Sprintf(format, v...)}
+}
+
+func (e *CorruptionError) Error() string {
+	if e.PageID == 0 {
+		return "corrupt data: " + e.Reason
+	}
+	return fmt.Sprintf("corrupt data on page %d: %s", e.PageID, e.Reason)
+}
+
+func (e *CorruptionError) Is(target error) bool {
+	return target == ErrCorrupt
+}
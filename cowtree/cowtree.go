@@ -0,0 +1,314 @@
+// Package cowtree is a B+ tree stored in a page file that never changes a
+// page it has committed. A write copies the nodes on its path from the leaf
+// up to the root into new pages, and commits by switching the root in one of
+// two meta pages, LMDB / bbolt style. A crash at any point leaves the tree as
+// of the last commit, without a write-ahead log, and every commit is a
+// snapshot that stays readable for as long as someone holds it.
+package cowtree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"storage-engine/common"
+	"storage-engine/pager"
+)
+
+// The first two pages are meta pages, written in turn by successive commits,
+// so the one before the last commit is intact if writing the last one is
+// interrupted. Open picks the valid one with the highest txid.
+//
+//	magic | u64 txid | u64 root page id
+const (
+	metaMagic = "btcowmt2"
+	metaPages = 2
+)
+
+// Options configures a Tree.
+type Options struct {
+	// Pager configures the page file the tree is stored in.
+	Pager pager.Options
+}
+
+// Tree is a copy-on-write B+ tree in a page file. Writes are serialized,
+// reads run alongside them on snapshots. It is safe for concurrent use.
+type Tree struct {
+	pages *pager.Pager
+
+	// writeMu serializes write transactions
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	txid    uint64
+	root    pager.PageID
+	readers map[uint64]int // open snapshots by txid
+	free    []pager.PageID // pages no snapshot can reach
+	pending []freed        // pages freed by commits, in txid order
+	err     error          // set when a commit failed half way
+	closed  bool
+}
+
+// freed holds the pages a commit stopped using. Snapshots taken before it
+// may still read them.
+type freed struct {
+	txid uint64
+	ids  []pager.PageID
+}
+
+// Open opens the tree stored in the page file at path, creating it if needed.
+func Open(path string, opts Options) (*Tree, error) {
+	p, err := pager.Open(path, opts.Pager)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tree{pages: p, readers: make(map[uint64]int)}
+	if err := t.open(); err != nil {
+		_ = p.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Tree) open() error {
+	if t.pages.PageCount() == 0 {
+		return t.create()
+	}
+	if t.pages.PageCount() < metaPages+1 {
+		return common.Corruptf("page file too small for a tree")
+	}
+
+	found := false
+	for id := pager.PageID(1); id <= metaPages; id++ {
+		txid, root, err := t.readMeta(id)
+		if err != nil {
+			// a torn meta page, the other one is the last commit
+			continue
+		}
+		if !found || txid > t.txid {
+			t.txid, t.root, found = txid, root, true
+		}
+	}
+	if !found {
+		return common.Corruptf("no valid meta page")
+	}
+
+	// free pages aren't recorded anywhere, they are the ones the tree doesn't
+	// reach
+	reachable := make(map[pager.PageID]bool)
+	if err := t.walk(t.root, reachable); err != nil {
+		return err
+	}
+	for id := pager.PageID(metaPages + 1); uint64(id) <= t.pages.PageCount(); id++ {
+		if !reachable[id] {
+			t.free = append(t.free, id)
+		}
+	}
+	return nil
+}
+
+// create lays out a new tree: the two meta pages and an empty root leaf.
+func (t *Tree) create() error {
+	for range metaPages + 1 {
+		if _, err := t.pages.Allocate(); err != nil {
+			return err
+		}
+	}
+	t.root = metaPages + 1
+	if err := t.pages.Write(t.root, (&node{leaf: true}).encode()); err != nil {
+		return err
+	}
+	if err := t.pages.Write(1, encodeMeta(0, t.root)); err != nil {
+		return err
+	}
+	return t.pages.Sync()
+}
+
+func encodeMeta(txid uint64, root pager.PageID) []byte {
+	buf := []byte(metaMagic)
+	buf = binary.LittleEndian.AppendUint64(buf, txid)
+	return binary.LittleEndian.AppendUint64(buf, uint64(root))
+}
+
+func (t *Tree) readMeta(id pager.PageID) (uint64, pager.PageID, error) {
+	page, err := t.pages.Read(id)
+	if err != nil {
+		return 0, 0, err
+	}
+	if string(page[:len(metaMagic)]) != metaMagic {
+		return 0, 0, &common.CorruptionError{PageID: uint64(id), Reason: "not a meta page"}
+	}
+	txid := binary.LittleEndian.Uint64(page[len(metaMagic):])
+	root := pager.PageID(binary.LittleEndian.Uint64(page[len(metaMagic)+8:]))
+	if root <= metaPages || uint64(root) > t.pages.PageCount() {
+		return 0, 0, &common.CorruptionError{PageID: uint64(id), Reason: fmt.Sprintf("bad root page %d", root)}
+	}
+	return txid, root, nil
+}
+
+// walk adds the pages of the subtree at id to seen.
+func (t *Tree) walk(id pager.PageID, seen map[pager.PageID]bool) error {
+	if seen[id] || id <= metaPages || uint64(id) > t.pages.PageCount() {
+		return &common.CorruptionError{PageID: uint64(id), Reason: "page reachable twice or out of range"}
+	}
+	seen[id] = true
+
+	n, err := t.readNode(id)
+	if err != nil {
+		return err
+	}
+	for _, c := range n.children {
+		if err := t.walk(c, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readNode decodes the node at id. Its values, and keys that share no
+// prefix, point into the page as the pager hands it out, from the mapping with pager.Options.Mmap. That is
+// safe for as long as the caller can reach the page: committed pages are
+// never written again before every snapshot that reads them is closed.
+func (t *Tree) readNode(id pager.PageID) (*node, error) {
+	page, err := t.pages.View(id)
+	if err != nil {
+		return nil, err
+	}
+	return decodeNode(id, page)
+}
+
+// Insert sets key to value in a transaction of its own.
+func (t *Tree) Insert(key, value []byte) error {
+	return t.Update(func(tx *Tx) error {
+		return tx.Insert(key, value)
+	})
+}
+
+// Delete removes key in a transaction of its own. It returns ErrNotFound if
+// key doesn't exist.
+func (t *Tree) Delete(key []byte) error {
+	return t.Update(func(tx *Tx) error {
+		return tx.Delete(key)
+	})
+}
+
+// Get returns the value of key as of the last commit.
+func (t *Tree) Get(key []byte) ([]byte, error) {
+	s, err := t.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	return s.Get(key)
+}
+
+// Update runs fn in a write transaction and commits it if fn returns nil.
+// Nothing fn did is visible to anyone else before the commit, and none of it
+// is kept if fn returns an error.
+func (t *Tree) Update(fn func(tx *Tx) error) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	t.mu.Lock()
+	if err := t.usable(); err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	tx := &Tx{t: t, root: t.root, txid: t.txid + 1, dirty: make(map[pager.PageID]*node)}
+	t.mu.Unlock()
+
+	if err := fn(tx); err != nil {
+		tx.rollback()
+		return err
+	}
+	return tx.commit()
+}
+
+func (t *Tree) usable() error {
+	if t.closed {
+		return common.ErrClosed
+	}
+	return t.err
+}
+
+// allocate hands out a page no snapshot can reach, growing the file if there
+// is none.
+func (t *Tree) allocate() (pager.PageID, error) {
+	t.mu.Lock()
+	if n := len(t.free); n > 0 {
+		id := t.free[n-1]
+		t.free = t.free[:n-1]
+		t.mu.Unlock()
+		return id, nil
+	}
+	t.mu.Unlock()
+	return t.pages.Allocate()
+}
+
+// release returns pages that were never committed to the free list.
+func (t *Tree) release(ids []pager.PageID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.free = append(t.free, ids...)
+}
+
+// reclaim moves the pages freed by commits no open snapshot predates to the
+// free list. A snapshot of txid r reads pages freed by commits after r.
+func (t *Tree) reclaim() {
+	oldest := t.txid
+	for txid := range t.readers {
+		oldest = min(oldest, txid)
+	}
+
+	n := 0
+	for _, f := range t.pending {
+		if f.txid > oldest {
+			break
+		}
+		t.free = append(t.free, f.ids...)
+		n++
+	}
+	t.pending = t.pending[n:]
+}
+
+// Stats describes how the pages of the tree are used.
+type Stats struct {
+	TxID         uint64 // id of the last commit
+	Pages        uint64 // pages in the file, meta pages included
+	FreePages    int    // pages ready for reuse
+	PendingPages int    // pages freed by commits that open snapshots may read
+	Snapshots    int    // open snapshots
+}
+
+func (t *Tree) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st := Stats{TxID: t.txid, Pages: t.pages.PageCount(), FreePages: len(t.free)}
+	for _, f := range t.pending {
+		st.PendingPages += len(f.ids)
+	}
+	for _, n := range t.readers {
+		st.Snapshots += n
+	}
+	return st
+}
+
+// Close closes the page file. Snapshots must be closed first.
+func (t *Tree) Close() error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return common.ErrClosed
+	}
+	if len(t.readers) > 0 {
+		return errors.New("snapshots still open")
+	}
+	t.closed = true
+	return t.pages.Close()
+}
@@ -0,0 +1,409 @@
+package cowtree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"storage-engine/common"
+	"storage-engine/pager"
+	"storage-engine/vfs"
+)
+
+func key(i int) []byte {
+	return []byte(fmt.Sprintf("key%06d", i))
+}
+
+func value(i int) []byte {
+	return bytes.Repeat([]byte{byte(i)}, 40)
+}
+
+// openTree opens the tree at path, failing the test on error.
+func openTree(t *testing.T, path string) *Tree {
+	tree, err := Open(path, Options{})
+	assert.NoError(t, err)
+	return tree
+}
+
+// checkTree checks the tree holds exactly want, in order.
+func checkTree(t *testing.T, tree *Tree, want map[string][]byte) {
+	s, err := tree.Snapshot()
+	assert.NoError(t, err)
+	defer s.Close()
+
+	var prev []byte
+	n := 0
+	assert.NoError(t, s.Ascend(nil, func(k, v []byte) bool {
+		assert.True(t, prev == nil || bytes.Compare(prev, k) < 0, "keys out of order")
+		assert.Equal(t, want[string(k)], v)
+		prev = bytes.Clone(k)
+		n++
+		return true
+	}))
+	assert.Equal(t, len(want), n)
+}
+
+func TestTree_InsertGetDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	tree := openTree(t, path)
+
+	want := make(map[string][]byte)
+	assert.NoError(t, tree.Update(func(tx *Tx) error {
+		for i := range 2000 {
+			if err := tx.Insert(key(i), value(i)); err != nil {
+				return err
+			}
+			want[string(key(i))] = value(i)
+		}
+		return nil
+	}))
+	checkTree(t, tree, want)
+
+	v, err := tree.Get(key(1234))
+	assert.NoError(t, err)
+	assert.Equal(t, value(1234), v)
+	_, err = tree.Get([]byte("nope"))
+	assert.ErrorIs(t, err, common.ErrNotFound)
+	assert.ErrorIs(t, tree.Delete([]byte("nope")), common.ErrNotFound)
+	assert.ErrorIs(t, tree.Insert(nil, nil), common.ErrEmptyKey)
+	assert.Error(t, tree.Insert([]byte("big"), make([]byte, MaxEntrySize)))
+
+	for i := 0; i < 2000; i += 2 {
+		assert.NoError(t, tree.Delete(key(i)))
+		delete(want, string(key(i)))
+	}
+	checkTree(t, tree, want)
+	assert.NoError(t, tree.Close())
+
+	tree = openTree(t, path)
+	defer tree.Close()
+	checkTree(t, tree, want)
+	assert.Equal(t, uint64(1001), tree.Stats().TxID)
+}
+
+func TestTree_DeleteAll(t *testing.T) {
+	tree := openTree(t, filepath.Join(t.TempDir(), "db"))
+	defer tree.Close()
+
+	assert.NoError(t, tree.Update(func(tx *Tx) error {
+		for i := range 1000 {
+			_ = tx.Insert(key(i), value(i))
+		}
+		return nil
+	}))
+	assert.NoError(t, tree.Update(func(tx *Tx) error {
+		for i := range 1000 {
+			if err := tx.Delete(key(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	checkTree(t, tree, nil)
+
+	// everything but the meta pages and the empty root is free again
+	st := tree.Stats()
+	assert.Equal(t, int(st.Pages)-metaPages-1, st.FreePages)
+}
+
+func TestTree_Rollback(t *testing.T) {
+	tree := openTree(t, filepath.Join(t.TempDir(), "db"))
+	defer tree.Close()
+	assert.NoError(t, tree.Insert(key(1), value(1)))
+
+	boom := errors.New("boom")
+	err := tree.Update(func(tx *Tx) error {
+		for i := range 500 {
+			_ = tx.Insert(key(i), value(i+1))
+		}
+		v, err := tx.Get(key(1))
+		assert.NoError(t, err)
+		assert.Equal(t, value(2), v)
+		return boom
+	})
+	assert.ErrorIs(t, err, boom)
+
+	checkTree(t, tree, map[string][]byte{string(key(1)): value(1)})
+	assert.Equal(t, uint64(1), tree.Stats().TxID)
+}
+
+func TestTree_Snapshots(t *testing.T) {
+	tree := openTree(t, filepath.Join(t.TempDir(), "db"))
+	defer tree.Close()
+
+	assert.NoError(t, tree.Update(func(tx *Tx) error {
+		for i := range 500 {
+			_ = tx.Insert(key(i), value(i))
+		}
+		return nil
+	}))
+
+	s, err := tree.Snapshot()
+	assert.NoError(t, err)
+	for i := range 500 {
+		assert.NoError(t, tree.Insert(key(i), value(i+1)))
+	}
+
+	// the snapshot still sees the old values, and the pages they are on
+	// aren't reused while it is open
+	v, err := s.Get(key(10))
+	assert.NoError(t, err)
+	assert.Equal(t, value(10), v)
+	st := tree.Stats()
+	assert.Equal(t, 0, st.FreePages)
+	assert.Greater(t, st.PendingPages, 0)
+	assert.Equal(t, 1, st.Snapshots)
+	assert.Error(t, tree.Close())
+
+	assert.NoError(t, s.Close())
+	assert.ErrorIs(t, s.Close(), common.ErrClosed)
+	st = tree.Stats()
+	assert.Equal(t, 0, st.PendingPages)
+	assert.Greater(t, st.FreePages, 0)
+
+	// freed pages are reused instead of growing the file
+	pages := st.Pages
+	for i := range 500 {
+		assert.NoError(t, tree.Insert(key(i), value(i+2)))
+	}
+	assert.Equal(t, pages, tree.Stats().Pages)
+}
+
+func TestTree_Ascend(t *testing.T) {
+	tree := openTree(t, filepath.Join(t.TempDir(), "db"))
+	defer tree.Close()
+	assert.NoError(t, tree.Update(func(tx *Tx) error {
+		for i := range 1000 {
+			_ = tx.Insert(key(i*2), value(i))
+		}
+		return nil
+	}))
+
+	s, _ := tree.Snapshot()
+	defer s.Close()
+	var got [][]byte
+	assert.NoError(t, s.Ascend(key(701), func(k, v []byte) bool {
+		got = append(got, bytes.Clone(k))
+		return len(got) < 3
+	}))
+	assert.Equal(t, [][]byte{key(702), key(704), key(706)}, got)
+}
+
+func TestTree_TornMeta(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	tree := openTree(t, path)
+	assert.NoError(t, tree.Insert(key(1), value(1)))
+	assert.NoError(t, tree.Insert(key(2), value(2)))
+	assert.NoError(t, tree.Close())
+
+	// lose the meta page of the last commit, txid 2
+	p, err := pager.Open(path, pager.Options{})
+	assert.NoError(t, err)
+	assert.NoError(t, p.Write(1, []byte("garbage")))
+	assert.NoError(t, p.Close())
+
+	tree = openTree(t, path)
+	defer tree.Close()
+	assert.Equal(t, uint64(1), tree.Stats().TxID)
+	checkTree(t, tree, map[string][]byte{string(key(1)): value(1)})
+
+	// the pages of the lost commit are free, and writing goes on from there
+	assert.NoError(t, tree.Insert(key(3), value(3)))
+	assert.Equal(t, uint64(2), tree.Stats().TxID)
+	checkTree(t, tree, map[string][]byte{string(key(1)): value(1), string(key(3)): value(3)})
+}
+
+func TestTree_Random(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	tree := openTree(t, path)
+	want := make(map[string][]byte)
+	r := rand.New(rand.NewPCG(1, 2))
+
+	for round := range 20 {
+		assert.NoError(t, tree.Update(func(tx *Tx) error {
+			for range 200 {
+				i := r.IntN(3000)
+				if r.IntN(3) == 0 {
+					err := tx.Delete(key(i))
+					_, ok := want[string(key(i))]
+					assert.Equal(t, ok, err == nil)
+					delete(want, string(key(i)))
+					continue
+				}
+				v := bytes.Repeat([]byte{byte(i)}, r.IntN(200))
+				if err := tx.Insert(key(i), v); err != nil {
+					return err
+				}
+				want[string(key(i))] = v
+			}
+			return nil
+		}))
+		if round%5 == 4 {
+			assert.NoError(t, tree.Close())
+			tree = openTree(t, path)
+		}
+		checkTree(t, tree, want)
+	}
+	assert.NoError(t, tree.Close())
+}
+
+func TestTree_SharedPrefixes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	tree := openTree(t, path)
+
+	name := func(i int) []byte {
+		return []byte(fmt.Sprintf("tenant-0042/objects/%08d/name", i))
+	}
+	want := make(map[string][]byte)
+	assert.NoError(t, tree.Update(func(tx *Tx) error {
+		for i := range 1000 {
+			want[string(name(i))] = []byte("v")
+			if err := tx.Insert(name(i), []byte("v")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	assert.NoError(t, tree.Close())
+	tree = openTree(t, path)
+	defer tree.Close()
+	checkTree(t, tree, want)
+
+	root, err := tree.readNode(tree.root)
+	assert.NoError(t, err)
+	assert.False(t, root.leaf)
+	// separators are cut down to what tells the leaves apart
+	for _, sep := range root.keys {
+		assert.Less(t, len(sep), len(name(0)))
+	}
+	// and the leaves hold far more than a page of whole keys
+	leaf, err := tree.readNode(root.children[0])
+	assert.NoError(t, err)
+	assert.Greater(t, len(leaf.keys)*len(name(0)), pager.PayloadSize)
+}
+
+func TestTree_ConcurrentReaders(t *testing.T) {
+	for name, opts := range map[string]Options{
+		"read": {},
+		// nodes point into the mapping, which is redone as the file grows
+		"mmap": {Pager: pager.Options{Mmap: true}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			testConcurrentReaders(t, opts)
+		})
+	}
+}
+
+func testConcurrentReaders(t *testing.T, opts Options) {
+	tree, err := Open(filepath.Join(t.TempDir(), "db"), opts)
+	assert.NoError(t, err)
+	defer tree.Close()
+
+	done := make(chan struct{})
+	errs := make(chan error, 4)
+	for range 4 {
+		go func() {
+			for {
+				select {
+				case <-done:
+					errs <- nil
+					return
+				default:
+				}
+				// every commit sets all keys to the same value
+				s, err := tree.Snapshot()
+				if err != nil {
+					errs <- err
+					return
+				}
+				var first []byte
+				var mixed error
+				err = s.Ascend(nil, func(k, v []byte) bool {
+					if first == nil {
+						first = bytes.Clone(v)
+					}
+					if !bytes.Equal(first, v) {
+						mixed = fmt.Errorf("snapshot %d mixes commits", s.TxID())
+						return false
+					}
+					return true
+				})
+				s.Close()
+				if err = errors.Join(err, mixed); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	for round := range 30 {
+		assert.NoError(t, tree.Update(func(tx *Tx) error {
+			for i := range 300 {
+				_ = tx.Insert(key(i), value(round))
+			}
+			return nil
+		}))
+	}
+	close(done)
+	for range 4 {
+		assert.NoError(t, <-errs)
+	}
+}
+
+func TestTree_CrashDuringCommit(t *testing.T) {
+	const rounds = 4
+	// round r sets 60 keys, overlapping those of the round before
+	apply := func(want map[string][]byte, r int) {
+		for i := r * 30; i < r*30+60; i++ {
+			want[string(key(i))] = value(r)
+		}
+	}
+
+	// crash at every operation until a run commits all rounds. Reopening
+	// finds exactly the rounds whose commit returned.
+	for n := 1; ; n++ {
+		mem := vfs.NewMem()
+		ffs := vfs.NewFaulty(mem)
+		ffs.CrashAt(n)
+
+		committed := 0
+		tree, err := Open("db", Options{Pager: pager.Options{FS: ffs}})
+		if err == nil {
+			for r := range rounds {
+				err := tree.Update(func(tx *Tx) error {
+					for i := r * 30; i < r*30+60; i++ {
+						if err := tx.Insert(key(i), value(r)); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+				if err != nil {
+					break
+				}
+				committed++
+			}
+			_ = tree.Close()
+		}
+		if !ffs.Crashed() {
+			assert.Equal(t, rounds, committed)
+			break
+		}
+
+		want := make(map[string][]byte)
+		for r := range committed {
+			apply(want, r)
+		}
+		tree, err = Open("db", Options{Pager: pager.Options{FS: mem}})
+		assert.NoError(t, err, "crash at op %d", n)
+		checkTree(t, tree, want)
+		assert.Equal(t, uint64(committed), tree.Stats().TxID, "crash at op %d", n)
+		assert.NoError(t, tree.Close())
+	}
+}
@@ -0,0 +1,126 @@
+package cowtree
+
+import (
+	"errors"
+	"slices"
+
+	"storage-engine/common"
+	"storage-engine/layout"
+	"storage-engine/pager"
+)
+
+// Nodes are written in the page layout of package layout, which stores the
+// prefix shared by the keys of a node once. An internal node with count keys
+// has count+1 children, child i holding the keys from key i-1 (inclusive) up
+// to key i.
+
+// MaxEntrySize bounds the size of a key plus its value, so that a node split
+// in two always fits its pages.
+const MaxEntrySize = pager.PayloadSize / 4
+
+// node is the decoded form of a page. Nodes read from committed pages are
+// never changed; a write transaction copies them first.
+type node struct {
+	leaf     bool
+	keys     [][]byte
+	values   [][]byte
+	children []pager.PageID
+}
+
+// size returns the encoded size of n.
+func (n *node) size() int {
+	if n.leaf {
+		return layout.LeafSize(nil, n.keys, n.values)
+	}
+	return layout.InternalSize(n.keys, n.children)
+}
+
+func (n *node) encode() []byte {
+	if n.leaf {
+		return layout.EncodeLeaf(nil, n.keys, n.values)
+	}
+	return layout.EncodeInternal(n.keys, n.children)
+}
+
+// decodeNode reads the node on page id. The values of a leaf point into page,
+// and so do its keys when they share no prefix.
+func decodeNode(id pager.PageID, page []byte) (*node, error) {
+	corrupt := func(err error) error {
+		var ce *common.CorruptionError
+		if errors.As(err, &ce) {
+			return &common.CorruptionError{PageID: uint64(id), Reason: ce.Reason}
+		}
+		return err
+	}
+	if len(page) > 0 && page[0] == layout.Leaf {
+		prefix, suffixes, values, err := layout.DecodeLeaf(page)
+		if err != nil {
+			return nil, corrupt(err)
+		}
+		return &node{leaf: true, keys: layout.Join(prefix, suffixes), values: values}, nil
+	}
+
+	keys, children, err := layout.DecodeInternal[pager.PageID](page)
+	if err != nil {
+		return nil, corrupt(err)
+	}
+	if slices.Contains(children, 0) {
+		return nil, corrupt(common.Corruptf("bad child id"))
+	}
+	return &node{keys: keys, children: children}, nil
+}
+
+// clone returns a copy of n that can be changed without touching n. Keys and
+// values are shared, they are never changed in place.
+func (n *node) clone() *node {
+	return &node{
+		leaf:     n.leaf,
+		keys:     slices.Clone(n.keys),
+		values:   slices.Clone(n.values),
+		children: slices.Clone(n.children),
+	}
+}
+
+// split cuts n in two halves of about the same encoded size, returning the
+// right half and the separator between them, which for leaves is the
+// shortest key that tells them apart. n keeps the left half.
+func (n *node) split() (*node, []byte) {
+	common.Assert(len(n.keys) >= 2 && (n.leaf || len(n.keys) >= 3), "can't split a node of %d keys", len(n.keys))
+
+	entry := func(i int) int {
+		if n.leaf {
+			return len(n.keys[i]) + len(n.values[i])
+		}
+		return len(n.keys[i])
+	}
+	total := 0
+	for i := range n.keys {
+		total += entry(i)
+	}
+	mid, acc := 0, 0
+	for mid < len(n.keys) && acc < total/2 {
+		acc += entry(mid)
+		mid++
+	}
+	if n.leaf {
+		mid = max(1, min(mid, len(n.keys)-1))
+	} else {
+		// the key at mid moves up, so both halves need keys around it
+		mid = max(1, min(mid, len(n.keys)-2))
+	}
+
+	right := &node{leaf: n.leaf}
+	var sep []byte
+	if n.leaf {
+		sep = layout.ShortestSeparator(n.keys[mid-1], n.keys[mid])
+		right.keys = slices.Clone(n.keys[mid:])
+		right.values = slices.Clone(n.values[mid:])
+		n.keys, n.values = n.keys[:mid:mid], n.values[:mid:mid]
+	} else {
+		sep = n.keys[mid]
+		right.keys = slices.Clone(n.keys[mid+1:])
+		right.children = slices.Clone(n.children[mid+1:])
+		n.keys, n.children = n.keys[:mid:mid], n.children[:mid+1:mid+1]
+	}
+	return right, sep
+}
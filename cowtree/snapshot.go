@@ -0,0 +1,107 @@
+package cowtree
+
+import (
+	"bytes"
+
+	"storage-engine/common"
+	"storage-engine/pager"
+)
+
+// Snapshot is a read-only view of the tree as of one commit. The pages it
+// reads are not reused until it is closed, so an open snapshot holds on to
+// the space freed by every commit after it.
+type Snapshot struct {
+	t      *Tree
+	txid   uint64
+	root   pager.PageID
+	closed bool
+}
+
+// Snapshot returns a view of the last commit. It must be closed.
+func (t *Tree) Snapshot() (*Snapshot, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil, common.ErrClosed
+	}
+	t.readers[t.txid]++
+	return &Snapshot{t: t, txid: t.txid, root: t.root}, nil
+}
+
+// TxID returns the id of the commit the snapshot sees.
+func (s *Snapshot) TxID() uint64 {
+	return s.txid
+}
+
+// Get returns the value of key.
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if s.closed {
+		return nil, common.ErrClosed
+	}
+	if len(key) == 0 {
+		return nil, common.ErrEmptyKey
+	}
+	return lookup(key, s.root, s.t.readNode)
+}
+
+// Ascend calls fn for every entry with a key of at least from, in key order,
+// until fn returns false. A nil from starts at the first key. The key and
+// value must not be kept after fn returns.
+func (s *Snapshot) Ascend(from []byte, fn func(key, value []byte) bool) error {
+	if s.closed {
+		return common.ErrClosed
+	}
+	_, err := s.ascend(s.root, from, fn)
+	return err
+}
+
+// ascend visits the subtree at id and reports whether to go on.
+func (s *Snapshot) ascend(id pager.PageID, from []byte, fn func(key, value []byte) bool) (bool, error) {
+	n, err := s.t.readNode(id)
+	if err != nil {
+		return false, err
+	}
+
+	if n.leaf {
+		for i, k := range n.keys {
+			if from != nil && bytes.Compare(k, from) < 0 {
+				continue
+			}
+			if !fn(k, n.values[i]) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+
+	start := 0
+	if from != nil {
+		start = n.childIndex(from)
+	}
+	for _, c := range n.children[start:] {
+		more, err := s.ascend(c, from, fn)
+		if err != nil || !more {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Close releases the snapshot, letting the pages only it could read be
+// reused.
+func (s *Snapshot) Close() error {
+	t := s.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s.closed {
+		return common.ErrClosed
+	}
+	s.closed = true
+	if t.readers[s.txid]--; t.readers[s.txid] == 0 {
+		delete(t.readers, s.txid)
+	}
+	t.reclaim()
+	return nil
+}
@@ -0,0 +1,339 @@
+package cowtree
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+
+	"storage-engine/common"
+	"storage-engine/pager"
+)
+
+// Tx is a write transaction, see Tree.Update. It must not be used after fn
+// returns.
+type Tx struct {
+	t    *Tree
+	root pager.PageID
+	txid uint64
+
+	// nodes written by this transaction, by their new page. They can change
+	// in place until the commit.
+	dirty     map[pager.PageID]*node
+	allocated []pager.PageID // pages taken for dirty nodes
+	freed     []pager.PageID // committed pages this transaction stopped using
+}
+
+// node returns the node at id, as this transaction sees it. It must not be
+// changed unless it is dirty.
+func (tx *Tx) node(id pager.PageID) (*node, error) {
+	if n, ok := tx.dirty[id]; ok {
+		return n, nil
+	}
+	return tx.t.readNode(id)
+}
+
+// writable returns a copy of the node at id that can be changed and the page
+// it goes to. A node already copied by this transaction is changed in place.
+func (tx *Tx) writable(id pager.PageID) (pager.PageID, *node, error) {
+	if n, ok := tx.dirty[id]; ok {
+		return id, n, nil
+	}
+	n, err := tx.t.readNode(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	newID, err := tx.add(n.clone())
+	if err != nil {
+		return 0, nil, err
+	}
+	tx.freed = append(tx.freed, id)
+	return newID, tx.dirty[newID], nil
+}
+
+// add gives n a new page.
+func (tx *Tx) add(n *node) (pager.PageID, error) {
+	id, err := tx.t.allocate()
+	if err != nil {
+		return 0, err
+	}
+	tx.allocated = append(tx.allocated, id)
+	tx.dirty[id] = n
+	return id, nil
+}
+
+// drop forgets the node at id, which the tree no longer points to.
+func (tx *Tx) drop(id pager.PageID) {
+	if _, ok := tx.dirty[id]; ok {
+		// never committed, so nobody else can see it
+		delete(tx.dirty, id)
+		tx.allocated = slices.DeleteFunc(tx.allocated, func(a pager.PageID) bool { return a == id })
+		tx.t.release([]pager.PageID{id})
+		return
+	}
+	tx.freed = append(tx.freed, id)
+}
+
+// Get returns the value of key, with the changes of this transaction.
+func (tx *Tx) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, common.ErrEmptyKey
+	}
+	return lookup(key, tx.root, tx.node)
+}
+
+// lookup finds key in the subtree at root, reading nodes with read.
+func lookup(key []byte, root pager.PageID, read func(pager.PageID) (*node, error)) ([]byte, error) {
+	id := root
+	for {
+		n, err := read(id)
+		if err != nil {
+			return nil, err
+		}
+		if n.leaf {
+			i, found := n.search(key)
+			if !found {
+				return nil, common.ErrNotFound
+			}
+			return bytes.Clone(n.values[i]), nil
+		}
+		id = n.children[n.childIndex(key)]
+	}
+}
+
+// search returns the position of key in leaf n, or where it would go.
+func (n *node) search(key []byte) (int, bool) {
+	return slices.BinarySearchFunc(n.keys, key, bytes.Compare)
+}
+
+// childIndex returns the child of internal node n that holds key.
+func (n *node) childIndex(key []byte) int {
+	i, found := n.search(key)
+	if found {
+		return i + 1
+	}
+	return i
+}
+
+// Insert sets key to value.
+func (tx *Tx) Insert(key, value []byte) error {
+	if len(key) == 0 {
+		return common.ErrEmptyKey
+	}
+	if len(key)+len(value) > MaxEntrySize {
+		return fmt.Errorf("entry of %d bytes, the most is %d", len(key)+len(value), MaxEntrySize)
+	}
+
+	id, right, sep, err := tx.insert(tx.root, bytes.Clone(key), bytes.Clone(value))
+	if err != nil {
+		return err
+	}
+	if right != 0 {
+		id, err = tx.add(&node{keys: [][]byte{sep}, children: []pager.PageID{id, right}})
+		if err != nil {
+			return err
+		}
+	}
+	tx.root = id
+	return nil
+}
+
+// insert adds key to the subtree at id and returns the new page of its root
+// and, if it had to split, the page of the right half and its first key.
+func (tx *Tx) insert(id pager.PageID, key, value []byte) (pager.PageID, pager.PageID, []byte, error) {
+	id, n, err := tx.writable(id)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	if n.leaf {
+		i, found := n.search(key)
+		if found {
+			n.values[i] = value
+		} else {
+			n.keys = slices.Insert(n.keys, i, key)
+			n.values = slices.Insert(n.values, i, value)
+		}
+	} else {
+		i := n.childIndex(key)
+		child, right, sep, err := tx.insert(n.children[i], key, value)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		n.children[i] = child
+		if right != 0 {
+			n.keys = slices.Insert(n.keys, i, sep)
+			n.children = slices.Insert(n.children, i+1, right)
+		}
+	}
+
+	if n.size() <= pager.PayloadSize {
+		return id, 0, nil, nil
+	}
+	r, sep := n.split()
+	rid, err := tx.add(r)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return id, rid, sep, nil
+}
+
+// Delete removes key. It returns ErrNotFound if key doesn't exist.
+func (tx *Tx) Delete(key []byte) error {
+	if len(key) == 0 {
+		return common.ErrEmptyKey
+	}
+
+	id, found, err := tx.delete(tx.root, key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return common.ErrNotFound
+	}
+
+	// an internal root left with a single child is replaced by it
+	for {
+		n, err := tx.node(id)
+		if err != nil {
+			return err
+		}
+		if n.leaf || len(n.children) > 1 {
+			break
+		}
+		tx.drop(id)
+		id = n.children[0]
+	}
+	tx.root = id
+	return nil
+}
+
+// delete removes key from the subtree at id and returns the new page of its
+// root. Nothing is copied if key isn't there.
+func (tx *Tx) delete(id pager.PageID, key []byte) (pager.PageID, bool, error) {
+	n, err := tx.node(id)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if n.leaf {
+		i, found := n.search(key)
+		if !found {
+			return id, false, nil
+		}
+		id, n, err = tx.writable(id)
+		if err != nil {
+			return 0, false, err
+		}
+		n.keys = slices.Delete(n.keys, i, i+1)
+		n.values = slices.Delete(n.values, i, i+1)
+		return id, true, nil
+	}
+
+	i := n.childIndex(key)
+	child, found, err := tx.delete(n.children[i], key)
+	if err != nil || !found {
+		return id, found, err
+	}
+	id, n, err = tx.writable(id)
+	if err != nil {
+		return 0, false, err
+	}
+	n.children[i] = child
+	return id, true, tx.rebalance(n, i)
+}
+
+// rebalance merges child i of n with a neighbour once it is less than a
+// quarter full, if the two fit a page together. Nodes are not kept at any
+// minimum size otherwise.
+func (tx *Tx) rebalance(n *node, i int) error {
+	child, err := tx.node(n.children[i])
+	if err != nil {
+		return err
+	}
+	if child.size() >= pager.PayloadSize/4 || len(n.children) < 2 {
+		return nil
+	}
+
+	left := max(i-1, 0)
+	l, err := tx.node(n.children[left])
+	if err != nil {
+		return err
+	}
+	r, err := tx.node(n.children[left+1])
+	if err != nil {
+		return err
+	}
+
+	merged := &node{leaf: l.leaf}
+	merged.keys = append(slices.Clone(l.keys), r.keys...)
+	if l.leaf {
+		merged.values = append(slices.Clone(l.values), r.values...)
+	} else {
+		merged.keys = slices.Insert(merged.keys, len(l.keys), n.keys[left])
+		merged.children = append(slices.Clone(l.children), r.children...)
+	}
+	if merged.size() > pager.PayloadSize {
+		return nil
+	}
+
+	lid, ln, err := tx.writable(n.children[left])
+	if err != nil {
+		return err
+	}
+	*ln = *merged
+	tx.drop(n.children[left+1])
+	n.children[left] = lid
+	n.keys = slices.Delete(n.keys, left, left+1)
+	n.children = slices.Delete(n.children, left+1, left+2)
+	return nil
+}
+
+// commit writes the dirty nodes to their pages, then switches the root in the
+// meta page the last commit didn't use.
+func (tx *Tx) commit() error {
+	t := tx.t
+	if tx.root == t.root && len(tx.dirty) == 0 {
+		return nil
+	}
+
+	for id, n := range tx.dirty {
+		if err := t.pages.Write(id, n.encode()); err != nil {
+			return tx.fail(err)
+		}
+	}
+	// the nodes have to be durable before a meta page points at them
+	if err := t.pages.Sync(); err != nil {
+		return tx.fail(err)
+	}
+	meta := pager.PageID(tx.txid%metaPages + 1)
+	if err := t.pages.Write(meta, encodeMeta(tx.txid, tx.root)); err != nil {
+		return tx.fail(err)
+	}
+	if err := t.pages.Sync(); err != nil {
+		return tx.fail(err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.txid, t.root = tx.txid, tx.root
+	if len(tx.freed) > 0 {
+		t.pending = append(t.pending, freed{txid: tx.txid, ids: tx.freed})
+	}
+	t.reclaim()
+	return nil
+}
+
+// fail gives up on a commit that may have been written in part. The tree
+// can't tell which meta page is current any more, so it refuses further
+// writes; reopening it picks up whichever commit made it to disk.
+func (tx *Tx) fail(err error) error {
+	tx.t.mu.Lock()
+	defer tx.t.mu.Unlock()
+	tx.t.err = err
+	return err
+}
+
+// rollback returns the pages taken by the transaction.
+func (tx *Tx) rollback() {
+	tx.t.release(tx.allocated)
+}
@@ -0,0 +1,154 @@
+// Package keyenc provides order-preserving encodings for fixed-width numeric
+// keys: bytes.Compare on two encoded values gives the same result as
+// comparing the original numbers.
+package keyenc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const signBit = 1 << 63
+
+// EncodeInt64 encodes v as 8 bytes big-endian with the sign bit flipped, so
+// negative numbers sort before positive ones.
+func EncodeInt64(v int64) []byte {
+	return AppendInt64(nil, v)
+}
+
+func AppendInt64(dst []byte, v int64) []byte {
+	return binary.BigEndian.AppendUint64(dst, uint64(v)^signBit)
+}
+
+func DecodeInt64(b []byte) (int64, error) {
+	u, err := decode8(b, "int64")
+	if err != nil {
+		return 0, err
+	}
+	return int64(u ^ signBit), nil
+}
+
+// EncodeUint64 encodes v as 8 bytes big-endian.
+func EncodeUint64(v uint64) []byte {
+	return AppendUint64(nil, v)
+}
+
+func AppendUint64(dst []byte, v uint64) []byte {
+	return binary.BigEndian.AppendUint64(dst, v)
+}
+
+func DecodeUint64(b []byte) (uint64, error) {
+	return decode8(b, "uint64")
+}
+
+// EncodeFloat64 encodes v so that encoded values follow the IEEE-754
+// totalOrder predicate: -NaN < -Inf < ... < -0 < +0 < ... < +Inf < +NaN.
+// Positive floats get their sign bit set, negative floats have every bit
+// inverted.
+func EncodeFloat64(v float64) []byte {
+	return AppendFloat64(nil, v)
+}
+
+func AppendFloat64(dst []byte, v float64) []byte {
+	bits := math.Float64bits(v)
+	if bits&signBit != 0 {
+		bits = ^bits
+	} else {
+		bits |= signBit
+	}
+	return binary.BigEndian.AppendUint64(dst, bits)
+}
+
+func DecodeFloat64(b []byte) (float64, error) {
+	bits, err := decode8(b, "float64")
+	if err != nil {
+		return 0, err
+	}
+	if bits&signBit != 0 {
+		bits &^= signBit
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits), nil
+}
+
+// Descending variants: the ascending encoding with every bit inverted, so
+// larger values sort first.
+
+func EncodeInt64Desc(v int64) []byte {
+	return Invert(EncodeInt64(v))
+}
+
+func DecodeInt64Desc(b []byte) (int64, error) {
+	return DecodeInt64(Invert(clone(b)))
+}
+
+func EncodeUint64Desc(v uint64) []byte {
+	return Invert(EncodeUint64(v))
+}
+
+func DecodeUint64Desc(b []byte) (uint64, error) {
+	return DecodeUint64(Invert(clone(b)))
+}
+
+func EncodeFloat64Desc(v float64) []byte {
+	return Invert(EncodeFloat64(v))
+}
+
+func DecodeFloat64Desc(b []byte) (float64, error) {
+	return DecodeFloat64(Invert(clone(b)))
+}
+
+// Invert flips every bit of b in place and returns it. Applied to any
+// fixed-width ascending encoding it produces the descending one (and back).
+// It does not work for variable-length encodings, where a shorter key is a
+// prefix of a longer one.
+func Invert(b []byte) []byte {
+	for i := range b {
+		b[i] = ^b[i]
+	}
+	return b
+}
+
+// PrefixSuccessor returns the smallest key greater than every key starting
+// with prefix, or nil if there is none.
+func PrefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := clone(prefix[:i+1])
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
+
+// EncodeLegacyInt is the encoding used by BTree.InsertInt before signed keys
+// were handled: a plain uint64 cast, which sorts negative numbers after all
+// positive ones. Only kept to read and migrate trees built with it.
+func EncodeLegacyInt(v int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(v))
+}
+
+// DecodeLegacyInt reverses EncodeLegacyInt. Shorter slices are left-padded
+// with zeros, as the old decoder did.
+func DecodeLegacyInt(b []byte) (int, error) {
+	if len(b) > 8 {
+		return 0, fmt.Errorf("legacy int: expected at most 8 bytes, got %d", len(b))
+	}
+	var tmp [8]byte
+	copy(tmp[8-len(b):], b)
+	return int(binary.BigEndian.Uint64(tmp[:])), nil
+}
+
+func decode8(b []byte, typ string) (uint64, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("%s: expected 8 bytes, got %d", typ, len(b))
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func clone(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
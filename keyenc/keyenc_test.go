@@ -0,0 +1,116 @@
+package keyenc
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInt64_Order(t *testing.T) {
+	vals := []int64{math.MinInt64, -1 << 40, -256, -1, 0, 1, 255, 1 << 40, math.MaxInt64}
+
+	for i := 1; i < len(vals); i++ {
+		a, b := EncodeInt64(vals[i-1]), EncodeInt64(vals[i])
+		assert.Equal(t, -1, bytes.Compare(a, b), "%d should sort before %d", vals[i-1], vals[i])
+
+		ad, bd := EncodeInt64Desc(vals[i-1]), EncodeInt64Desc(vals[i])
+		assert.Equal(t, 1, bytes.Compare(ad, bd), "%d should sort after %d descending", vals[i-1], vals[i])
+	}
+
+	for _, v := range vals {
+		got, err := DecodeInt64(EncodeInt64(v))
+		assert.NoError(t, err)
+		assert.Equal(t, v, got)
+
+		got, err = DecodeInt64Desc(EncodeInt64Desc(v))
+		assert.NoError(t, err)
+		assert.Equal(t, v, got)
+	}
+}
+
+func TestUint64_Order(t *testing.T) {
+	vals := []uint64{0, 1, 255, 256, 1 << 63, math.MaxUint64}
+
+	for i := 1; i < len(vals); i++ {
+		assert.Equal(t, -1, bytes.Compare(EncodeUint64(vals[i-1]), EncodeUint64(vals[i])))
+		assert.Equal(t, 1, bytes.Compare(EncodeUint64Desc(vals[i-1]), EncodeUint64Desc(vals[i])))
+	}
+
+	for _, v := range vals {
+		got, err := DecodeUint64Desc(EncodeUint64Desc(v))
+		assert.NoError(t, err)
+		assert.Equal(t, v, got)
+	}
+}
+
+func TestFloat64_TotalOrder(t *testing.T) {
+	negNaN := math.Float64frombits(math.Float64bits(math.NaN()) | 1<<63)
+	vals := []float64{
+		negNaN,
+		math.Inf(-1),
+		-math.MaxFloat64,
+		-1.5,
+		-math.SmallestNonzeroFloat64,
+		math.Copysign(0, -1),
+		0,
+		math.SmallestNonzeroFloat64,
+		1.5,
+		math.MaxFloat64,
+		math.Inf(1),
+		math.NaN(),
+	}
+
+	encoded := make([][]byte, len(vals))
+	for i, v := range vals {
+		encoded[i] = EncodeFloat64(v)
+	}
+	assert.True(t, sort.SliceIsSorted(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	}))
+
+	for i := 1; i < len(vals); i++ {
+		assert.Equal(t, 1, bytes.Compare(EncodeFloat64Desc(vals[i-1]), EncodeFloat64Desc(vals[i])))
+	}
+
+	for _, v := range vals {
+		got, err := DecodeFloat64(EncodeFloat64(v))
+		assert.NoError(t, err)
+		assert.Equal(t, math.Float64bits(v), math.Float64bits(got))
+
+		got, err = DecodeFloat64Desc(EncodeFloat64Desc(v))
+		assert.NoError(t, err)
+		assert.Equal(t, math.Float64bits(v), math.Float64bits(got))
+	}
+}
+
+func TestDecode_BadLength(t *testing.T) {
+	_, err := DecodeInt64([]byte{1, 2, 3})
+	assert.Error(t, err)
+
+	_, err = DecodeFloat64(nil)
+	assert.Error(t, err)
+
+	_, err = DecodeLegacyInt(make([]byte, 9))
+	assert.Error(t, err)
+}
+
+func TestLegacyInt(t *testing.T) {
+	for _, v := range []int{-5, 0, 7, math.MaxInt64} {
+		got, err := DecodeLegacyInt(EncodeLegacyInt(v))
+		assert.NoError(t, err)
+		assert.Equal(t, v, got)
+	}
+
+	// the reason this encoding was replaced
+	assert.Equal(t, 1, bytes.Compare(EncodeLegacyInt(-1), EncodeLegacyInt(1)))
+}
+
+func TestPrefixSuccessor(t *testing.T) {
+	assert.Equal(t, []byte("ab"), PrefixSuccessor([]byte("aa")))
+	assert.Equal(t, []byte{'b'}, PrefixSuccessor([]byte{'a', 0xff, 0xff}))
+	assert.Nil(t, PrefixSuccessor([]byte{0xff}))
+	assert.Nil(t, PrefixSuccessor(nil))
+}
@@ -0,0 +1,263 @@
+// Package layout is the page format of B+ tree nodes, shared by the trees
+// that put their nodes on pages.
+//
+// Keys in a node tend to share long prefixes (tenant ids, paths), so nodes
+// don't store them in full:
+//
+//   - separators in internal nodes are cut down to the shortest key that
+//     still tells the two children apart (suffix truncation, see
+//     ShortestSeparator), which keeps internal nodes small.
+//   - the prefix shared by every key of a node is written once and only the
+//     remaining suffixes are written per key (prefix compression).
+//
+// Page layout of a node:
+//
+//	kind (1 byte) | uvarint count | uvarint len | prefix |
+//	leaf:     (uvarint len | suffix | uvarint len | value) x count
+//	internal: (uvarint len | suffix) x count | (uvarint child id) x count+1
+package layout
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"storage-engine/common"
+)
+
+// The first byte of a node page.
+const (
+	Leaf     byte = 0
+	Internal byte = 1
+)
+
+// ShortestSeparator returns the shortest s with a < s <= b. If a == b, which
+// happens when a run of duplicate keys spans two leaves, it returns b.
+func ShortestSeparator(a, b []byte) []byte {
+	common.Assert(bytes.Compare(a, b) <= 0, "separator asked for keys out of order")
+
+	n := CommonPrefixLen(a, b)
+	if n >= len(b)-1 {
+		return b
+	}
+	// copy, so the separator doesn't keep the whole of b alive
+	return bytes.Clone(b[:n+1])
+}
+
+// CommonPrefixLen returns the length of the longest prefix of a and b.
+func CommonPrefixLen(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := range n {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// Prefix returns the prefix shared by every key in keys, which must be
+// sorted: the one shared by the first and last key.
+func Prefix(keys [][]byte) []byte {
+	if len(keys) == 0 {
+		return nil
+	}
+	first, last := keys[0], keys[len(keys)-1]
+	return first[:CommonPrefixLen(first, last)]
+}
+
+// LeafSize returns the size of the page EncodeLeaf writes for the same
+// arguments.
+func LeafSize(prefix []byte, suffixes, values [][]byte) int {
+	more := len(Prefix(suffixes))
+	size := keysSize(len(prefix)+more, suffixes, more)
+	for _, v := range values {
+		size += chunkSize(len(v))
+	}
+	return size
+}
+
+// EncodeLeaf writes a leaf whose keys are prefix followed by each of
+// suffixes. The suffixes may share more than prefix, that is taken out too.
+func EncodeLeaf(prefix []byte, suffixes, values [][]byte) []byte {
+	common.Assert(len(values) == len(suffixes),
+		"leaf has %d values for %d keys", len(values), len(suffixes))
+
+	more := Prefix(suffixes)
+	full := append(prefix[:len(prefix):len(prefix)], more...)
+	buf := make([]byte, 0, LeafSize(prefix, suffixes, values))
+	return appendKeys(append(buf, Leaf), full, suffixes, len(more), func(buf []byte, i int) []byte {
+		buf = binary.AppendUvarint(buf, uint64(len(values[i])))
+		return append(buf, values[i]...)
+	})
+}
+
+// DecodeLeaf reads a leaf written by EncodeLeaf. It returns the prefix
+// shared by the keys and what follows it in each of them; all of them point
+// into page.
+func DecodeLeaf(page []byte) (prefix []byte, suffixes, values [][]byte, err error) {
+	suffixes, values = make([][]byte, 0), make([][]byte, 0)
+	rest, err := readKeys(page, Leaf, func(rest, p, suffix []byte) ([]byte, error) {
+		value, rest, err := readChunk(rest)
+		if err != nil {
+			return nil, err
+		}
+		prefix = p
+		suffixes = append(suffixes, suffix)
+		values = append(values, value)
+		return rest, nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(rest) != 0 {
+		return nil, nil, nil, common.Corruptf("%d trailing bytes in node", len(rest))
+	}
+	return prefix, suffixes, values, nil
+}
+
+// InternalSize returns the size of the page EncodeInternal writes for the
+// same arguments.
+func InternalSize[ID ~uint64](keys [][]byte, children []ID) int {
+	prefix := len(Prefix(keys))
+	size := keysSize(prefix, keys, prefix)
+	for _, id := range children {
+		size += uvarintLen(uint64(id))
+	}
+	return size
+}
+
+// EncodeInternal writes an internal node with the given keys and the page
+// ids of its children.
+func EncodeInternal[ID ~uint64](keys [][]byte, children []ID) []byte {
+	common.Assert(len(children) == len(keys)+1,
+		"internal node has %d children but %d keys", len(children), len(keys))
+
+	prefix := Prefix(keys)
+	buf := make([]byte, 0, InternalSize(keys, children))
+	buf = appendKeys(append(buf, Internal), prefix, keys, len(prefix), nil)
+	for _, id := range children {
+		buf = binary.AppendUvarint(buf, uint64(id))
+	}
+	return buf
+}
+
+// DecodeInternal reads an internal node written by EncodeInternal. The keys
+// are put back together in memory of their own.
+func DecodeInternal[ID ~uint64](page []byte) ([][]byte, []ID, error) {
+	var prefix []byte
+	suffixes := make([][]byte, 0)
+	rest, err := readKeys(page, Internal, func(rest, p, suffix []byte) ([]byte, error) {
+		prefix = p
+		suffixes = append(suffixes, suffix)
+		return rest, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	children := make([]ID, 0, len(suffixes)+1)
+	for range len(suffixes) + 1 {
+		id, n := binary.Uvarint(rest)
+		if n <= 0 {
+			return nil, nil, common.Corruptf("truncated child id")
+		}
+		children = append(children, ID(id))
+		rest = rest[n:]
+	}
+	if len(rest) != 0 {
+		return nil, nil, common.Corruptf("%d trailing bytes in node", len(rest))
+	}
+	return Join(prefix, suffixes), children, nil
+}
+
+// Join returns prefix followed by each of suffixes, all in one allocation.
+// With no prefix it returns suffixes.
+func Join(prefix []byte, suffixes [][]byte) [][]byte {
+	if len(prefix) == 0 {
+		return suffixes
+	}
+	size := 0
+	for _, s := range suffixes {
+		size += len(prefix) + len(s)
+	}
+	buf := make([]byte, 0, size)
+	keys := make([][]byte, 0, len(suffixes))
+	for _, s := range suffixes {
+		start := len(buf)
+		buf = append(append(buf, prefix...), s...)
+		keys = append(keys, buf[start:len(buf):len(buf)])
+	}
+	return keys
+}
+
+// appendKeys writes the key count, the prefix and the suffix of every key,
+// which is what follows the first cut bytes of it, calling after, if set,
+// once each key is written.
+func appendKeys(buf, prefix []byte, keys [][]byte, cut int, after func(buf []byte, i int) []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(keys)))
+	buf = binary.AppendUvarint(buf, uint64(len(prefix)))
+	buf = append(buf, prefix...)
+	for i, k := range keys {
+		buf = binary.AppendUvarint(buf, uint64(len(k)-cut))
+		buf = append(buf, k[cut:]...)
+		if after != nil {
+			buf = after(buf, i)
+		}
+	}
+	return buf
+}
+
+// keysSize returns the size of what appendKeys writes for the same
+// arguments, with the kind byte before it.
+func keysSize(prefixLen int, keys [][]byte, cut int) int {
+	size := 1 + uvarintLen(uint64(len(keys))) + chunkSize(prefixLen)
+	for _, k := range keys {
+		size += chunkSize(len(k) - cut)
+	}
+	return size
+}
+
+// readKeys reads what appendKeys wrote, checking the node kind, and calls
+// each with the prefix, every suffix and the bytes that follow it.
+func readKeys(page []byte, kind byte, each func(rest, prefix, suffix []byte) ([]byte, error)) ([]byte, error) {
+	if len(page) == 0 || page[0] != kind {
+		return nil, common.Corruptf("unexpected node kind")
+	}
+
+	count, n := binary.Uvarint(page[1:])
+	if n <= 0 {
+		return nil, common.Corruptf("truncated key count")
+	}
+	rest := page[1+n:]
+
+	prefix, rest, err := readChunk(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	for range count {
+		var suffix []byte
+		if suffix, rest, err = readChunk(rest); err != nil {
+			return nil, err
+		}
+		if rest, err = each(rest, prefix, suffix); err != nil {
+			return nil, err
+		}
+	}
+	return rest, nil
+}
+
+func readChunk(b []byte) ([]byte, []byte, error) {
+	l, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < l {
+		return nil, nil, common.Corruptf("truncated chunk")
+	}
+	return b[n : n+int(l)], b[n+int(l):], nil
+}
+
+func chunkSize(l int) int {
+	return uvarintLen(uint64(l)) + l
+}
+
+func uvarintLen(v uint64) int {
+	return len(binary.AppendUvarint(nil, v))
+}
@@ -0,0 +1,86 @@
+package layout
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"storage-engine/common"
+)
+
+func TestShortestSeparator(t *testing.T) {
+	cases := []struct {
+		a, b, want string
+	}{
+		{"tenant-1/users/alice", "tenant-1/users/bob", "tenant-1/users/b"},
+		{"abc", "abd", "abd"},
+		{"ab", "abzzz", "abz"},
+		{"a", "b", "b"},
+		{"", "b", "b"},
+		{"same", "same", "same"},
+		{"apple", "banana", "b"},
+	}
+	for _, c := range cases {
+		s := ShortestSeparator([]byte(c.a), []byte(c.b))
+		assert.Equal(t, c.want, string(s), "%q %q", c.a, c.b)
+		if c.a != c.b {
+			assert.True(t, bytes.Compare([]byte(c.a), s) < 0)
+		}
+		assert.True(t, bytes.Compare(s, []byte(c.b)) <= 0)
+	}
+}
+
+func TestLeaf(t *testing.T) {
+	var keys, values [][]byte
+	for i := range 10 {
+		keys = append(keys, []byte(fmt.Sprintf("/home/user/documents/%02d.txt", i)))
+		values = append(values, []byte(fmt.Sprint(i)))
+	}
+
+	// part of the shared prefix comes apart from the suffixes, as a leaf
+	// keeps it in memory
+	suffixes := make([][]byte, 0, len(keys))
+	for _, k := range keys {
+		suffixes = append(suffixes, k[5:])
+	}
+	page := EncodeLeaf(keys[0][:5], suffixes, values)
+	assert.Equal(t, LeafSize(keys[0][:5], suffixes, values), len(page))
+	full := 0
+	for i := range keys {
+		full += len(keys[i]) + len(values[i])
+	}
+	assert.Less(t, len(page), full/2)
+
+	prefix, gotSuffixes, gotValues, err := DecodeLeaf(page)
+	assert.NoError(t, err)
+	assert.Equal(t, "/home/user/documents/0", string(prefix))
+	assert.Equal(t, keys, Join(prefix, gotSuffixes))
+	assert.Equal(t, values, gotValues)
+
+	_, empty, _, err := DecodeLeaf(EncodeLeaf(nil, nil, nil))
+	assert.NoError(t, err)
+	assert.Empty(t, empty)
+
+	_, _, _, err = DecodeLeaf(page[:len(page)-1])
+	assert.ErrorIs(t, err, common.ErrCorrupt)
+	_, _, err = DecodeInternal[uint64](page)
+	assert.ErrorIs(t, err, common.ErrCorrupt)
+}
+
+func TestInternal(t *testing.T) {
+	keys := [][]byte{[]byte("tenant-7/b"), []byte("tenant-7/k"), []byte("tenant-7/q")}
+	children := []uint64{3, 1 << 40, 9, 12}
+
+	page := EncodeInternal(keys, children)
+	assert.Equal(t, InternalSize(keys, children), len(page))
+
+	gotKeys, gotChildren, err := DecodeInternal[uint64](page)
+	assert.NoError(t, err)
+	assert.Equal(t, keys, gotKeys)
+	assert.Equal(t, children, gotChildren)
+
+	_, _, _, err = DecodeLeaf(page)
+	assert.ErrorIs(t, err, common.ErrCorrupt)
+}
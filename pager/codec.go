@@ -0,0 +1,87 @@
+package pager
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+
+	"storage-engine/common"
+)
+
+// Codec compresses page payloads. Its ID is stored in the header of every
+// page it compressed, so a pager can read pages written with any registered
+// codec no matter which one it writes with.
+type Codec interface {
+	// ID identifies the codec on disk. 0 means uncompressed and can't be used.
+	ID() byte
+	Name() string
+	// Compress appends the compressed form of src to dst.
+	Compress(dst, src []byte) ([]byte, error)
+	// Decompress appends the decompressed form of src to dst.
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = make(map[byte]Codec)
+)
+
+// RegisterCodec makes c available for reading pages compressed with it.
+// Registering the same id twice panics.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	_, dup := codecs[c.ID()]
+	common.Assert(c.ID() != 0 && !dup, "codec id %d reserved or registered twice", c.ID())
+	codecs[c.ID()] = c
+}
+
+func LookupCodec(id byte) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	c, ok := codecs[id]
+	return c, ok
+}
+
+func init() {
+	RegisterCodec(FlateCodec{Level: flate.DefaultCompression})
+}
+
+// FlateCodec compresses with DEFLATE at Level. The level only matters when
+// compressing, so any FlateCodec reads what another wrote.
+type FlateCodec struct {
+	Level int
+}
+
+func (FlateCodec) ID() byte     { return 1 }
+func (FlateCodec) Name() string { return "flate" }
+
+func (c FlateCodec) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w, err := flate.NewWriter(buf, c.Level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (FlateCodec) Decompress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	// a page never decompresses to more than PageSize, anything longer is
+	// corrupt
+	if _, err := io.Copy(buf, io.LimitReader(r, PageSize+1)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
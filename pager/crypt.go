@@ -0,0 +1,198 @@
+package pager
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"storage-engine/common"
+)
+
+// sealOverhead is the size of the GCM tag added to every encrypted body.
+const sealOverhead = 16
+
+// SaltSize is the size of the salt an Encryptor derives its keys with.
+const SaltSize = 16
+
+// KeyProvider supplies AES keys (16, 24 or 32 bytes) by id. Id 0 means "not
+// encrypted" and is never asked for.
+type KeyProvider interface {
+	// CurrentKey returns the key new pages and records are encrypted with.
+	CurrentKey() (id uint32, key []byte, err error)
+	// Key returns the key with the given id, for data encrypted before a
+	// rotation.
+	Key(id uint32) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider holding its keys in memory.
+type StaticKeys struct {
+	mu      sync.RWMutex
+	current uint32
+	keys    map[uint32][]byte
+}
+
+// NewStaticKeys returns a provider whose current key is key, with id id.
+func NewStaticKeys(id uint32, key []byte) *StaticKeys {
+	k := &StaticKeys{keys: make(map[uint32][]byte)}
+	_ = k.Rotate(id, key) // can't clash in an empty provider
+	return k
+}
+
+// Rotate adds key under id and makes it the current key. Older keys stay
+// available for reading. An id stands for the same key for good, since pages
+// and records name their key by id only: Rotate fails if id already has a
+// different key.
+func (k *StaticKeys) Rotate(id uint32, key []byte) error {
+	common.Assert(id != 0, "key id 0 is reserved")
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if old, ok := k.keys[id]; ok && !bytes.Equal(old, key) {
+		return fmt.Errorf("key id %d is already taken by a different key", id)
+	}
+	k.keys[id] = bytes.Clone(key)
+	k.current = id
+	return nil
+}
+
+func (k *StaticKeys) CurrentKey() (uint32, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current, k.keys[k.current], nil
+}
+
+func (k *StaticKeys) Key(id uint32) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("no key with id %d", id)
+	}
+	return key, nil
+}
+
+// Encryptor encrypts pages and log records with AES-GCM under the keys of a
+// KeyProvider. It is safe for concurrent use.
+//
+// A GCM nonce must never repeat under one key. Page nonces are the page id
+// and the lsn of the write, record nonces the lsn of the record with a page
+// id of 0, which no data page has. So lsns must be unique among page writes,
+// which the pager guarantees, and among log records, which is up to the log.
+// They are only unique within a file though: another file, or the same one
+// deleted and created again, starts over. So the cipher doesn't use the keys
+// of the provider as they are, but keys derived from them with HKDF-SHA256
+// and a random salt stored with each file, making them different for every
+// file.
+type Encryptor struct {
+	keys KeyProvider
+	salt []byte
+
+	mu    sync.Mutex
+	aeads map[uint32]cipher.AEAD
+}
+
+// NewEncryptor returns an Encryptor for a file whose salt is salt, SaltSize
+// random bytes picked when the file was created.
+func NewEncryptor(keys KeyProvider, salt []byte) *Encryptor {
+	common.Assert(len(salt) == SaltSize, "salt of %d bytes", len(salt))
+	return &Encryptor{keys: keys, salt: bytes.Clone(salt), aeads: make(map[uint32]cipher.AEAD)}
+}
+
+// current returns the current key id and its cipher.
+func (e *Encryptor) current() (uint32, cipher.AEAD, error) {
+	id, key, err := e.keys.CurrentKey()
+	if err != nil {
+		return 0, nil, err
+	}
+	if id == 0 {
+		return 0, nil, fmt.Errorf("key id 0 is reserved")
+	}
+	aead, err := e.aead(id, key)
+	return id, aead, err
+}
+
+// aead returns the cipher for key id, fetching the key if key is nil.
+func (e *Encryptor) aead(id uint32, key []byte) (cipher.AEAD, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if aead, ok := e.aeads[id]; ok {
+		return aead, nil
+	}
+	if key == nil {
+		var err error
+		if key, err = e.keys.Key(id); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return nil, fmt.Errorf("key %d: %w", id, err)
+	}
+	fileKey, err := hkdf.Key(sha256.New, key, e.salt, "storage-engine pager", len(key))
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	e.aeads[id] = aead
+	return aead, nil
+}
+
+// maxEncryptedPage is the last page id an encrypted file can have, the most
+// that fits the nonce.
+const maxEncryptedPage PageID = 1<<32 - 1
+
+// nonce returns the nonce of the page id written at lsn:
+//
+//	u32 page id | u64 lsn
+//
+// It is unique within a file only; the key is what differs between files.
+// Pages past maxEncryptedPage are refused before they get here.
+func nonce(id PageID, lsn uint64) []byte {
+	common.Assert(id < 1<<32, "page id %d too large for a nonce", id)
+
+	n := make([]byte, 12)
+	binary.LittleEndian.PutUint32(n, uint32(id))
+	binary.LittleEndian.PutUint64(n[4:], lsn)
+	return n
+}
+
+// SealRecord encrypts a log record written at lsn. The result carries the key
+// id, so OpenRecord works after the key is rotated.
+func (e *Encryptor) SealRecord(lsn uint64, record []byte) ([]byte, error) {
+	id, aead, err := e.current()
+	if err != nil {
+		return nil, err
+	}
+	sealed := binary.LittleEndian.AppendUint32(nil, id)
+	return aead.Seal(sealed, nonce(0, lsn), record, sealed[:4]), nil
+}
+
+// OpenRecord decrypts a record sealed by SealRecord at lsn. A record that was
+// changed, or is opened with another lsn, gives a *common.CorruptionError.
+func (e *Encryptor) OpenRecord(lsn uint64, sealed []byte) ([]byte, error) {
+	if len(sealed) < 4+sealOverhead {
+		return nil, common.Corruptf("short log record")
+	}
+	aead, err := e.aead(binary.LittleEndian.Uint32(sealed), nil)
+	if err != nil {
+		return nil, err
+	}
+	record, err := aead.Open(nil, nonce(0, lsn), sealed[4:], sealed[:4])
+	if err != nil {
+		return nil, common.Corruptf("log record at lsn %d: %v", lsn, err)
+	}
+	return record, nil
+}
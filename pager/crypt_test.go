@@ -0,0 +1,253 @@
+package pager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"storage-engine/common"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestPager_Encryption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	keys := NewStaticKeys(1, testKey(1))
+	p, err := Open(path, Options{Keys: keys, DoubleWrite: true})
+	assert.NoError(t, err)
+	for range 3 {
+		id, _ := p.Allocate()
+		assert.NoError(t, p.Write(id, []byte("top secret")))
+	}
+	assert.NoError(t, p.Close())
+
+	raw, _ := os.ReadFile(path)
+	assert.False(t, bytes.Contains(raw, []byte("top secret")))
+
+	p, err = Open(path, Options{Keys: keys})
+	assert.NoError(t, err)
+	data, err := p.Read(2)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("top secret"), data[:10])
+	assert.Equal(t, map[uint32]int{1: 3}, p.Stats().Keys)
+	assert.NoError(t, p.Close())
+
+	p, err = Open(path, Options{})
+	assert.NoError(t, err)
+	defer p.Close()
+	_, err = p.Read(2)
+	assert.ErrorContains(t, err, "encrypted")
+}
+
+func TestPager_EncryptionWithCompression(t *testing.T) {
+	p, err := Open(filepath.Join(t.TempDir(), "db"), Options{
+		Keys:  NewStaticKeys(1, testKey(1)),
+		Codec: FlateCodec{Level: 6},
+	})
+	assert.NoError(t, err)
+	defer p.Close()
+
+	id, _ := p.Allocate()
+	assert.NoError(t, p.Write(id, jsonPage(1)))
+	assert.NoError(t, p.Sync())
+
+	st := p.Stats()
+	assert.Equal(t, 1, st.Compressed)
+	assert.Equal(t, int64(SectorSize), st.StoredBytes)
+	data, err := p.Read(id)
+	assert.NoError(t, err)
+	assert.Equal(t, jsonPage(1), data[:len(jsonPage(1))])
+}
+
+func TestPager_KeysDifferPerFile(t *testing.T) {
+	// the same page written with the same lsn and key to two files, or to a
+	// file created again, must not be sealed with the same nonce and key
+	keys := NewStaticKeys(1, testKey(1))
+	dir := t.TempDir()
+	var images [][]byte
+	for _, name := range []string{"a", "b", "b"} {
+		path := filepath.Join(dir, name)
+		_ = os.Remove(path)
+		p, err := Open(path, Options{Keys: keys})
+		assert.NoError(t, err)
+		id, _ := p.Allocate()
+		assert.NoError(t, p.Write(id, []byte("top secret")))
+		assert.NoError(t, p.Close())
+		s := p.slots[id]
+		assert.Equal(t, uint64(1), s.lsn)
+
+		raw, _ := os.ReadFile(path)
+		images = append(images, raw[s.start*SectorSize+HeaderSize:][:s.size+sealOverhead])
+	}
+	assert.NotEqual(t, images[0], images[1])
+	assert.NotEqual(t, images[1], images[2])
+}
+
+func TestPager_AuthenticatesHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	keys := NewStaticKeys(1, testKey(1))
+	p, err := Open(path, Options{Keys: keys})
+	assert.NoError(t, err)
+	a, _ := p.Allocate()
+	b, _ := p.Allocate()
+	assert.NoError(t, p.Write(a, []byte("a")))
+	assert.NoError(t, p.Write(b, []byte("b")))
+	assert.NoError(t, p.Close())
+	off := slotOffset(t, path, b)
+
+	// swap in the lsn of another write with a matching checksum, which only
+	// the GCM tag can catch
+	raw, _ := os.ReadFile(path)
+	slot := raw[off : off+SectorSize]
+	binary.LittleEndian.PutUint64(slot[12:], binary.LittleEndian.Uint64(slot[12:])-1)
+	binary.LittleEndian.PutUint32(slot[0:], crc32.Checksum(slot[4:], castagnoli))
+	assert.NoError(t, os.WriteFile(path, raw, 0o644))
+
+	p, err = Open(path, Options{Keys: keys})
+	assert.NoError(t, err)
+	defer p.Close()
+	_, err = p.Read(b)
+	assert.ErrorIs(t, err, common.ErrCorrupt)
+	_, err = p.Read(a)
+	assert.NoError(t, err)
+}
+
+func TestPager_RefusesPlaintext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	keys := NewStaticKeys(1, testKey(1))
+	p, err := Open(path, Options{Keys: keys})
+	assert.NoError(t, err)
+	id, _ := p.Allocate()
+	assert.NoError(t, p.Write(id, []byte("secret")))
+	assert.NoError(t, p.Close())
+	off := slotOffset(t, path, id)
+
+	// swap in a plaintext page, which needs no key to forge
+	raw, _ := os.ReadFile(path)
+	copy(raw[off:], encodePage(pageHeader{id: id, lsn: pageLSN(raw[off:])}, []byte("forged"), nil))
+	assert.NoError(t, os.WriteFile(path, raw, 0o644))
+
+	p, err = Open(path, Options{Keys: keys})
+	assert.NoError(t, err)
+	_, err = p.Read(id)
+	assert.ErrorIs(t, err, common.ErrCorrupt)
+	assert.NoError(t, p.Close())
+
+	// unless plaintext pages are expected, while a file is being encrypted;
+	// writes encrypt them
+	p, err = Open(path, Options{Keys: keys, AllowPlaintext: true})
+	assert.NoError(t, err)
+	data, err := p.Read(id)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("forged"), data[:6])
+	assert.NoError(t, p.Write(id, data))
+	assert.NoError(t, p.Sync())
+	assert.Equal(t, map[uint32]int{1: 1}, p.Stats().Keys)
+	assert.NoError(t, p.Close())
+}
+
+func TestPager_KeyRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	keys := NewStaticKeys(1, testKey(1))
+	p, err := Open(path, Options{Keys: keys})
+	assert.NoError(t, err)
+	for range 3 {
+		id, _ := p.Allocate()
+		assert.NoError(t, p.Write(id, []byte("v1")))
+	}
+	assert.NoError(t, p.Sync())
+
+	// pages move to the new key as they are written
+	assert.NoError(t, keys.Rotate(2, testKey(2)))
+	assert.NoError(t, p.Write(1, []byte("v2")))
+	assert.NoError(t, p.Sync())
+	assert.Equal(t, map[uint32]int{1: 2, 2: 1}, p.Stats().Keys)
+	assert.NoError(t, p.Close())
+
+	p, err = Open(path, Options{Keys: keys})
+	assert.NoError(t, err)
+	defer p.Close()
+	data, err := p.Read(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v2"), data[:2])
+	data, err = p.Read(3)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v1"), data[:2])
+
+	// a provider that lost the old key can't read the pages still using it
+	p.enc = NewEncryptor(NewStaticKeys(2, testKey(2)), p.salt)
+	_, err = p.Read(1)
+	assert.NoError(t, err)
+	_, err = p.Read(3)
+	assert.ErrorContains(t, err, "no key with id 1")
+}
+
+func TestStaticKeys_RotateKeepsIDs(t *testing.T) {
+	keys := NewStaticKeys(1, testKey(1))
+	assert.NoError(t, keys.Rotate(2, testKey(2)))
+
+	// an id can't be given another key, pages written with it name it
+	assert.Error(t, keys.Rotate(1, testKey(3)))
+	id, key, err := keys.CurrentKey()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), id)
+	assert.Equal(t, testKey(2), key)
+
+	// going back to a known key is fine
+	assert.NoError(t, keys.Rotate(1, testKey(1)))
+	id, _, _ = keys.CurrentKey()
+	assert.Equal(t, uint32(1), id)
+}
+
+func TestPager_EncryptedPageLimit(t *testing.T) {
+	p, err := Open(filepath.Join(t.TempDir(), "data.db"), Options{Keys: NewStaticKeys(1, testKey(1))})
+	assert.NoError(t, err)
+
+	// the page id is part of the nonce, so it has to fit in 32 bits
+	p.pages = uint64(maxEncryptedPage)
+	id, err := p.Allocate()
+	assert.NoError(t, err)
+	assert.Equal(t, maxEncryptedPage, id)
+	_, err = p.Allocate()
+	assert.Error(t, err)
+	assert.Equal(t, uint64(maxEncryptedPage), p.PageCount())
+
+	// nor can pages of a file grown before encryption was turned on be written
+	p.pages++
+	assert.Error(t, p.Write(maxEncryptedPage+1, []byte("v")))
+	assert.NoError(t, p.Write(id, []byte("v")))
+
+	// without writing the pages out at 16 TiB
+	clear(p.dirty)
+	p.dirtyHeader = false
+	assert.NoError(t, p.Close())
+}
+
+func TestEncryptor_Records(t *testing.T) {
+	keys := NewStaticKeys(1, testKey(1))
+	e := NewEncryptor(keys, make([]byte, SaltSize))
+
+	sealed, err := e.SealRecord(7, []byte("put k v"))
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(sealed, []byte("put k v")))
+
+	assert.NoError(t, keys.Rotate(2, testKey(2)))
+	record, err := e.OpenRecord(7, sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("put k v"), record)
+
+	_, err = e.OpenRecord(8, sealed)
+	assert.ErrorIs(t, err, common.ErrCorrupt)
+	sealed[len(sealed)-1] ^= 1
+	_, err = e.OpenRecord(7, sealed)
+	assert.ErrorIs(t, err, common.ErrCorrupt)
+	_, err = e.OpenRecord(7, sealed[:5])
+	assert.ErrorIs(t, err, common.ErrCorrupt)
+}
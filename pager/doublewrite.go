@@ -0,0 +1,101 @@
+package pager
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+
+	"storage-engine/vfs"
+)
+
+// The double-write buffer holds full images of what a Sync is about to write
+// in place: slots, and the zeros that clear the space it releases. They reach
+// disk before the in-place writes start, so a slot torn by a crash can be
+// rewritten from its image on the next Open.
+//
+//	magic | u32 write count | u32 CRC32C of the rest | (u64 offset | u32 length | image)...
+const (
+	dwbMagic      = "btdwb003"
+	dwbHeaderSize = len(dwbMagic) + 8
+)
+
+// slotWrite is an image, a slot or zeros, and the file offset it goes to.
+type slotWrite struct {
+	off   int64
+	image []byte
+}
+
+// writeDoubleWrite replaces the contents of f with writes and syncs it.
+func writeDoubleWrite(f vfs.File, writes []slotWrite) error {
+	buf := make([]byte, dwbHeaderSize)
+	copy(buf, dwbMagic)
+	binary.LittleEndian.PutUint32(buf[len(dwbMagic):], uint32(len(writes)))
+	for _, w := range writes {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(w.off))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(w.image)))
+		buf = append(buf, w.image...)
+	}
+	crc := crc32.Checksum(buf[dwbHeaderSize:], castagnoli)
+	binary.LittleEndian.PutUint32(buf[len(dwbMagic)+4:], crc)
+
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(buf, 0); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// readDoubleWrite returns the writes in f, or nil if it is empty or was
+// torn itself, in which case the crash hit before any in-place write and the
+// main file is intact.
+func readDoubleWrite(f vfs.File) ([]slotWrite, error) {
+	size, err := f.Size()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if _, err := f.ReadAt(buf, 0); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if len(buf) < dwbHeaderSize || string(buf[:len(dwbMagic)]) != dwbMagic {
+		return nil, nil
+	}
+	count := int(binary.LittleEndian.Uint32(buf[len(dwbMagic):]))
+	crc := binary.LittleEndian.Uint32(buf[len(dwbMagic)+4:])
+	body := buf[dwbHeaderSize:]
+	if crc32.Checksum(body, castagnoli) != crc {
+		return nil, nil
+	}
+
+	// the images were checked as a whole, one that doesn't fit means the
+	// buffer was written by something else
+	writes := make([]slotWrite, 0, count)
+	for range count {
+		if len(body) < 12 {
+			return nil, nil
+		}
+		off := int64(binary.LittleEndian.Uint64(body))
+		size := int(binary.LittleEndian.Uint32(body[8:]))
+		if len(body)-12 < size {
+			return nil, nil
+		}
+		writes = append(writes, slotWrite{off: off, image: body[12 : 12+size]})
+		body = body[12+size:]
+	}
+	if len(body) != 0 {
+		return nil, nil
+	}
+	return writes, nil
+}
+
+// clearDoubleWrite empties f once the slots it protects are safely in place.
+func clearDoubleWrite(f vfs.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	return f.Sync()
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package pager
+
+import (
+	"errors"
+
+	"storage-engine/vfs"
+)
+
+func mmapFile(f vfs.File, size int) ([]byte, error) {
+	return nil, errors.New("mmap is not supported on this platform")
+}
+
+func munmap(b []byte) error {
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package pager
+
+import (
+	"errors"
+	"syscall"
+
+	"storage-engine/vfs"
+)
+
+// mmapFile maps the first size bytes of f, which has to be a file of the OS.
+func mmapFile(f vfs.File, size int) ([]byte, error) {
+	fd, ok := f.(interface{ Fd() uintptr })
+	if !ok {
+		return nil, errors.New("mmap needs a file of the OS file system")
+	}
+	return syscall.Mmap(int(fd.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(b []byte) error {
+	return syscall.Munmap(b)
+}
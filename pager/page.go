@@ -0,0 +1,156 @@
+package pager
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"storage-engine/common"
+)
+
+// PageSize is the logical size of every page. Pages are stored in slots of
+// one or more sectors, a compressed page taking only as many as it needs.
+const (
+	PageSize   = 4096
+	SectorSize = 512
+	maxSectors = PageSize / SectorSize
+)
+
+// Every slot starts with a header protecting the rest of it:
+//
+//	0  checksum  CRC32C of bytes 4 to the end of the slot
+//	4  page id   so a page written to the wrong place is caught
+//	12 lsn       sequence number of the write that produced the page
+//	20 codec     id of the codec the body is compressed with, 0 for none
+//	21 sectors   size of the slot
+//	22 length    bytes of body after the header
+//	24 key id    id of the key the body is encrypted with, 0 for none
+//	28 size      bytes of payload, once the body is decrypted and decompressed
+//	30 reserved
+//	32 body
+//
+// An encrypted body is sealed with AES-GCM using bytes 4 to 32 as additional
+// data, so the header can't be changed without the page failing to decrypt.
+// Room for the GCM tag is kept whether pages are encrypted or not, so every
+// pager has the same PayloadSize.
+const (
+	HeaderSize  = 32
+	PayloadSize = PageSize - HeaderSize - sealOverhead
+)
+
+// PageID identifies a page. Page 0 holds the file header, so data pages
+// start at 1.
+type PageID uint64
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type pageHeader struct {
+	id      PageID
+	lsn     uint64
+	codec   byte
+	sectors int
+	length  int
+	keyID   uint32
+	size    int
+}
+
+// slotSectors returns the number of sectors a slot holding a body of n bytes
+// takes.
+func slotSectors(n int) int {
+	return (HeaderSize + n + SectorSize - 1) / SectorSize
+}
+
+// encodePage returns the on-disk slot of page h.id, whose body is body
+// compressed with h.codec, from a payload of h.size bytes. Uncompressed bodies
+// are the payload, whatever h.size says. With aead the body is encrypted,
+// h.keyID naming the key.
+func encodePage(h pageHeader, body []byte, aead cipher.AEAD) []byte {
+	common.Assert(len(body) <= PayloadSize,
+		"body of %d bytes doesn't fit in a page", len(body))
+	common.Assert((aead != nil) == (h.keyID != 0), "key id %d doesn't match the cipher", h.keyID)
+
+	if h.codec == 0 {
+		h.size = len(body)
+	}
+	h.length = len(body)
+	if aead != nil {
+		h.length += sealOverhead
+	}
+	h.sectors = slotSectors(h.length)
+
+	page := make([]byte, h.sectors*SectorSize)
+	binary.LittleEndian.PutUint64(page[4:], uint64(h.id))
+	binary.LittleEndian.PutUint64(page[12:], h.lsn)
+	page[20] = h.codec
+	page[21] = byte(h.sectors)
+	binary.LittleEndian.PutUint16(page[22:], uint16(h.length))
+	binary.LittleEndian.PutUint32(page[24:], h.keyID)
+	binary.LittleEndian.PutUint16(page[28:], uint16(h.size))
+	if aead != nil {
+		aead.Seal(page[HeaderSize:HeaderSize], nonce(h.id, h.lsn), body, page[4:HeaderSize])
+	} else {
+		copy(page[HeaderSize:], body)
+	}
+	binary.LittleEndian.PutUint32(page[0:], crc32.Checksum(page[4:], castagnoli))
+	return page
+}
+
+// unsealPage decrypts the body of page, already checked by decodePage.
+func unsealPage(h pageHeader, page []byte, aead cipher.AEAD) ([]byte, error) {
+	body, err := aead.Open(nil, nonce(h.id, h.lsn), page[HeaderSize:HeaderSize+h.length], page[4:HeaderSize])
+	if err != nil {
+		return nil, corruptPage(h.id, "can't decrypt: %v", err)
+	}
+	return body, nil
+}
+
+// decodePage checks the slot of page id and returns its header and body. A
+// torn write or bit rot shows up as a checksum mismatch.
+func decodePage(id PageID, page []byte) (pageHeader, []byte, error) {
+	h, err := parseHeader(id, page)
+	if err != nil {
+		return h, nil, err
+	}
+	if h.id != id {
+		return h, nil, corruptPage(id, "page holds page %d", h.id)
+	}
+	return h, page[HeaderSize : HeaderSize+h.length], nil
+}
+
+// parseHeader checks the checksum of the slot at the start of page, which may
+// run past the end of the slot. Errors are reported against page id.
+func parseHeader(id PageID, page []byte) (pageHeader, error) {
+	if len(page) < SectorSize {
+		return pageHeader{}, corruptPage(id, "short page")
+	}
+
+	h := pageHeader{
+		id:      PageID(binary.LittleEndian.Uint64(page[4:])),
+		lsn:     binary.LittleEndian.Uint64(page[12:]),
+		codec:   page[20],
+		sectors: int(page[21]),
+		length:  int(binary.LittleEndian.Uint16(page[22:])),
+		keyID:   binary.LittleEndian.Uint32(page[24:]),
+		size:    int(binary.LittleEndian.Uint16(page[28:])),
+	}
+	if h.sectors < 1 || h.sectors > maxSectors || h.sectors*SectorSize > len(page) {
+		return h, corruptPage(id, "bad slot size")
+	}
+	if binary.LittleEndian.Uint32(page[0:]) != crc32.Checksum(page[4:h.sectors*SectorSize], castagnoli) {
+		return h, corruptPage(id, "checksum mismatch")
+	}
+	if HeaderSize+h.length > h.sectors*SectorSize {
+		return h, corruptPage(id, "body overflows its slot")
+	}
+	return h, nil
+}
+
+// pageLSN returns the lsn in the header of page, without checking it.
+func pageLSN(page []byte) uint64 {
+	return binary.LittleEndian.Uint64(page[12:])
+}
+
+func corruptPage(id PageID, format string, v ...any) error {
+	return &common.CorruptionError{PageID: uint64(id), Reason: fmt.Sprintf(format, v...)}
+}
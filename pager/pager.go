@@ -0,0 +1,730 @@
+// Package pager stores pages in a file, with a checksum in every page so bit
+// rot and torn writes are caught on read, and optional compression.
+package pager
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+
+	"storage-engine/common"
+	"storage-engine/vfs"
+)
+
+// The file starts with a one sector header page, page 0:
+//
+//	magic | u32 page size | u64 page count | u64 lsn lease | salt
+//
+// The salt is random, picked when the file is created, and makes the keys of
+// encrypted pages different from those of any other file: see Encryptor.
+//
+// followed by the slots of the data pages in no particular order. Where each
+// page lives isn't stored anywhere; Open finds the slots by scanning the file,
+// the copy with the highest lsn winning when a page was moved. Space a Sync
+// gives up, the slot a page moved out of or the tail of one that shrank, is
+// zeroed by that Sync, so between slots there is nothing but zeros and what a
+// crash left of a slot being written: see scan.
+const fileMagic = "btpager5"
+
+// lsnLease is how many lsns are reserved in the file header at a time. Open
+// continues after the reserved ones, so an lsn is never handed out twice even
+// if the page written with it was torn and can't be found. Encrypted pages
+// rely on this, their nonces are made of page id and lsn.
+const lsnLease = 1 << 16
+
+// Options configures a Pager.
+type Options struct {
+	// DoubleWrite writes every slot through a double-write buffer next to
+	// the file (path + ".dwb") before writing it in place, so pages torn by
+	// a crash are repaired on the next Open. It also makes each Sync atomic,
+	// at the cost of writing every page twice. Without it a crash during
+	// Sync can leave a page torn, reported as corrupt when read, or at the
+	// version before the Sync. A page is never read back at a version older
+	// than that.
+	DoubleWrite bool
+	// Codec compresses pages on write, nil stores them as they are. Pages
+	// are only kept compressed when that saves at least a sector.
+	Codec Codec
+	// Mmap maps the file read-only into memory, LMDB style, so reads don't
+	// go through read calls and View can hand out pages without copying
+	// them. Writes still go through the write buffer and are written to the
+	// file on Sync, never through the mapping; the mapping sees them as it
+	// is backed by the same page cache. It covers up to twice the size of
+	// the file, and is redone, doubling again, when the file outgrows it.
+	Mmap bool
+	// Keys turns on AES-GCM encryption of every page written, with the
+	// current key of the provider. After a key rotation pages are
+	// re-encrypted with the new key as they are written; until then they
+	// are read with their old key. Nil leaves pages unencrypted, and pages
+	// that are encrypted can't be read.
+	Keys KeyProvider
+	// AllowPlaintext lets a pager with Keys read pages that aren't
+	// encrypted, to encrypt an existing file lazily as its pages are
+	// rewritten. Otherwise they are reported as corrupt: nothing
+	// authenticates them, so anyone able to write the file could swap in a
+	// page of their own.
+	AllowPlaintext bool
+	// FS is the file system the page file and the double-write buffer are
+	// in, nil for the one of the OS. Mmap needs the OS one.
+	FS vfs.FS
+}
+
+// slot is where a page is stored, in sectors.
+type slot struct {
+	start   int64
+	sectors int
+	codec   byte
+	keyID   uint32
+	lsn     uint64
+	size    int // bytes of payload
+	packed  int // bytes of payload as the codec left it
+}
+
+type extent struct {
+	start   int64
+	sectors int
+}
+
+// Pager reads and writes the pages of a single file. Writes are buffered
+// until Sync. It is safe for concurrent use.
+type Pager struct {
+	mu    sync.Mutex
+	f     vfs.File
+	dwb   vfs.File // nil without DoubleWrite
+	codec Codec
+	enc   *Encryptor // nil without Keys
+	plain bool       // see AllowPlaintext
+
+	pages  uint64 // including the header page
+	synced uint64 // pages as of the last Sync
+	lsn    uint64 // lsn of the last page written
+	lease  uint64 // lsns up to lease are reserved in the file header
+	salt   []byte
+	slots  map[PageID]slot
+	free   []extent
+	end    int64 // sectors in the file
+
+	mmap     bool
+	mapped   []byte   // the file and room to grow, with Mmap
+	unmapped [][]byte // earlier mappings, unmapped on Close
+
+	dirty       map[PageID][]byte
+	dirtyHeader bool
+	closed      bool
+}
+
+// Open opens the page file at path, creating it if needed. With DoubleWrite
+// any slots left in the double-write buffer by a crash are written back first.
+func Open(path string, opts Options) (*Pager, error) {
+	if opts.FS == nil {
+		opts.FS = vfs.Default
+	}
+	f, err := opts.FS.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Pager{
+		f:     f,
+		codec: opts.Codec,
+		mmap:  opts.Mmap,
+		plain: opts.AllowPlaintext,
+		slots: make(map[PageID]slot),
+		dirty: make(map[PageID][]byte),
+	}
+	if err := p.open(path, opts); err != nil {
+		_ = p.closeFiles()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Pager) open(path string, opts Options) error {
+	if opts.DoubleWrite {
+		dwb, err := opts.FS.Open(path + ".dwb")
+		if err != nil {
+			return err
+		}
+		p.dwb = dwb
+		if err := p.recover(); err != nil {
+			return err
+		}
+	}
+
+	size, err := p.f.Size()
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		p.pages, p.synced, p.end = 1, 1, 1
+		p.salt = make([]byte, SaltSize)
+		if _, err := rand.Read(p.salt); err != nil {
+			return err
+		}
+		if _, err := p.f.WriteAt(p.fileHeader(1), 0); err != nil {
+			return err
+		}
+		if err := p.f.Sync(); err != nil {
+			return err
+		}
+		p.setKeys(opts.Keys)
+		return p.mapFile()
+	}
+
+	if err := p.readFileHeader(); err != nil {
+		return err
+	}
+	p.setKeys(opts.Keys)
+	// a torn extension of the file leaves a partial last slot, which fails
+	// its checksum like any other torn slot
+	p.end = (size + SectorSize - 1) / SectorSize
+	if err := p.scan(); err != nil {
+		return err
+	}
+	p.lsn = max(p.lsn, p.lease)
+	return p.mapFile()
+}
+
+func (p *Pager) setKeys(keys KeyProvider) {
+	if keys != nil {
+		p.enc = NewEncryptor(keys, p.salt)
+	}
+}
+
+func (p *Pager) mapFile() error {
+	if !p.mmap {
+		return nil
+	}
+	return p.remap()
+}
+
+// recover writes back the slots in the double-write buffer.
+func (p *Pager) recover() error {
+	writes, err := readDoubleWrite(p.dwb)
+	if err != nil {
+		return err
+	}
+	for _, w := range writes {
+		if _, err := p.f.WriteAt(w.image, w.off); err != nil {
+			return err
+		}
+	}
+	if len(writes) > 0 {
+		if err := p.f.Sync(); err != nil {
+			return err
+		}
+	}
+	return clearDoubleWrite(p.dwb)
+}
+
+// fileHeader returns the image of the header page for a file of pages pages.
+func (p *Pager) fileHeader(pages uint64) []byte {
+	body := []byte(fileMagic)
+	body = binary.LittleEndian.AppendUint32(body, PageSize)
+	body = binary.LittleEndian.AppendUint64(body, pages)
+	body = binary.LittleEndian.AppendUint64(body, p.lease)
+	body = append(body, p.salt...)
+	return encodePage(pageHeader{lsn: p.lsn}, body, nil)
+}
+
+func (p *Pager) readFileHeader() error {
+	page := make([]byte, SectorSize)
+	if _, err := p.f.ReadAt(page, 0); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	_, body, err := decodePage(0, page)
+	if err != nil || len(body) != len(fileMagic)+20+SaltSize || !bytes.HasPrefix(body, []byte(fileMagic)) {
+		return common.Corruptf("not a page file")
+	}
+	if size := binary.LittleEndian.Uint32(body[len(fileMagic):]); size != PageSize {
+		return fmt.Errorf("page file has %d byte pages, expected %d", size, PageSize)
+	}
+	p.pages = binary.LittleEndian.Uint64(body[len(fileMagic)+4:])
+	p.lease = binary.LittleEndian.Uint64(body[len(fileMagic)+12:])
+	p.salt = bytes.Clone(body[len(fileMagic)+20:])
+	if p.pages == 0 {
+		return common.Corruptf("not a page file")
+	}
+	p.synced = p.pages
+	return nil
+}
+
+// scan finds the current slot of every page and the free space between them,
+// and picks up the lsn counter so lsns keep increasing across reopens.
+//
+// A slot that fails its checksum but still names a page and an lsn up to the
+// lease was torn, or rotted, while holding that page; stale page bodies can't
+// pass for one, as released space is zeroed. If its lsn is above the one of
+// every intact copy, those copies are older than the one that was lost, so
+// the page is left without a slot and reads report it as corrupt, rather
+// than going back to an image the pager had already replaced.
+func (p *Pager) scan() error {
+	torn := make(map[PageID]uint64)
+	buf := make([]byte, PageSize)
+	for s := int64(1); s < p.end; {
+		n, err := p.f.ReadAt(buf, s*SectorSize)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		h, err := parseHeader(0, buf[:n])
+		if err != nil {
+			// zeroed free space or a torn slot
+			if h.id != 0 && uint64(h.id) < p.pages && h.lsn != 0 && h.lsn <= p.lease {
+				torn[h.id] = max(torn[h.id], h.lsn)
+			}
+			s++
+			continue
+		}
+
+		p.lsn = max(p.lsn, h.lsn)
+		cur, ok := p.slots[h.id]
+		if h.id != 0 && uint64(h.id) < p.pages && (!ok || h.lsn > cur.lsn) {
+			p.slots[h.id] = slotOf(s, h)
+		}
+		s += int64(h.sectors)
+	}
+	for id, lsn := range torn {
+		if cur, ok := p.slots[id]; ok && lsn > cur.lsn {
+			delete(p.slots, id)
+		}
+	}
+
+	used := slices.SortedFunc(maps.Values(p.slots), func(a, b slot) int {
+		return cmp.Compare(a.start, b.start)
+	})
+	next := int64(1)
+	for _, s := range used {
+		if s.start > next {
+			p.free = append(p.free, extent{start: next, sectors: int(s.start - next)})
+		}
+		next = s.start + int64(s.sectors)
+	}
+	if p.end > next {
+		p.free = append(p.free, extent{start: next, sectors: int(p.end - next)})
+	}
+	return nil
+}
+
+// PageCount returns the number of data pages, allocated ones included.
+func (p *Pager) PageCount() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pages - 1
+}
+
+// Allocate adds a zeroed page and returns its id.
+func (p *Pager) Allocate() (PageID, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return 0, common.ErrClosed
+	}
+	id := PageID(p.pages)
+	if err := p.checkEncryptable(id); err != nil {
+		return 0, err
+	}
+	p.pages++
+	p.dirtyHeader = true
+	p.dirty[id] = nil
+	return id, nil
+}
+
+// Read returns a copy of the payload of page id, PayloadSize bytes long. A
+// page that fails its checksum gives a *common.CorruptionError.
+func (p *Pager) Read(id PageID) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.check(id); err != nil {
+		return nil, err
+	}
+	body, err := p.view(id)
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, PayloadSize)
+	copy(payload, body)
+	return payload, nil
+}
+
+// View returns the payload of page id as it was written, without padding it
+// to PayloadSize, checked like Read does. With Mmap, pages stored as they are come straight from the
+// mapping without being copied; compressed and encrypted pages are decoded
+// into a new slice. The slice must not be modified, and is only valid until
+// page id is written again and synced: a page rewritten in place changes
+// under it, and the space of a page that moved is zeroed. It is never
+// valid after Close.
+func (p *Pager) View(id PageID) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.check(id); err != nil {
+		return nil, err
+	}
+	return p.view(id)
+}
+
+func (p *Pager) view(id PageID) ([]byte, error) {
+	if data, ok := p.dirty[id]; ok {
+		return data, nil
+	}
+
+	s, ok := p.slots[id]
+	if !ok {
+		return nil, corruptPage(id, "no intact copy of the page")
+	}
+	page, err := p.readSlot(s)
+	if err != nil {
+		return nil, err
+	}
+	h, body, err := decodePage(id, page)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.keyID == 0 && p.enc != nil && !p.plain {
+		return nil, corruptPage(id, "page isn't encrypted")
+	}
+	if h.keyID != 0 {
+		if p.enc == nil {
+			return nil, fmt.Errorf("page %d is encrypted and no keys were given", id)
+		}
+		aead, err := p.enc.aead(h.keyID, nil)
+		if err != nil {
+			return nil, err
+		}
+		if body, err = unsealPage(h, page, aead); err != nil {
+			return nil, err
+		}
+	}
+	if h.codec != 0 {
+		c, ok := LookupCodec(h.codec)
+		if !ok {
+			return nil, fmt.Errorf("page %d is compressed with unknown codec %d", id, h.codec)
+		}
+		if body, err = c.Decompress(nil, body); err != nil {
+			return nil, corruptPage(id, "can't decompress: %v", err)
+		}
+	}
+	if len(body) != h.size || h.size > PayloadSize {
+		return nil, corruptPage(id, "payload of %d bytes, the header says %d", len(body), h.size)
+	}
+	return body, nil
+}
+
+// readSlot returns the bytes of slot s, from the mapping if it covers them.
+func (p *Pager) readSlot(s slot) ([]byte, error) {
+	off, size := s.start*SectorSize, int64(s.sectors)*SectorSize
+	if off+size <= int64(len(p.mapped)) {
+		return p.mapped[off : off+size], nil
+	}
+
+	page := make([]byte, size)
+	n, err := p.f.ReadAt(page, off)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return page[:n], nil
+}
+
+// remap maps the file again once it outgrew the mapping, with room for it to
+// double. Older mappings stay until Close, so views into them remain
+// readable; doubling keeps them to a logarithmic number, taking less address
+// space together than the last one.
+func (p *Pager) remap() error {
+	size, err := p.f.Size()
+	if err != nil {
+		return err
+	}
+	if size <= int64(len(p.mapped)) {
+		return nil
+	}
+
+	// the part past the end of the file is never read, no slot is there
+	mapped, err := mmapFile(p.f, int(max(size, 2*int64(len(p.mapped)))))
+	if err != nil {
+		return err
+	}
+	if p.mapped != nil {
+		p.unmapped = append(p.unmapped, p.mapped)
+	}
+	p.mapped = mapped
+	return nil
+}
+
+// Write replaces the payload of page id with data, zero padded to
+// PayloadSize. It reaches the file on the next Sync.
+func (p *Pager) Write(id PageID, data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.check(id); err != nil {
+		return err
+	}
+	if err := p.checkEncryptable(id); err != nil {
+		return err
+	}
+	if len(data) > PayloadSize {
+		return fmt.Errorf("payload of %d bytes doesn't fit in a page", len(data))
+	}
+	p.dirty[id] = bytes.Clone(data)
+	return nil
+}
+
+func (p *Pager) check(id PageID) error {
+	if p.closed {
+		return common.ErrClosed
+	}
+	if id == 0 || uint64(id) >= p.pages {
+		return fmt.Errorf("page %d out of range", id)
+	}
+	return nil
+}
+
+// checkEncryptable refuses a page id too large to encrypt, when pages are.
+func (p *Pager) checkEncryptable(id PageID) error {
+	if p.enc != nil && id > maxEncryptedPage {
+		return fmt.Errorf("page %d is past the %d pages an encrypted file can hold", id, maxEncryptedPage)
+	}
+	return nil
+}
+
+// Sync writes all buffered pages to the file and waits for them to be durable.
+func (p *Pager) Sync() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return common.ErrClosed
+	}
+	return p.sync()
+}
+
+func (p *Pager) sync() error {
+	if len(p.dirty) == 0 && !p.dirtyHeader {
+		return nil
+	}
+
+	if p.lsn+uint64(len(p.dirty)) > p.lease {
+		// the new lease has to be durable before any page uses it
+		p.lease = p.lsn + uint64(len(p.dirty)) + lsnLease
+		if err := p.writeSlots([]slotWrite{{off: 0, image: p.fileHeader(p.synced)}}); err != nil {
+			return err
+		}
+	}
+
+	var (
+		writes   []slotWrite
+		slots    = make(map[PageID]slot, len(p.dirty))
+		released []extent
+	)
+	for _, id := range slices.Sorted(maps.Keys(p.dirty)) {
+		p.lsn++
+		image, s, err := p.encode(id, p.lsn, p.dirty[id])
+		if err != nil {
+			return err
+		}
+
+		old, ok := p.slots[id]
+		switch {
+		case ok && s.sectors <= old.sectors:
+			s.start = old.start
+			if tail := old.sectors - s.sectors; tail > 0 {
+				released = append(released, extent{start: old.start + int64(s.sectors), sectors: tail})
+			}
+		case ok:
+			released = append(released, extent{start: old.start, sectors: old.sectors})
+			s.start = p.allocSlot(s.sectors)
+		default:
+			s.start = p.allocSlot(s.sectors)
+		}
+		slots[id] = s
+		writes = append(writes, slotWrite{off: s.start * SectorSize, image: image})
+	}
+	if p.dirtyHeader {
+		writes = append(writes, slotWrite{off: 0, image: p.fileHeader(p.pages)})
+	}
+	// what was left in the released space would read as torn slots in scan
+	for _, e := range released {
+		writes = append(writes, slotWrite{off: e.start * SectorSize, image: make([]byte, e.sectors*SectorSize)})
+	}
+	if err := p.writeSlots(writes); err != nil {
+		return err
+	}
+	if err := p.mapFile(); err != nil {
+		return err
+	}
+
+	// space given up by this Sync is only reused once the pages that moved
+	// out of it are durable elsewhere
+	maps.Copy(p.slots, slots)
+	p.free = append(p.free, released...)
+	clear(p.dirty)
+	p.dirtyHeader = false
+	p.synced = p.pages
+	return nil
+}
+
+// writeSlots writes slots in place, through the double-write buffer if there
+// is one, and waits for them to be durable.
+func (p *Pager) writeSlots(writes []slotWrite) error {
+	if p.dwb != nil {
+		if err := writeDoubleWrite(p.dwb, writes); err != nil {
+			return err
+		}
+	}
+	for _, w := range writes {
+		if _, err := p.f.WriteAt(w.image, w.off); err != nil {
+			return err
+		}
+	}
+	if err := p.f.Sync(); err != nil {
+		return err
+	}
+	if p.dwb != nil {
+		return clearDoubleWrite(p.dwb)
+	}
+	return nil
+}
+
+// encode returns the slot image of page id, compressed if that makes the
+// slot smaller and encrypted if there are keys, and the slot it needs.
+func (p *Pager) encode(id PageID, lsn uint64, payload []byte) ([]byte, slot, error) {
+	h := pageHeader{id: id, lsn: lsn, size: len(payload)}
+	var aead cipher.AEAD
+	overhead := 0
+	if p.enc != nil {
+		var err error
+		if h.keyID, aead, err = p.enc.current(); err != nil {
+			return nil, slot{}, err
+		}
+		overhead = sealOverhead
+	}
+
+	body := payload
+	if p.codec != nil && len(body) > 0 {
+		compressed, err := p.codec.Compress(nil, body)
+		if err != nil {
+			return nil, slot{}, err
+		}
+		if slotSectors(len(compressed)+overhead) < slotSectors(len(body)+overhead) {
+			body, h.codec = compressed, p.codec.ID()
+		}
+	}
+
+	image := encodePage(h, body, aead)
+	h.sectors, h.length = len(image)/SectorSize, len(body)
+	if aead != nil {
+		h.length += sealOverhead
+	}
+	return image, slotOf(0, h), nil
+}
+
+// slotOf returns the slot starting at sector start that holds the page of
+// header h.
+func slotOf(start int64, h pageHeader) slot {
+	s := slot{start: start, sectors: h.sectors, codec: h.codec, keyID: h.keyID, lsn: h.lsn, size: h.size, packed: h.length}
+	if h.keyID != 0 {
+		s.packed -= sealOverhead
+	}
+	return s
+}
+
+// allocSlot finds room for a slot of n sectors, first fit, growing the file
+// if nothing fits.
+func (p *Pager) allocSlot(n int) int64 {
+	for i, e := range p.free {
+		if e.sectors < n {
+			continue
+		}
+		if e.sectors == n {
+			p.free = slices.Delete(p.free, i, i+1)
+		} else {
+			p.free[i] = extent{start: e.start + int64(n), sectors: e.sectors - n}
+		}
+		return e.start
+	}
+	start := p.end
+	p.end += int64(n)
+	return start
+}
+
+// Stats describes how much space the pages take on disk.
+type Stats struct {
+	Pages        int            // pages stored in the file
+	Compressed   int            // pages stored compressed
+	Keys         map[uint32]int // encrypted pages by key id
+	LogicalBytes int64          // payload bytes of the stored pages
+	PackedBytes  int64          // the same once compressed, if they were
+	StoredBytes  int64          // size of their slots
+	FreeBytes    int64          // space between slots waiting to be reused
+}
+
+// Ratio returns the compression ratio, logical over packed bytes, 1 when
+// nothing was compressed.
+func (s Stats) Ratio() float64 {
+	if s.PackedBytes == 0 {
+		return 1
+	}
+	return float64(s.LogicalBytes) / float64(s.PackedBytes)
+}
+
+// Stats reports space usage as of the last Sync.
+func (p *Pager) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st := Stats{Keys: make(map[uint32]int)}
+	for _, s := range p.slots {
+		st.Pages++
+		if s.codec != 0 {
+			st.Compressed++
+		}
+		if s.keyID != 0 {
+			st.Keys[s.keyID]++
+		}
+		st.LogicalBytes += int64(s.size)
+		st.PackedBytes += int64(s.packed)
+		st.StoredBytes += int64(s.sectors) * SectorSize
+	}
+	for _, e := range p.free {
+		st.FreeBytes += int64(e.sectors) * SectorSize
+	}
+	return st
+}
+
+// Close syncs buffered pages and closes the file.
+func (p *Pager) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return common.ErrClosed
+	}
+	err := p.sync()
+	p.closed = true
+	return errors.Join(err, p.closeFiles())
+}
+
+func (p *Pager) closeFiles() error {
+	var err error
+	for _, m := range append(p.unmapped, p.mapped) {
+		if m != nil {
+			err = errors.Join(err, munmap(m))
+		}
+	}
+	err = errors.Join(err, p.f.Close())
+	if p.dwb != nil {
+		err = errors.Join(err, p.dwb.Close())
+	}
+	return err
+}
@@ -0,0 +1,566 @@
+package pager
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"storage-engine/common"
+	"storage-engine/vfs"
+)
+
+// writePages fills n new pages of a fresh pager at path and closes it.
+func writePages(t *testing.T, path string, opts Options, n int) {
+	p, err := Open(path, opts)
+	assert.NoError(t, err)
+	for i := range n {
+		id, err := p.Allocate()
+		assert.NoError(t, err)
+		assert.NoError(t, p.Write(id, []byte{byte(i), 'p'}))
+	}
+	assert.NoError(t, p.Close())
+}
+
+func TestPager_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	writePages(t, path, Options{}, 3)
+
+	p, err := Open(path, Options{})
+	assert.NoError(t, err)
+	defer p.Close()
+	assert.Equal(t, uint64(3), p.PageCount())
+
+	for i := range 3 {
+		data, err := p.Read(PageID(i + 1))
+		assert.NoError(t, err)
+		assert.Len(t, data, PayloadSize)
+		assert.Equal(t, []byte{byte(i), 'p'}, data[:2])
+	}
+
+	_, err = p.Read(0)
+	assert.Error(t, err)
+	_, err = p.Read(4)
+	assert.Error(t, err)
+	assert.Error(t, p.Write(1, make([]byte, PayloadSize+1)))
+}
+
+func TestPager_ReadsUnsyncedWrites(t *testing.T) {
+	p, err := Open(filepath.Join(t.TempDir(), "db"), Options{})
+	assert.NoError(t, err)
+	defer p.Close()
+
+	id, _ := p.Allocate()
+	data, err := p.Read(id)
+	assert.NoError(t, err)
+	assert.Equal(t, make([]byte, PayloadSize), data)
+
+	assert.NoError(t, p.Write(id, []byte("x")))
+	data, _ = p.Read(id)
+	assert.Equal(t, byte('x'), data[0])
+}
+
+func TestPager_LSNKeepsIncreasing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	writePages(t, path, Options{}, 2)
+
+	// lsns continue after the lease taken by the first Sync, even if the
+	// pages written with the last ones were lost
+	p, err := Open(path, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2+lsnLease), p.lsn)
+	assert.NoError(t, p.Write(1, []byte("y")))
+	assert.NoError(t, p.Close())
+
+	raw, _ := os.ReadFile(path)
+	assert.Equal(t, uint64(3+lsnLease), pageLSN(raw[SectorSize:]))
+}
+
+func TestPager_DetectsBitRot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	writePages(t, path, Options{}, 2)
+
+	raw, _ := os.ReadFile(path)
+	raw[2*SectorSize+100] ^= 0x10
+	assert.NoError(t, os.WriteFile(path, raw, 0o644))
+
+	p, err := Open(path, Options{})
+	assert.NoError(t, err)
+	defer p.Close()
+
+	_, err = p.Read(1)
+	assert.NoError(t, err)
+	_, err = p.Read(2)
+	assert.ErrorIs(t, err, common.ErrCorrupt)
+	var ce *common.CorruptionError
+	assert.True(t, errors.As(err, &ce))
+	assert.Equal(t, uint64(2), ce.PageID)
+}
+
+func TestPager_DetectsMisplacedPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	writePages(t, path, Options{}, 2)
+
+	raw, _ := os.ReadFile(path)
+	copy(raw[2*SectorSize:], raw[SectorSize:2*SectorSize])
+	assert.NoError(t, os.WriteFile(path, raw, 0o644))
+
+	p, err := Open(path, Options{})
+	assert.NoError(t, err)
+	defer p.Close()
+	_, err = p.Read(2)
+	assert.ErrorIs(t, err, common.ErrCorrupt)
+}
+
+func TestPager_NotAPageFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	assert.NoError(t, os.WriteFile(path, []byte("hello"), 0o644))
+	_, err := Open(path, Options{})
+	assert.ErrorIs(t, err, common.ErrCorrupt)
+}
+
+// slotOffset returns the file offset of the slot of page id at path.
+func slotOffset(t *testing.T, path string, id PageID) int64 {
+	p, err := Open(path, Options{})
+	assert.NoError(t, err)
+	defer p.Close()
+	return p.slots[id].start * SectorSize
+}
+
+func TestPager_RepairsTornPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	writePages(t, path, Options{DoubleWrite: true}, 2)
+	off := slotOffset(t, path, 2)
+
+	// crash halfway through writing page 2 in place, after its new image
+	// made it to the double-write buffer
+	raw, _ := os.ReadFile(path)
+	image := encodePage(pageHeader{id: 2, lsn: 10}, []byte("new"), nil)
+	dwb, err := vfs.Default.Open(path + ".dwb")
+	assert.NoError(t, err)
+	assert.NoError(t, writeDoubleWrite(dwb, []slotWrite{{off: off, image: image}}))
+	assert.NoError(t, dwb.Close())
+	copy(raw[off:], image[:SectorSize/2])
+	assert.NoError(t, os.WriteFile(path, raw, 0o644))
+
+	p, err := Open(path, Options{DoubleWrite: true})
+	assert.NoError(t, err)
+	defer p.Close()
+
+	data, err := p.Read(2)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("new"), data[:3])
+
+	info, _ := os.Stat(path + ".dwb")
+	assert.Equal(t, int64(0), info.Size())
+}
+
+func TestPager_TornWriteDoesntRollBack(t *testing.T) {
+	for name, torn := range map[string]func(image []byte) (int, []byte){
+		"new header": func(image []byte) (int, []byte) { return 0, image[:len(image)/2] },
+		"old header": func(image []byte) (int, []byte) { return len(image) / 2, image[len(image)/2:] },
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db")
+			p, err := Open(path, Options{Codec: FlateCodec{Level: 6}})
+			assert.NoError(t, err)
+			id, _ := p.Allocate()
+			assert.NoError(t, p.Write(id, jsonPage(1)))
+			assert.NoError(t, p.Sync())
+			// the page moves, leaving its first image intact behind it
+			random := make([]byte, PayloadSize)
+			_, _ = rand.Read(random)
+			assert.NoError(t, p.Write(id, random))
+			assert.NoError(t, p.Sync())
+			s := p.slots[id]
+			assert.NoError(t, p.Close())
+
+			// crash halfway through rewriting it in place, without a
+			// double-write buffer to repair it
+			image := encodePage(pageHeader{id: id, lsn: s.lsn + 1}, bytes.Repeat([]byte("x"), PayloadSize), nil)
+			raw, _ := os.ReadFile(path)
+			at, part := torn(image)
+			copy(raw[s.start*SectorSize+int64(at):], part)
+			assert.NoError(t, os.WriteFile(path, raw, 0o644))
+
+			p, err = Open(path, Options{})
+			assert.NoError(t, err)
+			defer p.Close()
+			_, err = p.Read(id)
+			assert.ErrorIs(t, err, common.ErrCorrupt)
+		})
+	}
+}
+
+func TestPager_StaleDataIsntTorn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	p, err := Open(path, Options{})
+	assert.NoError(t, err)
+	a, _ := p.Allocate()
+	b, _ := p.Allocate()
+	assert.NoError(t, p.Write(b, []byte("b")))
+	assert.NoError(t, p.Sync())
+
+	// data of a that looks like the header of a newer copy of b, where the
+	// second sector of its slot starts
+	data := make([]byte, 4000)
+	binary.LittleEndian.PutUint64(data[SectorSize-HeaderSize+4:], uint64(b))
+	binary.LittleEndian.PutUint64(data[SectorSize-HeaderSize+12:], 1000)
+	assert.NoError(t, p.Write(a, data))
+	assert.NoError(t, p.Sync())
+	// a shrinks in place, giving up the rest of its slot
+	assert.NoError(t, p.Write(a, []byte("small")))
+	assert.NoError(t, p.Close())
+
+	p, err = Open(path, Options{})
+	assert.NoError(t, err)
+	defer p.Close()
+	got, err := p.Read(b)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("b"), got[:1])
+	got, err = p.Read(a)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("small"), got[:5])
+}
+
+func TestPager_IgnoresTornDoubleWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	writePages(t, path, Options{DoubleWrite: true}, 2)
+	off := slotOffset(t, path, 2)
+
+	// crash while writing the buffer, before anything was written in place
+	dwb, err := vfs.Default.Open(path + ".dwb")
+	assert.NoError(t, err)
+	image := encodePage(pageHeader{id: 2, lsn: 10}, []byte("new"), nil)
+	assert.NoError(t, writeDoubleWrite(dwb, []slotWrite{{off: off, image: image}}))
+	assert.NoError(t, dwb.Truncate(int64(dwbHeaderSize+12+SectorSize/2)))
+	assert.NoError(t, dwb.Close())
+
+	p, err := Open(path, Options{DoubleWrite: true})
+	assert.NoError(t, err)
+	defer p.Close()
+
+	data, err := p.Read(2)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 'p'}, data[:2])
+}
+
+func TestPager_Closed(t *testing.T) {
+	p, err := Open(filepath.Join(t.TempDir(), "db"), Options{DoubleWrite: true})
+	assert.NoError(t, err)
+	id, _ := p.Allocate()
+	assert.NoError(t, p.Close())
+
+	_, err = p.Read(id)
+	assert.ErrorIs(t, err, common.ErrClosed)
+	assert.ErrorIs(t, p.Write(id, nil), common.ErrClosed)
+	assert.ErrorIs(t, p.Sync(), common.ErrClosed)
+	assert.ErrorIs(t, p.Close(), common.ErrClosed)
+}
+
+// jsonPage returns a compressible payload filling most of a page.
+func jsonPage(i int) []byte {
+	var sb strings.Builder
+	for sb.Len() < PayloadSize-100 {
+		fmt.Fprintf(&sb, `{"id":%d,"name":"user","active":true},`, i)
+	}
+	return []byte(sb.String())
+}
+
+func TestPager_Compression(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	opts := Options{Codec: FlateCodec{Level: 6}, DoubleWrite: true}
+	p, err := Open(path, opts)
+	assert.NoError(t, err)
+	for i := range 10 {
+		id, _ := p.Allocate()
+		assert.NoError(t, p.Write(id, jsonPage(i)))
+	}
+	assert.NoError(t, p.Sync())
+
+	st := p.Stats()
+	assert.Equal(t, 10, st.Pages)
+	assert.Equal(t, 10, st.Compressed)
+	assert.Equal(t, int64(10*len(jsonPage(0))), st.LogicalBytes)
+	assert.Equal(t, int64(10*SectorSize), st.StoredBytes)
+	assert.Greater(t, st.Ratio(), 8.0)
+	assert.NoError(t, p.Close())
+
+	// reading doesn't need the codec configured
+	p, err = Open(path, Options{})
+	assert.NoError(t, err)
+	defer p.Close()
+	for i := range 10 {
+		data, err := p.Read(PageID(i + 1))
+		assert.NoError(t, err)
+		assert.Equal(t, jsonPage(i), data[:len(jsonPage(i))])
+	}
+	assert.Equal(t, 10, p.Stats().Compressed)
+}
+
+func TestPager_IncompressiblePage(t *testing.T) {
+	p, err := Open(filepath.Join(t.TempDir(), "db"), Options{Codec: FlateCodec{Level: 6}})
+	assert.NoError(t, err)
+	defer p.Close()
+
+	data := make([]byte, PayloadSize)
+	_, _ = rand.Read(data)
+	id, _ := p.Allocate()
+	assert.NoError(t, p.Write(id, data))
+	assert.NoError(t, p.Sync())
+
+	st := p.Stats()
+	assert.Equal(t, 0, st.Compressed)
+	assert.Equal(t, int64(PageSize), st.StoredBytes)
+	assert.Equal(t, 1.0, st.Ratio())
+	got, err := p.Read(id)
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+}
+
+func TestPager_MovesGrowingPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	p, err := Open(path, Options{Codec: FlateCodec{Level: 6}})
+	assert.NoError(t, err)
+	a, _ := p.Allocate()
+	b, _ := p.Allocate()
+	assert.NoError(t, p.Write(a, jsonPage(1)))
+	assert.NoError(t, p.Write(b, jsonPage(2)))
+	assert.NoError(t, p.Sync())
+
+	// a no longer fits its one sector slot and moves to the end
+	random := make([]byte, PayloadSize)
+	_, _ = rand.Read(random)
+	assert.NoError(t, p.Write(a, random))
+	assert.NoError(t, p.Sync())
+	assert.Equal(t, int64(SectorSize), p.Stats().FreeBytes)
+
+	// the space it left is reused
+	c, _ := p.Allocate()
+	assert.NoError(t, p.Write(c, jsonPage(3)))
+	assert.NoError(t, p.Sync())
+	assert.Equal(t, int64(0), p.Stats().FreeBytes)
+	assert.NoError(t, p.Close())
+
+	p, err = Open(path, Options{})
+	assert.NoError(t, err)
+	defer p.Close()
+	got, err := p.Read(a)
+	assert.NoError(t, err)
+	assert.Equal(t, random, got)
+	got, _ = p.Read(c)
+	assert.Equal(t, jsonPage(3), got[:len(jsonPage(3))])
+	assert.Equal(t, int64(0), p.Stats().FreeBytes)
+}
+
+func TestPager_UnknownCodec(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	writePages(t, path, Options{}, 1)
+	off := slotOffset(t, path, 1)
+
+	raw, _ := os.ReadFile(path)
+	copy(raw[off:], encodePage(pageHeader{id: 1, lsn: 5, codec: 200}, []byte("x"), nil))
+	assert.NoError(t, os.WriteFile(path, raw, 0o644))
+
+	p, err := Open(path, Options{})
+	assert.NoError(t, err)
+	defer p.Close()
+	_, err = p.Read(1)
+	assert.ErrorContains(t, err, "unknown codec 200")
+}
+
+func TestPager_Mmap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	writePages(t, path, Options{}, 2)
+
+	p, err := Open(path, Options{Mmap: true, Codec: FlateCodec{Level: 6}})
+	assert.NoError(t, err)
+	defer p.Close()
+
+	// stored as is, so the view points into the mapping
+	view, err := p.View(2)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 'p'}, view)
+	mapped := p.mapped
+	assert.Same(t, &mapped[2*SectorSize+HeaderSize], &view[0])
+
+	// growing the file maps it again, the old view stays readable
+	id, _ := p.Allocate()
+	assert.NoError(t, p.Write(id, jsonPage(1)))
+	assert.NoError(t, p.Sync())
+	assert.Equal(t, 2*len(mapped), len(p.mapped))
+	assert.Len(t, p.unmapped, 1)
+	assert.Equal(t, []byte{1, 'p'}, view)
+
+	// the new mapping has room to grow into
+	mapped = p.mapped
+	other, _ := p.Allocate()
+	assert.NoError(t, p.Write(other, jsonPage(2)))
+	assert.NoError(t, p.Sync())
+	assert.Len(t, p.unmapped, 1)
+	assert.Equal(t, len(mapped), len(p.mapped))
+	view, err = p.View(other)
+	assert.NoError(t, err)
+	assert.Equal(t, jsonPage(2), view)
+
+	// compressed pages are decoded
+	view, err = p.View(id)
+	assert.NoError(t, err)
+	assert.Equal(t, jsonPage(1), view)
+
+	// writes made through the file show up in the mapping, and are checked
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	assert.NoError(t, err)
+	_, err = f.WriteAt([]byte{0xff}, SectorSize+HeaderSize)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	_, err = p.View(1)
+	assert.ErrorIs(t, err, common.ErrCorrupt)
+	_, err = p.Read(1)
+	assert.ErrorIs(t, err, common.ErrCorrupt)
+}
+
+func TestPager_ViewIsExact(t *testing.T) {
+	p, err := Open(filepath.Join(t.TempDir(), "db"), Options{Mmap: true})
+	assert.NoError(t, err)
+	defer p.Close()
+
+	// trailing zeros are part of the payload, whether synced or not
+	a, _ := p.Allocate()
+	b, _ := p.Allocate()
+	assert.NoError(t, p.Write(a, []byte{'a', 0, 0}))
+	for range 2 {
+		view, err := p.View(a)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{'a', 0, 0}, view)
+		view, err = p.View(b)
+		assert.NoError(t, err)
+		assert.Empty(t, view)
+		assert.NoError(t, p.Sync())
+	}
+
+	// stored as they are, pages count as uncompressed
+	st := p.Stats()
+	assert.Equal(t, int64(3), st.LogicalBytes)
+	assert.Equal(t, 1.0, st.Ratio())
+}
+
+func TestPager_MmapNeedsOSFile(t *testing.T) {
+	_, err := Open("db", Options{Mmap: true, FS: vfs.NewMem()})
+	assert.ErrorContains(t, err, "mmap")
+}
+
+func TestPager_CrashDuringSync(t *testing.T) {
+	const pages = 5
+	newPage := func(i int) []byte {
+		// bigger than the old pages, so they move to new slots
+		return bytes.Repeat([]byte{byte(i), 'n'}, 300)
+	}
+
+	// crash at every operation of opening the pager and syncing new versions
+	// of all pages, until a run gets through without crashing
+	for n := 1; ; n++ {
+		mem := vfs.NewMem()
+		writePages(t, "db", Options{FS: mem, DoubleWrite: true}, pages)
+
+		ffs := vfs.NewFaulty(mem)
+		ffs.CrashAt(n)
+		synced := false
+		p, err := Open("db", Options{FS: ffs, DoubleWrite: true})
+		if err == nil {
+			for i := range pages {
+				assert.NoError(t, p.Write(PageID(i+1), newPage(i)))
+			}
+			synced = p.Sync() == nil
+			_ = p.Close()
+		}
+		if !ffs.Crashed() {
+			assert.True(t, synced)
+			break
+		}
+
+		// the double-write buffer makes the Sync atomic: all pages are old or
+		// all are new
+		p, err = Open("db", Options{FS: mem, DoubleWrite: true})
+		assert.NoError(t, err, "crash at op %d", n)
+		var old, fresh int
+		for i := range pages {
+			data, err := p.Read(PageID(i + 1))
+			assert.NoError(t, err, "crash at op %d", n)
+			switch {
+			case bytes.Equal(data[:2], []byte{byte(i), 'p'}):
+				old++
+			case bytes.Equal(data[:600], newPage(i)):
+				fresh++
+			}
+		}
+		assert.True(t, old == pages && !synced || fresh == pages,
+			"crash at op %d: %d old and %d new pages, synced %v", n, old, fresh, synced)
+		assert.NoError(t, p.Close())
+	}
+}
+
+func TestPager_RepairsTornPageAfterRelease(t *testing.T) {
+	big := func(i int) []byte { return bytes.Repeat([]byte{byte(i), 'b'}, 1000) }
+	small := func(i int) []byte { return []byte{byte(i), 's'} }
+
+	// crash at every operation of a Sync that shrinks every page, and so
+	// zeroes their released tails, until one leaves its writes in the buffer:
+	// a slot and a zeroed tail per page
+	var (
+		mem    *vfs.MemFS
+		writes []slotWrite
+	)
+	for n := 1; len(writes) != 6; n++ {
+		mem = vfs.NewMem()
+		p, err := Open("db", Options{FS: mem, DoubleWrite: true})
+		assert.NoError(t, err)
+		for i := range 3 {
+			id, _ := p.Allocate()
+			assert.NoError(t, p.Write(id, big(i)))
+		}
+		assert.NoError(t, p.Close())
+
+		ffs := vfs.NewFaulty(mem)
+		p, err = Open("db", Options{FS: ffs, DoubleWrite: true})
+		assert.NoError(t, err)
+		for i := range 3 {
+			assert.NoError(t, p.Write(PageID(i+1), small(i)))
+		}
+		ffs.CrashAt(ffs.Ops() + n)
+		if p.Sync() == nil {
+			t.Fatal("no crash left the writes of the Sync in the buffer")
+		}
+		_ = p.Close()
+
+		dwb, err := mem.Open("db.dwb")
+		assert.NoError(t, err)
+		writes, err = readDoubleWrite(dwb)
+		assert.NoError(t, err)
+		assert.NoError(t, dwb.Close())
+	}
+
+	// and the first slot got torn on the way to disk regardless
+	f, err := mem.Open("db")
+	assert.NoError(t, err)
+	_, err = f.WriteAt(writes[0].image[:SectorSize/2], writes[0].off)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	p, err := Open("db", Options{FS: mem, DoubleWrite: true})
+	assert.NoError(t, err)
+	defer p.Close()
+	for i := range 3 {
+		data, err := p.Read(PageID(i + 1))
+		assert.NoError(t, err)
+		assert.Equal(t, small(i), data[:2])
+	}
+	assert.Equal(t, int64(3*3*SectorSize), p.Stats().FreeBytes)
+}
@@ -0,0 +1,344 @@
+// Package tuple encodes ordered tuples of values into keys whose byte order
+// matches the tuple order, following the FoundationDB tuple layer format.
+//
+// Elements are compared component-wise. Each element starts with a type code,
+// so elements of different types sort by type: nil < []byte < string <
+// nested Tuple < integers < float32 < float64 < false < true < UUID.
+// A tuple sorts before any longer tuple it is a prefix of.
+package tuple
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+
+	"storage-engine/keyenc"
+)
+
+// Tuple is an ordered list of elements. Supported element types are nil,
+// []byte, string, Tuple, every signed and unsigned integer type, float32,
+// float64, bool and UUID.
+type Tuple []any
+
+// UUID is a 16 byte identifier, stored as-is.
+type UUID [16]byte
+
+const (
+	nilCode     = 0x00
+	bytesCode   = 0x01
+	stringCode  = 0x02
+	nestedCode  = 0x05
+	intZeroCode = 0x14
+	float32Code = 0x20
+	float64Code = 0x21
+	falseCode   = 0x26
+	trueCode    = 0x27
+	uuidCode    = 0x30
+
+	// inside strings, byte strings and nested tuples a literal 0x00 is
+	// written as 0x00 0xff so it can't be mistaken for the terminator
+	escapeCode = 0xff
+)
+
+// Pack encodes t into a key.
+func Pack(t Tuple) ([]byte, error) {
+	return appendTuple(nil, t, false)
+}
+
+// MustPack is Pack for tuples known to be valid, such as ones built from
+// literals. It panics on unsupported element types.
+func MustPack(elems ...any) []byte {
+	b, err := Pack(Tuple(elems))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Pack encodes t into a key.
+func (t Tuple) Pack() ([]byte, error) {
+	return Pack(t)
+}
+
+// Unpack decodes a key produced by Pack. Integers come back as int64, or as
+// uint64 when they don't fit in an int64.
+func Unpack(b []byte) (Tuple, error) {
+	t, rest, err := decodeTuple(b, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("tuple: %d trailing bytes", len(rest))
+	}
+	return t, nil
+}
+
+// Range returns the key range [begin, end) covering every tuple that starts
+// with prefix and has at least one more element. The keys can be used directly
+// as bplustree.IterOptions bounds, or begin passed to BTree.Seek.
+func Range(prefix Tuple) (begin, end []byte, err error) {
+	p, err := Pack(prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	begin = append(bytes.Clone(p), 0x00)
+	end = append(p, 0xff)
+	return begin, end, nil
+}
+
+// PrefixRange returns the key range [begin, end) covering every key that
+// starts with prefix. end is nil when no such bound exists, i.e. when prefix
+// is empty or all 0xff bytes, which IterOptions treats as unbounded.
+func PrefixRange(prefix []byte) (begin, end []byte) {
+	return bytes.Clone(prefix), keyenc.PrefixSuccessor(prefix)
+}
+
+func appendTuple(dst []byte, t Tuple, nested bool) ([]byte, error) {
+	var err error
+	for _, e := range t {
+		if dst, err = appendElem(dst, e, nested); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+func appendElem(dst []byte, e any, nested bool) ([]byte, error) {
+	switch v := e.(type) {
+	case nil:
+		dst = append(dst, nilCode)
+		if nested {
+			dst = append(dst, escapeCode)
+		}
+	case []byte:
+		dst = appendEscaped(append(dst, bytesCode), v)
+	case string:
+		dst = appendEscaped(append(dst, stringCode), []byte(v))
+	case Tuple:
+		var err error
+		dst = append(dst, nestedCode)
+		if dst, err = appendTuple(dst, v, true); err != nil {
+			return nil, err
+		}
+		dst = append(dst, 0x00)
+	case int:
+		dst = appendInt(dst, int64(v))
+	case int8:
+		dst = appendInt(dst, int64(v))
+	case int16:
+		dst = appendInt(dst, int64(v))
+	case int32:
+		dst = appendInt(dst, int64(v))
+	case int64:
+		dst = appendInt(dst, v)
+	case uint:
+		dst = appendUint(dst, uint64(v))
+	case uint8:
+		dst = appendUint(dst, uint64(v))
+	case uint16:
+		dst = appendUint(dst, uint64(v))
+	case uint32:
+		dst = appendUint(dst, uint64(v))
+	case uint64:
+		dst = appendUint(dst, v)
+	case float32:
+		dst = binary.BigEndian.AppendUint32(append(dst, float32Code), orderFloat32(math.Float32bits(v)))
+	case float64:
+		dst = binary.BigEndian.AppendUint64(append(dst, float64Code), orderFloat64(math.Float64bits(v)))
+	case bool:
+		if v {
+			dst = append(dst, trueCode)
+		} else {
+			dst = append(dst, falseCode)
+		}
+	case UUID:
+		dst = append(append(dst, uuidCode), v[:]...)
+	default:
+		return nil, fmt.Errorf("tuple: unsupported element type %T", e)
+	}
+	return dst, nil
+}
+
+func appendEscaped(dst, b []byte) []byte {
+	for _, c := range b {
+		dst = append(dst, c)
+		if c == 0x00 {
+			dst = append(dst, escapeCode)
+		}
+	}
+	return append(dst, 0x00)
+}
+
+// Integers are stored in as few bytes as possible. The type code carries the
+// length, 0x14+n for positive and 0x14-n for negative numbers, and negative
+// magnitudes are one's complemented so larger magnitudes sort first.
+func appendInt(dst []byte, v int64) []byte {
+	if v >= 0 {
+		return appendUint(dst, uint64(v))
+	}
+
+	mag := uint64(-v) // wraps to 1<<63 for MinInt64, which is what we want
+	n := byteLen(mag)
+	dst = append(dst, byte(intZeroCode-n))
+	return appendBigEndian(dst, ^mag, n)
+}
+
+func appendUint(dst []byte, v uint64) []byte {
+	n := byteLen(v)
+	dst = append(dst, byte(intZeroCode+n))
+	return appendBigEndian(dst, v, n)
+}
+
+func byteLen(v uint64) int {
+	return (bits.Len64(v) + 7) / 8
+}
+
+func appendBigEndian(dst []byte, v uint64, n int) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(dst, buf[8-n:]...)
+}
+
+func orderFloat32(b uint32) uint32 {
+	if b&(1<<31) != 0 {
+		return ^b
+	}
+	return b | 1<<31
+}
+
+func orderFloat64(b uint64) uint64 {
+	if b&(1<<63) != 0 {
+		return ^b
+	}
+	return b | 1<<63
+}
+
+func unorderFloat32(b uint32) uint32 {
+	if b&(1<<31) != 0 {
+		return b &^ (1 << 31)
+	}
+	return ^b
+}
+
+func unorderFloat64(b uint64) uint64 {
+	if b&(1<<63) != 0 {
+		return b &^ (1 << 63)
+	}
+	return ^b
+}
+
+func decodeTuple(b []byte, nested bool) (Tuple, []byte, error) {
+	t := Tuple{}
+	for len(b) > 0 {
+		if nested && b[0] == 0x00 {
+			if len(b) > 1 && b[1] == escapeCode {
+				t = append(t, nil)
+				b = b[2:]
+				continue
+			}
+			// end of the nested tuple
+			return t, b[1:], nil
+		}
+
+		e, rest, err := decodeElem(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		t = append(t, e)
+		b = rest
+	}
+
+	if nested {
+		return nil, nil, fmt.Errorf("tuple: unterminated nested tuple")
+	}
+	return t, b, nil
+}
+
+func decodeElem(b []byte) (any, []byte, error) {
+	code := b[0]
+	b = b[1:]
+
+	switch {
+	case code == nilCode:
+		return nil, b, nil
+	case code == bytesCode:
+		return decodeEscaped(b)
+	case code == stringCode:
+		s, rest, err := decodeEscaped(b)
+		return string(s), rest, err
+	case code == nestedCode:
+		return decodeTuple(b, true)
+	case code >= intZeroCode-8 && code <= intZeroCode+8:
+		return decodeInt(b, int(code)-intZeroCode)
+	case code == float32Code:
+		if len(b) < 4 {
+			return nil, nil, fmt.Errorf("tuple: truncated float32")
+		}
+		return math.Float32frombits(unorderFloat32(binary.BigEndian.Uint32(b))), b[4:], nil
+	case code == float64Code:
+		if len(b) < 8 {
+			return nil, nil, fmt.Errorf("tuple: truncated float64")
+		}
+		return math.Float64frombits(unorderFloat64(binary.BigEndian.Uint64(b))), b[8:], nil
+	case code == falseCode:
+		return false, b, nil
+	case code == trueCode:
+		return true, b, nil
+	case code == uuidCode:
+		if len(b) < 16 {
+			return nil, nil, fmt.Errorf("tuple: truncated uuid")
+		}
+		return UUID(b[:16]), b[16:], nil
+	}
+
+	return nil, nil, fmt.Errorf("tuple: unknown type code 0x%02x", code)
+}
+
+func decodeEscaped(b []byte) ([]byte, []byte, error) {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] != 0x00 {
+			out = append(out, b[i])
+			continue
+		}
+		if i+1 < len(b) && b[i+1] == escapeCode {
+			out = append(out, 0x00)
+			i++
+			continue
+		}
+		return out, b[i+1:], nil
+	}
+	return nil, nil, fmt.Errorf("tuple: unterminated string")
+}
+
+func decodeInt(b []byte, n int) (any, []byte, error) {
+	neg := n < 0
+	if neg {
+		n = -n
+	}
+	if len(b) < n {
+		return nil, nil, fmt.Errorf("tuple: truncated integer")
+	}
+
+	var buf [8]byte
+	copy(buf[8-n:], b[:n])
+	v := binary.BigEndian.Uint64(buf[:])
+	rest := b[n:]
+
+	if neg {
+		mag := ^v
+		if n < 8 {
+			mag &= 1<<(8*n) - 1
+		}
+		if mag > 1<<63 {
+			return nil, nil, fmt.Errorf("tuple: integer out of int64 range")
+		}
+		return int64(-mag), rest, nil
+	}
+	if v > math.MaxInt64 {
+		return v, rest, nil
+	}
+	return int64(v), rest, nil
+}
@@ -0,0 +1,192 @@
+package tuple
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	bplustree "storage-engine/bplus-tree"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackUnpack_RoundTrip(t *testing.T) {
+	id := UUID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	in := Tuple{
+		nil,
+		[]byte{0x00, 0x01, 0xff},
+		"tenant\x00a",
+		int64(0),
+		int64(-1),
+		int64(255),
+		int64(-256),
+		int64(math.MinInt64),
+		int64(math.MaxInt64),
+		uint64(math.MaxUint64),
+		float32(1.5),
+		-2.25,
+		true,
+		false,
+		id,
+		Tuple{nil, "nested", Tuple{int64(7)}},
+	}
+
+	b, err := Pack(in)
+	assert.NoError(t, err)
+
+	out, err := Unpack(b)
+	assert.NoError(t, err)
+	assert.Equal(t, in, out)
+}
+
+func TestPack_IntTypesNormalised(t *testing.T) {
+	out, err := Unpack(MustPack(int8(-3), uint16(9), 42))
+	assert.NoError(t, err)
+	assert.Equal(t, Tuple{int64(-3), int64(9), int64(42)}, out)
+}
+
+func TestPack_UnsupportedType(t *testing.T) {
+	_, err := Pack(Tuple{struct{}{}})
+	assert.Error(t, err)
+
+	assert.Panics(t, func() { MustPack(map[string]int{}) })
+}
+
+func TestPack_Order(t *testing.T) {
+	// every tuple must sort strictly before the next one
+	ordered := []Tuple{
+		{},
+		{nil},
+		{[]byte("a")},
+		{[]byte("a"), nil},
+		{[]byte("a\x00")},
+		{[]byte("b")},
+		{""},
+		{"a"},
+		{"a", int64(1)},
+		{"a\x00"},
+		{"ab"},
+		{Tuple{}},
+		{Tuple{nil}},
+		{Tuple{int64(1)}},
+		{Tuple{int64(1), nil}},
+		{Tuple{int64(2)}},
+		{int64(math.MinInt64)},
+		{int64(-65536)},
+		{int64(-256)},
+		{int64(-255)},
+		{int64(-1)},
+		{int64(0)},
+		{int64(1)},
+		{int64(255)},
+		{int64(256)},
+		{int64(math.MaxInt64)},
+		{uint64(math.MaxUint64)},
+		{float32(-1)},
+		{float32(1)},
+		{math.Inf(-1)},
+		{-1.0},
+		{0.0},
+		{1.0},
+		{math.Inf(1)},
+		{false},
+		{true},
+		{UUID{}},
+		{UUID{0xff}},
+	}
+
+	for i := 1; i < len(ordered); i++ {
+		a := MustPack(ordered[i-1]...)
+		b := MustPack(ordered[i]...)
+		assert.Equal(t, -1, bytes.Compare(a, b), "%v should sort before %v", ordered[i-1], ordered[i])
+	}
+}
+
+func TestPack_CompositeKeyOrder(t *testing.T) {
+	a := MustPack(int64(1), int64(100), "z")
+	b := MustPack(int64(1), int64(200), "a")
+	c := MustPack(int64(2), int64(0), "a")
+
+	assert.Equal(t, -1, bytes.Compare(a, b))
+	assert.Equal(t, -1, bytes.Compare(b, c))
+}
+
+func TestUnpack_Malformed(t *testing.T) {
+	for _, b := range [][]byte{
+		{stringCode, 'a'},                // missing terminator
+		{nestedCode, intZeroCode + 1, 1}, // unterminated nested tuple
+		{intZeroCode + 2, 1},             // truncated integer
+		{float64Code, 0, 0},              // truncated float
+		{uuidCode, 1, 2},                 // truncated uuid
+		{0x40},                           // unknown code
+	} {
+		_, err := Unpack(b)
+		assert.Error(t, err, "% x", b)
+	}
+}
+
+func TestRange(t *testing.T) {
+	begin, end, err := Range(Tuple{"tenant-1"})
+	assert.NoError(t, err)
+
+	inside := [][]byte{
+		MustPack("tenant-1", int64(0)),
+		MustPack("tenant-1", int64(math.MaxInt64), "x"),
+		MustPack("tenant-1", nil),
+		MustPack("tenant-1", UUID{0xff}),
+	}
+	outside := [][]byte{
+		MustPack("tenant-1"),
+		MustPack("tenant-0", int64(5)),
+		MustPack("tenant-10", int64(5)),
+		MustPack("tenant-2"),
+	}
+
+	for _, k := range inside {
+		assert.True(t, bytes.Compare(k, begin) >= 0 && bytes.Compare(k, end) < 0, "% x should be in range", k)
+	}
+	for _, k := range outside {
+		assert.False(t, bytes.Compare(k, begin) >= 0 && bytes.Compare(k, end) < 0, "% x should be out of range", k)
+	}
+}
+
+func TestPrefixRange(t *testing.T) {
+	begin, end := PrefixRange([]byte("ab"))
+	assert.Equal(t, []byte("ab"), begin)
+	assert.Equal(t, []byte("ac"), end)
+
+	_, end = PrefixRange([]byte{0x01, 0xff, 0xff})
+	assert.Equal(t, []byte{0x02}, end)
+
+	_, end = PrefixRange([]byte{0xff})
+	assert.Nil(t, end)
+}
+
+func TestRange_WithBTreeSeek(t *testing.T) {
+	b := bplustree.New(3)
+
+	for tenant := range int64(3) {
+		for ts := range int64(10) {
+			key := MustPack(tenant, ts, "id")
+			assert.NoError(t, b.Insert(key, MustPack(tenant, ts)))
+		}
+	}
+
+	begin, end, err := Range(Tuple{int64(1)})
+	assert.NoError(t, err)
+
+	it, err := b.Seek(begin)
+	assert.NoError(t, err)
+
+	got := make([]Tuple, 0)
+	for ; it.Valid() && bytes.Compare(it.Key(), end) < 0; it.Next() {
+		tup, err := Unpack(it.Key())
+		assert.NoError(t, err)
+		got = append(got, tup)
+	}
+
+	assert.Len(t, got, 10)
+	for i, tup := range got {
+		assert.Equal(t, Tuple{int64(1), int64(i), "id"}, tup)
+	}
+}
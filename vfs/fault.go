@@ -0,0 +1,258 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"sync"
+)
+
+// ErrCrashed is returned by every operation of a FaultFS after its simulated
+// crash.
+var ErrCrashed = errors.New("simulated crash")
+
+// FaultFS wraps a file system and injects faults into it: failing syncs,
+// short writes and crashes. A crash undoes every write and truncate that
+// wasn't synced yet, in every file, as if the machine lost power; the wrapped
+// file system is left as it would be found after a reboot, and the FaultFS
+// fails every operation from then on. Creating and renaming files is durable
+// at once.
+//
+// Operations are counted so a test can crash at each one in turn: Open,
+// Rename, ReadAt, WriteAt, Sync and Truncate count, Size and Close don't.
+type FaultFS struct {
+	fs FS
+
+	mu          sync.Mutex
+	ops         int
+	crashAt     int  // operation that crashes, 0 for none
+	crashed     bool // set once crashed
+	syncErr     error
+	shortWrites bool
+	unsynced    map[string][]undo // by file name, oldest first
+}
+
+// undo restores a range of a file as it was before a write or truncate that
+// wasn't synced: the file goes back to size, then data is written at off.
+type undo struct {
+	size int64
+	off  int64
+	data []byte
+}
+
+func NewFaulty(fs FS) *FaultFS {
+	return &FaultFS{fs: fs, unsynced: make(map[string][]undo)}
+}
+
+// CrashAt makes operation n, counting from the first one the FaultFS saw,
+// crash instead of running. 0 turns it off.
+func (f *FaultFS) CrashAt(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.crashAt = n
+}
+
+// Crash crashes now, see FaultFS.
+func (f *FaultFS) Crash() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.crash()
+}
+
+// Crashed reports whether the FaultFS has crashed.
+func (f *FaultFS) Crashed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.crashed
+}
+
+// Ops returns the number of operations so far.
+func (f *FaultFS) Ops() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ops
+}
+
+// FailSyncs makes Sync return err without syncing anything, so the writes
+// before it stay undone by a crash. nil turns it off.
+func (f *FaultFS) FailSyncs(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.syncErr = err
+}
+
+// ShortWrites makes WriteAt write only the first half of its buffer and
+// return io.ErrShortWrite.
+func (f *FaultFS) ShortWrites(on bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.shortWrites = on
+}
+
+// op counts an operation and returns ErrCrashed if it is the one to crash or
+// the crash already happened. f.mu must be held.
+func (f *FaultFS) op() error {
+	if f.crashed {
+		return ErrCrashed
+	}
+	f.ops++
+	if f.ops == f.crashAt {
+		if err := f.crash(); err != nil {
+			return errors.Join(ErrCrashed, err)
+		}
+		return ErrCrashed
+	}
+	return nil
+}
+
+// crash undoes the unsynced changes, newest first. f.mu must be held.
+func (f *FaultFS) crash() error {
+	f.crashed = true
+	var errs error
+	for name, undos := range f.unsynced {
+		file, err := f.fs.Open(name)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		for _, u := range slices.Backward(undos) {
+			errs = errors.Join(errs, file.Truncate(u.size))
+			if len(u.data) > 0 {
+				_, err := file.WriteAt(u.data, u.off)
+				errs = errors.Join(errs, err)
+			}
+		}
+		errs = errors.Join(errs, file.Sync(), file.Close())
+	}
+	clear(f.unsynced)
+	return errs
+}
+
+func (f *FaultFS) Open(name string) (File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.op(); err != nil {
+		return nil, err
+	}
+	file, err := f.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{fs: f, f: file, name: name}, nil
+}
+
+func (f *FaultFS) Rename(oldname, newname string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.op(); err != nil {
+		return err
+	}
+	if err := f.fs.Rename(oldname, newname); err != nil {
+		return err
+	}
+	f.unsynced[newname] = f.unsynced[oldname]
+	delete(f.unsynced, oldname)
+	return nil
+}
+
+// faultFile is a file of a FaultFS. Its operations are serialized by the
+// FaultFS, so a crash never races with a write.
+type faultFile struct {
+	fs   *FaultFS
+	f    File
+	name string
+}
+
+func (f *faultFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.fs.op(); err != nil {
+		return 0, err
+	}
+	return f.f.ReadAt(p, off)
+}
+
+func (f *faultFile) WriteAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.fs.op(); err != nil {
+		return 0, err
+	}
+
+	short := f.fs.shortWrites
+	if short {
+		p = p[:len(p)/2]
+	}
+	if err := f.saveRange(off, int64(len(p))); err != nil {
+		return 0, err
+	}
+	n, err := f.f.WriteAt(p, off)
+	if err == nil && short {
+		err = io.ErrShortWrite
+	}
+	return n, err
+}
+
+func (f *faultFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.fs.op(); err != nil {
+		return err
+	}
+	if f.fs.syncErr != nil {
+		return f.fs.syncErr
+	}
+	if err := f.f.Sync(); err != nil {
+		return err
+	}
+	delete(f.fs.unsynced, f.name)
+	return nil
+}
+
+func (f *faultFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.fs.op(); err != nil {
+		return err
+	}
+	if err := f.saveRange(size, -1); err != nil {
+		return err
+	}
+	return f.f.Truncate(size)
+}
+
+// saveRange records how to undo a change of n bytes at off, or of everything
+// from off on if n is negative.
+func (f *faultFile) saveRange(off, n int64) error {
+	size, err := f.f.Size()
+	if err != nil {
+		return err
+	}
+	end := size
+	if n >= 0 {
+		end = min(off+n, size)
+	}
+
+	u := undo{size: size, off: off}
+	if off < end {
+		u.data = make([]byte, end-off)
+		if _, err := f.f.ReadAt(u.data, off); err != nil {
+			return err
+		}
+	}
+	f.fs.unsynced[f.name] = append(f.fs.unsynced[f.name], u)
+	return nil
+}
+
+func (f *faultFile) Size() (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.fs.crashed {
+		return 0, ErrCrashed
+	}
+	return f.f.Size()
+}
+
+func (f *faultFile) Close() error {
+	return f.f.Close()
+}
@@ -0,0 +1,112 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// contents returns what is in the file name of fs.
+func contents(t *testing.T, fs FS, name string) string {
+	f, err := fs.Open(name)
+	assert.NoError(t, err)
+	defer f.Close()
+	size, _ := f.Size()
+	buf := make([]byte, size)
+	_, err = f.ReadAt(buf, 0)
+	assert.True(t, err == nil || errors.Is(err, io.EOF))
+	return string(buf)
+}
+
+func TestFaultFS_CrashDropsUnsyncedWrites(t *testing.T) {
+	for name, fs := range map[string]FS{
+		"mem": NewMem(),
+		"os":  Default,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "f")
+			ffs := NewFaulty(fs)
+			f, err := ffs.Open(path)
+			assert.NoError(t, err)
+
+			_, _ = f.WriteAt([]byte("synced"), 0)
+			assert.NoError(t, f.Sync())
+			_, _ = f.WriteAt([]byte("SYN"), 0)
+			_, _ = f.WriteAt([]byte(" and lost"), 6)
+			assert.NoError(t, f.Truncate(4))
+			assert.Equal(t, "SYNc", contents(t, fs, path))
+
+			assert.NoError(t, ffs.Crash())
+			assert.True(t, ffs.Crashed())
+			assert.Equal(t, "synced", contents(t, fs, path))
+
+			_, err = f.WriteAt([]byte("x"), 0)
+			assert.ErrorIs(t, err, ErrCrashed)
+			_, err = ffs.Open(path)
+			assert.ErrorIs(t, err, ErrCrashed)
+			assert.NoError(t, f.Close())
+		})
+	}
+}
+
+func TestFaultFS_CrashAt(t *testing.T) {
+	m := NewMem()
+	ffs := NewFaulty(m)
+	ffs.CrashAt(4)
+
+	f, _ := ffs.Open("f")                 // 1
+	_, _ = f.WriteAt([]byte("one"), 0)    // 2
+	assert.NoError(t, f.Sync())           // 3
+	_, err := f.WriteAt([]byte("two"), 3) // 4 crashes
+	assert.ErrorIs(t, err, ErrCrashed)
+	assert.ErrorIs(t, f.Sync(), ErrCrashed)
+	assert.Equal(t, 4, ffs.Ops())
+	assert.Equal(t, "one", contents(t, m, "f"))
+}
+
+func TestFaultFS_FailSyncs(t *testing.T) {
+	m := NewMem()
+	ffs := NewFaulty(m)
+	f, _ := ffs.Open("f")
+
+	boom := errors.New("boom")
+	ffs.FailSyncs(boom)
+	_, _ = f.WriteAt([]byte("data"), 0)
+	assert.ErrorIs(t, f.Sync(), boom)
+
+	// the failed sync made nothing durable
+	_ = ffs.Crash()
+	assert.Equal(t, "", contents(t, m, "f"))
+}
+
+func TestFaultFS_ShortWrites(t *testing.T) {
+	m := NewMem()
+	ffs := NewFaulty(m)
+	f, _ := ffs.Open("f")
+
+	ffs.ShortWrites(true)
+	n, err := f.WriteAt([]byte("abcdef"), 0)
+	assert.ErrorIs(t, err, io.ErrShortWrite)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "abc", contents(t, m, "f"))
+
+	ffs.ShortWrites(false)
+	n, err = f.WriteAt([]byte("abcdef"), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+}
+
+func TestFaultFS_Rename(t *testing.T) {
+	m := NewMem()
+	ffs := NewFaulty(m)
+	f, _ := ffs.Open("tmp")
+	_, _ = f.WriteAt([]byte("data"), 0)
+	assert.NoError(t, ffs.Rename("tmp", "final"))
+
+	// the rename is durable, the write to the file wasn't synced
+	_ = ffs.Crash()
+	assert.Equal(t, "", contents(t, m, "final"))
+}
@@ -0,0 +1,150 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"sync"
+
+	"storage-engine/common"
+)
+
+// MemFS is a file system in memory. Nothing is lost unless a FaultFS on top
+// of it crashes, so Sync does nothing. It is safe for concurrent use.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memData
+}
+
+// memData is the contents of a file, shared by every handle open on it, also
+// across renames.
+type memData struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+func NewMem() *MemFS {
+	return &MemFS{files: make(map[string]*memData)}
+}
+
+func (m *MemFS) Open(name string) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.files[name]
+	if !ok {
+		d = &memData{}
+		m.files[name] = d
+	}
+	return &memFile{d: d}, nil
+}
+
+func (m *MemFS) Rename(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.files[oldname]
+	if !ok {
+		return fmt.Errorf("rename %s: %w", oldname, fs.ErrNotExist)
+	}
+	delete(m.files, oldname)
+	m.files[newname] = d
+	return nil
+}
+
+type memFile struct {
+	d      *memData
+	mu     sync.Mutex
+	closed bool
+}
+
+var errNegativeOffset = errors.New("negative offset")
+
+func (f *memFile) usable() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return common.ErrClosed
+	}
+	return nil
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.usable(); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+	f.d.mu.RLock()
+	defer f.d.mu.RUnlock()
+
+	if off >= int64(len(f.d.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.d.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if err := f.usable(); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+	f.d.mu.Lock()
+	defer f.d.mu.Unlock()
+
+	if end := off + int64(len(p)); end > int64(len(f.d.data)) {
+		f.d.data = slices.Grow(f.d.data, int(end)-len(f.d.data))[:end]
+	}
+	return copy(f.d.data[off:], p), nil
+}
+
+func (f *memFile) Sync() error {
+	return f.usable()
+}
+
+func (f *memFile) Truncate(size int64) error {
+	if err := f.usable(); err != nil {
+		return err
+	}
+	if size < 0 {
+		return errNegativeOffset
+	}
+	f.d.mu.Lock()
+	defer f.d.mu.Unlock()
+
+	if size <= int64(len(f.d.data)) {
+		clear(f.d.data[size:])
+		f.d.data = f.d.data[:size]
+		return nil
+	}
+	f.d.data = slices.Grow(f.d.data, int(size)-len(f.d.data))[:size]
+	return nil
+}
+
+func (f *memFile) Size() (int64, error) {
+	if err := f.usable(); err != nil {
+		return 0, err
+	}
+	f.d.mu.RLock()
+	defer f.d.mu.RUnlock()
+	return int64(len(f.d.data)), nil
+}
+
+func (f *memFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return common.ErrClosed
+	}
+	f.closed = true
+	return nil
+}
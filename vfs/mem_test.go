@@ -0,0 +1,66 @@
+package vfs
+
+import (
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"storage-engine/common"
+)
+
+func TestMemFS_ReadWrite(t *testing.T) {
+	m := NewMem()
+	f, err := m.Open("a")
+	assert.NoError(t, err)
+
+	n, err := f.WriteAt([]byte("world"), 6)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	_, _ = f.WriteAt([]byte("hello"), 0)
+	size, _ := f.Size()
+	assert.Equal(t, int64(11), size)
+
+	buf := make([]byte, 16)
+	n, err = f.ReadAt(buf, 0)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, []byte("hello\x00world"), buf[:n])
+	_, err = f.ReadAt(buf, 11)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// shrinking clears the tail, growing again reads zeros
+	assert.NoError(t, f.Truncate(2))
+	assert.NoError(t, f.Truncate(4))
+	n, _ = f.ReadAt(buf, 0)
+	assert.Equal(t, []byte("he\x00\x00"), buf[:n])
+
+	// a second handle sees the same file
+	g, _ := m.Open("a")
+	size, _ = g.Size()
+	assert.Equal(t, int64(4), size)
+
+	assert.NoError(t, f.Close())
+	assert.ErrorIs(t, f.Close(), common.ErrClosed)
+	_, err = f.WriteAt([]byte("x"), 0)
+	assert.ErrorIs(t, err, common.ErrClosed)
+}
+
+func TestMemFS_Rename(t *testing.T) {
+	m := NewMem()
+	f, _ := m.Open("a")
+	_, _ = f.WriteAt([]byte("data"), 0)
+
+	assert.NoError(t, m.Rename("a", "b"))
+	assert.ErrorIs(t, m.Rename("a", "b"), fs.ErrNotExist)
+
+	// the open handle follows the file, and "a" is new and empty
+	_, _ = f.WriteAt([]byte("D"), 0)
+	b, _ := m.Open("b")
+	buf := make([]byte, 4)
+	_, _ = b.ReadAt(buf, 0)
+	assert.Equal(t, []byte("Data"), buf)
+	a, _ := m.Open("a")
+	size, _ := a.Size()
+	assert.Equal(t, int64(0), size)
+}
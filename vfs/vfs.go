@@ -0,0 +1,60 @@
+// Package vfs is the file system the storage layers do their I/O through, so
+// tests can swap the one of the OS for an in-memory one, and inject faults
+// and crashes into either.
+package vfs
+
+import "os"
+
+// FS opens and renames files.
+type FS interface {
+	// Open opens the named file for reading and writing, creating it if it
+	// doesn't exist.
+	Open(name string) (File, error)
+	// Rename renames oldname to newname, replacing newname if it exists.
+	Rename(oldname, newname string) error
+}
+
+// File is an open file. Reads and writes take an offset, so a File can be
+// used from several goroutines at once. Reads past the end return io.EOF, as
+// with os.File.
+type File interface {
+	ReadAt(p []byte, off int64) (int, error)
+	WriteAt(p []byte, off int64) (int, error)
+	// Sync makes the writes so far durable.
+	Sync() error
+	// Truncate changes the size of the file, filling it with zeros if it
+	// grows.
+	Truncate(size int64) error
+	Size() (int64, error)
+	Close() error
+}
+
+// Default is the file system of the OS. Its files also have the Fd method of
+// os.File, for callers that need the descriptor, such as mmap.
+var Default FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) Open(name string) (File, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return osFile{f}, nil
+}
+
+func (osFS) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+type osFile struct {
+	*os.File
+}
+
+func (f osFile) Size() (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}